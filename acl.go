@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"google.golang.org/api/googleapi"
+	storage "google.golang.org/api/storage/v1"
+)
+
+var allowACL = flag.Bool("allow-acl", false, "Let admins see and toggle whether objects are publicly readable.")
+
+const publicEntity = "allUsers"
+
+// ObjectACL is the public access state of an object shown to admins.
+type ObjectACL struct {
+	Public bool
+	// Uniform is set when the bucket uses uniform bucket-level access, which
+	// disables object ACLs.
+	Uniform bool
+}
+
+// IsPublic reports whether the ACL of object grants read access to everyone.
+func IsPublic(object *storage.Object) bool {
+	for _, rule := range object.Acl {
+		if rule.Entity == publicEntity && (rule.Role == "READER" || rule.Role == "OWNER") {
+			return true
+		}
+	}
+	return false
+}
+
+// uniformAccess reports whether the bucket has uniform bucket-level access
+// enabled.
+func (s *Server) uniformAccess() (bool, error) {
+	bucket, err := s.Storage().Buckets.Get(bucketName).Fields("iamConfiguration").Do()
+	if err != nil {
+		return false, err
+	}
+	config := bucket.IamConfiguration
+	return config != nil && config.UniformBucketLevelAccess != nil && config.UniformBucketLevelAccess.Enabled, nil
+}
+
+// ObjectACLFor returns the access state of objectName, or nil when it can't
+// be read.
+func (s *Server) ObjectACLFor(objectName string) *ObjectACL {
+	uniform, err := s.uniformAccess()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"internalError": err,
+		}).Warn("Failed getting bucket access configuration.")
+		return nil
+	}
+	if uniform {
+		return &ObjectACL{Uniform: true}
+	}
+	object, err := s.Storage().Objects.Get(bucketName, StorageName(objectName)).Projection("full").Fields("acl").Do()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"objectName":    objectName,
+			"internalError": err,
+		}).Warn("Failed getting object ACL.")
+		return nil
+	}
+	return &ObjectACL{Public: IsPublic(object)}
+}
+
+// SetPublic grants or revokes public read access to objectName, or only
+// records the intent in dry-run mode.
+func (s *Server) SetPublic(request *http.Request, objectName string, public bool) error {
+	Audit(request, "acl", log.Fields{"objectName": objectName, "public": public})
+	if *dryRun {
+		return nil
+	}
+	if public {
+		rule := &storage.ObjectAccessControl{Entity: publicEntity, Role: "READER"}
+		_, err := s.Storage().ObjectAccessControls.Insert(bucketName, StorageName(objectName), rule).Do()
+		return err
+	}
+	err := s.Storage().ObjectAccessControls.Delete(bucketName, StorageName(objectName), publicEntity).Do()
+	if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == http.StatusNotFound {
+		// Not public to begin with.
+		return nil
+	}
+	return err
+}
+
+type aclRequest struct {
+	Public bool `json:"public"`
+}
+
+type aclResult struct {
+	Name   string `json:"name"`
+	Public bool   `json:"public"`
+	DryRun bool   `json:"dryRun,omitempty"`
+}
+
+// ACLHandler makes the object public or private as asked by a JSON body like
+// {"public": true}.
+func (s *Server) ACLHandler(response http.ResponseWriter, request *http.Request) {
+	if !IsAdmin(request) {
+		writeJSONError(response, http.StatusForbidden, "only admins can change access")
+		return
+	}
+	if !RequireJSON(response, request) {
+		return
+	}
+	objectName := mux.Vars(request)["objectName"]
+	var body aclRequest
+	if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+		writeJSONError(response, http.StatusBadRequest, `expected {"public": true} or {"public": false}`)
+		return
+	}
+	if uniform, err := s.uniformAccess(); err == nil && uniform {
+		writeJSONError(response, http.StatusConflict, "the bucket uses uniform bucket-level access, object ACLs are disabled")
+		return
+	}
+	if err := s.SetPublic(request, objectName, body.Public); err != nil {
+		log.WithFields(log.Fields{
+			"objectName":    objectName,
+			"internalError": err,
+		}).Warn("Failed changing object ACL.")
+		writeJSONError(response, http.StatusBadGateway, err.Error())
+		return
+	}
+	writeJSON(response, http.StatusOK, aclResult{Name: objectName, Public: body.Public, DryRun: *dryRun})
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"html/template"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+var aliasesFile = flag.String("aliases", "", "File of friendly slugs for objects, one \"slug objectName\" pair per line. /play/slug plays the object and the index links it under the slug. Reloaded by -admin-users through /admin/reload-aliases.")
+
+// Aliases maps the slugs of -aliases to object names and back.
+type Aliases struct {
+	mutex sync.RWMutex
+	// objects maps slugs to object names, slugs the other way round.
+	objects map[string]string
+	slugs   map[string]string
+}
+
+// ReadAliases reads the slug to object name map from a file, skipping empty
+// lines and comments starting with #. The object name is everything after
+// the first run of blanks, so it may contain spaces itself.
+func ReadAliases(filename string) (map[string]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	objects := make(map[string]string)
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		split := strings.IndexAny(text, " \t")
+		if split < 0 {
+			return nil, fmt.Errorf("line %d: expected \"slug objectName\"", line)
+		}
+		slug, objectName := text[:split], strings.TrimSpace(text[split:])
+		if _, ok := objects[slug]; ok {
+			return nil, fmt.Errorf("line %d: duplicate slug %q", line, slug)
+		}
+		// The index can only show one slug per object.
+		if seen[objectName] {
+			return nil, fmt.Errorf("line %d: %q already has a slug", line, objectName)
+		}
+		objects[slug] = objectName
+		seen[objectName] = true
+	}
+	return objects, scanner.Err()
+}
+
+// Load replaces the aliases with the ones in filename. The old aliases stay
+// in place when the file can't be read.
+func (a *Aliases) Load(filename string) (int, error) {
+	return loadLocked(&a.mutex, filename, ReadAliases, func(objects map[string]string) int {
+		slugs := make(map[string]string, len(objects))
+		for slug, objectName := range objects {
+			slugs[objectName] = slug
+		}
+		a.objects, a.slugs = objects, slugs
+		return len(objects)
+	})
+}
+
+// Resolve returns the object name behind slug, or slug itself when it isn't
+// an alias so that object names keep working.
+func (a *Aliases) Resolve(slug string) string {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	if objectName, ok := a.objects[slug]; ok {
+		return objectName
+	}
+	return slug
+}
+
+// Slug returns the slug of objectName and whether it has one.
+func (a *Aliases) Slug(objectName string) (string, bool) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	slug, ok := a.slugs[objectName]
+	return slug, ok
+}
+
+// PlayPath escapes the slug of objectName, or objectName without one, for
+// /play/ links.
+func (a *Aliases) PlayPath(objectName string) template.URL {
+	if slug, ok := a.Slug(objectName); ok {
+		return ObjectPath(slug)
+	}
+	return ObjectPath(objectName)
+}
+
+// DisplayName is the slug of objectName, or its cleaned up name without one.
+func (a *Aliases) DisplayName(objectName string) string {
+	if slug, ok := a.Slug(objectName); ok {
+		return slug
+	}
+	return CleanupName(objectName)
+}
+
+// ReloadAliasesHandler re-reads -aliases.
+func (s *Server) ReloadAliasesHandler(response http.ResponseWriter, request *http.Request) {
+	reloadFile(response, request, fileReload{
+		name:     "aliases",
+		flag:     "aliases",
+		filename: *aliasesFile,
+		what:     "aliases",
+		countKey: "aliases",
+		load:     s.Aliases.Load,
+	})
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+)
+
+const minSuggestQuery = 2
+
+var suggestLimit = flag.Int("suggest-limit", 10, "Maximum number of names returned by /api/suggest.")
+
+func writeJSON(response http.ResponseWriter, status int, value interface{}) {
+	response.Header().Set("Content-type", "application/json")
+	response.WriteHeader(status)
+	if err := json.NewEncoder(response).Encode(value); err != nil {
+		log.WithFields(log.Fields{
+			"internalError": err,
+		}).Warn("Failed writing JSON response.")
+	}
+}
+
+type apiError struct {
+	Error string `json:"error"`
+}
+
+func writeJSONError(response http.ResponseWriter, status int, message string) {
+	writeJSON(response, status, apiError{Error: message})
+}
+
+// RequireJSON answers 415 and returns false unless the request says its body
+// is JSON. Browsers only send that content type cross-site after a CORS
+// preflight, so other sites can't post forms to the API of a logged-in user.
+func RequireJSON(response http.ResponseWriter, request *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		writeJSONError(response, http.StatusUnsupportedMediaType, "expected Content-Type: application/json")
+		return false
+	}
+	return true
+}
+
+// WantsJSON reports whether the client asked for JSON instead of HTML, either
+// with ?format=json or by accepting JSON but not HTML.
+func WantsJSON(request *http.Request) bool {
+	switch request.FormValue("format") {
+	case "json":
+		return true
+	case "html":
+		return false
+	}
+	accept := request.Header.Get("Accept")
+	return strings.Contains(accept, "application/json") && !strings.Contains(accept, "text/html")
+}
+
+// VideoMetadata is the JSON form of the play page.
+type VideoMetadata struct {
+	Name        string `json:"name"`
+	ContentType string `json:"contentType"`
+	Size        uint64 `json:"size"`
+	Updated     string `json:"updated"`
+	Url         string `json:"url"`
+	SubUrl      string `json:"subUrl"`
+	// ThumbnailsUrl is the WebVTT track of the seek bar thumbnails.
+	ThumbnailsUrl string `json:"thumbnailsUrl,omitempty"`
+	DownloadUrl   string `json:"downloadUrl,omitempty"`
+	Stream        bool   `json:"stream"`
+}
+
+type URLInfo struct {
+	Name string `json:"name"`
+	Url  string `json:"url"`
+}
+
+func (s *Server) URLHandler(response http.ResponseWriter, request *http.Request) {
+	objectName := mux.Vars(request)["objectName"]
+	if !s.VerifyObject(request.Context(), objectName) {
+		writeJSONError(response, http.StatusNotFound, "object not found")
+		return
+	}
+	signedUrl := s.SignUrlContext(request.Context(), objectName)
+	if signedUrl == "" {
+		writeJSONError(response, http.StatusInternalServerError, "could not sign url")
+		return
+	}
+	writeJSON(response, http.StatusOK, URLInfo{Name: objectName, Url: signedUrl})
+}
+
+// TextURLHandler is URLHandler for scripts, answering with just the signed
+// URL and a newline. It always checks that the object exists.
+func (s *Server) TextURLHandler(response http.ResponseWriter, request *http.Request) {
+	objectName := mux.Vars(request)["objectName"]
+	response.Header().Set("Content-type", "text/plain; charset=utf-8")
+	object, err := s.GetObject(request.Context(), objectName)
+	if err != nil {
+		http.Error(response, "Object not found.", http.StatusNotFound)
+		return
+	}
+	signedUrl := s.SignObject(object)
+	if signedUrl == "" {
+		http.Error(response, "Could not sign URL.", http.StatusInternalServerError)
+		return
+	}
+	if strings.HasPrefix(signedUrl, "/") {
+		// Proxied downloads are relative unless -external-url is set.
+		signedUrl = requestOrigin(request) + signedUrl
+	}
+	NoIndex(response)
+	fmt.Fprintln(response, signedUrl)
+}
+
+// SuggestHandler returns the names of indexed videos matching q, ranking names
+// that start with q above those that merely contain it.
+func (s *Server) SuggestHandler(response http.ResponseWriter, request *http.Request) {
+	query := strings.ToLower(strings.TrimSpace(request.FormValue("q")))
+	sizes, err := ParseSizeRange(request)
+	if err != nil {
+		writeJSONError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	names := []string{}
+	if len(query) < minSuggestQuery {
+		writeJSON(response, http.StatusOK, names)
+		return
+	}
+
+	matches := FilterBySize(FilterVideos(s.IndexObjects(request.Context(), request.FormValue("prefix"), query)), sizes.Min, sizes.Max)
+	SortObjects(matches, ParseSort(request))
+	isPrefix := func(objectName string) bool {
+		objectName = strings.ToLower(objectName)
+		return strings.HasPrefix(objectName, query) || strings.HasPrefix(path.Base(objectName), query)
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		return isPrefix(matches[i].Name) && !isPrefix(matches[j].Name)
+	})
+
+	for _, object := range matches {
+		if len(names) == *suggestLimit {
+			break
+		}
+		names = append(names, object.Name)
+	}
+	writeJSON(response, http.StatusOK, names)
+}
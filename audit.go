@@ -0,0 +1,25 @@
+package main
+
+import (
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// Audit records an operation that changes the bucket or grants access to it.
+// In dry-run mode the operation is logged but not carried out, which the entry
+// reflects.
+func Audit(request *http.Request, operation string, fields log.Fields) {
+	entry := log.WithFields(fields).WithFields(log.Fields{
+		"audit":      true,
+		"operation":  operation,
+		"user":       CurrentUser(request),
+		"remoteAddr": request.RemoteAddr,
+		"dryRun":     *dryRun,
+	})
+	if *dryRun {
+		entry.Info("Dry run, skipping operation.")
+	} else {
+		entry.Info("Performing operation.")
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"crypto/subtle"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+var adminUsers = flag.String("admin-users", "", "Comma separated users that see operator tools like links to the Cloud Console.")
+
+type contextKey int
+
+const userContextKey contextKey = iota
+
+// Paths below these prefixes carry their own authorization or are for load
+// balancers and are served without asking for credentials.
+var publicPathPrefixes = []string{"/s/", "/r/", "/robots.txt", "/readyz"}
+
+// ParseUsers parses a comma separated list of user:password pairs.
+func ParseUsers(input string) (map[string]string, error) {
+	users := make(map[string]string)
+	for _, pair := range strings.Split(input, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid user %q, expected user:password", parts[0])
+		}
+		users[parts[0]] = parts[1]
+	}
+	return users, nil
+}
+
+func (s *Server) AuthEnabled() bool {
+	return len(s.Users) > 0
+}
+
+func (s *Server) checkPassword(user string, password string) bool {
+	expected, ok := s.Users[user]
+	if !ok {
+		// Compare anyway so unknown users take as long as known ones.
+		expected = "\x00"
+	}
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(password)) == 1 && ok
+}
+
+func isPublicPath(path string) bool {
+	for _, prefix := range publicPathPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticate requires HTTP basic auth on every request when users are
+// configured and records the authenticated user in the request context.
+func (s *Server) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if !s.AuthEnabled() || isPublicPath(request.URL.Path) {
+			next.ServeHTTP(response, request)
+			return
+		}
+		user, password, ok := request.BasicAuth()
+		if !ok || !s.checkPassword(user, password) {
+			if ok {
+				log.WithFields(log.Fields{
+					"user":       user,
+					"remoteAddr": request.RemoteAddr,
+				}).Warn("Rejected login.")
+			}
+			response.Header().Set("WWW-Authenticate", `Basic realm="filebrowser"`)
+			http.Error(response, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		ctx := context.WithValue(request.Context(), userContextKey, user)
+		next.ServeHTTP(response, request.WithContext(ctx))
+	})
+}
+
+// CurrentUser returns the authenticated user of the request, or "" when
+// authentication is disabled.
+func CurrentUser(request *http.Request) string {
+	user, _ := request.Context().Value(userContextKey).(string)
+	return user
+}
+
+// RequireUser only lets authenticated users through. Without any configured
+// users the wrapped handler is unavailable.
+func (s *Server) RequireUser(handler http.HandlerFunc) http.HandlerFunc {
+	return func(response http.ResponseWriter, request *http.Request) {
+		if CurrentUser(request) == "" {
+			http.Error(response, "This endpoint requires authentication.", http.StatusForbidden)
+			return
+		}
+		handler(response, request)
+	}
+}
+
+// IsAdmin reports whether the authenticated user is listed in -admin-users.
+func IsAdmin(request *http.Request) bool {
+	user := CurrentUser(request)
+	if user == "" {
+		return false
+	}
+	for _, admin := range strings.Split(*adminUsers, ",") {
+		if strings.TrimSpace(admin) == user {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+func TestRestrictedLinksSkipLogin(t *testing.T) {
+	_, network, _ := net.ParseCIDR("10.0.0.0/8")
+	setFlag(t, &restrictedNetworks, []*net.IPNet{network})
+	bucket := &fakeBucket{}
+	bucket.add("clip.mp4", "video", storage.Object{ContentType: "video/mp4"})
+	s := newTestServer(t, bucket)
+	s.Users = map[string]string{"alice": "secret"}
+	s.CookieSecret = []byte("cookie secret")
+	handler := s.Handler(s.NewRouter())
+	link := s.RestrictedPath("clip.mp4", nil, time.Hour)
+
+	tests := []struct {
+		name       string
+		path       string
+		remoteAddr string
+		status     int
+	}{
+		{"allowed network", link, "10.1.2.3:1234", http.StatusOK},
+		{"other network", link, "192.0.2.1:1234", http.StatusForbidden},
+		{"invalid token", "/r/invalid", "10.1.2.3:1234", http.StatusForbidden},
+		{"other pages", "/play/clip.mp4", "10.1.2.3:1234", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		request := httptest.NewRequest("GET", test.path, nil)
+		request.RemoteAddr = test.remoteAddr
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		if response.Code != test.status {
+			t.Errorf("%s: status %d, want %d: %s", test.name, response.Code, test.status, response.Body)
+		}
+		if test.status == http.StatusOK && response.Body.String() != "video" {
+			t.Errorf("%s: body %q, want the object", test.name, response.Body)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"net/http"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	bannerText  = flag.String("banner", "", "Announcement shown at the top of every page. It can be changed at runtime through /admin/banner.")
+	bannerLevel = flag.String("banner-level", "info", "Severity of -banner, info or warn.")
+)
+
+// maxBannerLength keeps a banner to a line or two.
+const maxBannerLength = 500
+
+// Banner is an announcement shown on every page. The text is plain text and
+// escaped by the templates.
+type Banner struct {
+	Text  string `json:"text"`
+	Level string `json:"level"`
+}
+
+// ValidateBanner checks banner and fills in the default level.
+func ValidateBanner(banner Banner) (Banner, error) {
+	banner.Text = strings.TrimSpace(banner.Text)
+	if banner.Level == "" {
+		banner.Level = "info"
+	}
+	if banner.Level != "info" && banner.Level != "warn" {
+		return Banner{}, errors.New("banner level must be info or warn")
+	}
+	if len(banner.Text) > maxBannerLength {
+		return Banner{}, errors.New("banner is too long")
+	}
+	return banner, nil
+}
+
+// BannerBoard holds the banner, which can be replaced while pages render.
+type BannerBoard struct {
+	mutex  sync.RWMutex
+	banner Banner
+}
+
+// Current returns the banner to show, or nil when there is none.
+func (b *BannerBoard) Current() *Banner {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	if b.banner.Text == "" {
+		return nil
+	}
+	banner := b.banner
+	return &banner
+}
+
+func (b *BannerBoard) Set(banner Banner) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.banner = banner
+}
+
+// BannerHandler returns the banner on GET and replaces it on POST. An empty
+// text removes the banner.
+func (s *Server) BannerHandler(response http.ResponseWriter, request *http.Request) {
+	if request.Method != "POST" {
+		current := s.Banner.Current()
+		if current == nil {
+			current = &Banner{}
+		}
+		writeJSON(response, http.StatusOK, current)
+		return
+	}
+	if !RequireJSON(response, request) {
+		return
+	}
+	var banner Banner
+	if err := json.NewDecoder(request.Body).Decode(&banner); err != nil {
+		writeJSONError(response, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	banner, err := ValidateBanner(banner)
+	if err != nil {
+		writeJSONError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	Audit(request, "set-banner", log.Fields{"text": banner.Text, "level": banner.Level})
+	if !*dryRun {
+		s.Banner.Set(banner)
+	}
+	writeJSON(response, http.StatusOK, banner)
+}
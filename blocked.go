@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	storage "google.golang.org/api/storage/v1"
+)
+
+var blockedNames = flag.String("blocked-names", "", "File of object names that are never listed or served, one per line. Lines may be glob patterns, *.tmp matches in every folder, and lines ending with a slash block a whole folder. Reloaded by -admin-users through /admin/reload-blocked.")
+
+// BlockList holds the patterns of -blocked-names. They are matched against
+// the names the instance knows objects by, inside of -root-prefix.
+type BlockList struct {
+	mutex    sync.RWMutex
+	patterns []string
+}
+
+// ReadBlockList reads the patterns from a file, skipping empty lines and
+// comments starting with #.
+func ReadBlockList(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if _, err := path.Match(line, ""); err != nil {
+			return nil, err
+		}
+		patterns = append(patterns, line)
+	}
+	return patterns, scanner.Err()
+}
+
+// Load replaces the patterns with the ones in filename. The old patterns
+// stay in place when the file can't be read.
+func (b *BlockList) Load(filename string) (int, error) {
+	return loadLocked(&b.mutex, filename, ReadBlockList, func(patterns []string) int {
+		b.patterns = patterns
+		return len(patterns)
+	})
+}
+
+// Blocked reports whether objectName must not be listed or served. Trashed
+// objects are blocked as well.
+func (b *BlockList) Blocked(objectName string) bool {
+	// The saved play counts are the server's, not content.
+	if InTrash(objectName) || (*playCountsObject != "" && objectName == *playCountsObject) {
+		return true
+	}
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	for _, pattern := range b.patterns {
+		if strings.HasSuffix(pattern, delimiter) {
+			if strings.HasPrefix(objectName, pattern) {
+				return true
+			}
+			continue
+		}
+		// Patterns without a slash match the base name in every folder.
+		name := objectName
+		if !strings.Contains(pattern, delimiter) {
+			name = path.Base(objectName)
+		}
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// Hide filters the blocked objects out of a freshly fetched listing in
+// place.
+func (b *BlockList) Hide(items []*storage.Object) []*storage.Object {
+	b.mutex.RLock()
+	empty := len(b.patterns) == 0 && !TrashEnabled() && *playCountsObject == ""
+	b.mutex.RUnlock()
+	if empty {
+		return items
+	}
+	return keep(items, func(item *storage.Object) bool { return !b.Blocked(item.Name) })
+}
+
+// logBlocked records an attempt to access a blocked object.
+func logBlocked(request *http.Request, objectName string) {
+	log.WithFields(log.Fields{
+		"audit":      true,
+		"objectName": objectName,
+		"user":       CurrentUser(request),
+		"remoteAddr": request.RemoteAddr,
+		"path":       request.URL.Path,
+	}).Warn("Refused access to blocked object.")
+}
+
+// BlockObjects answers 403 for every route naming a blocked object.
+func (s *Server) BlockObjects(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if objectName, ok := mux.Vars(request)["objectName"]; ok && s.Blocked.Blocked(objectName) {
+			logBlocked(request, objectName)
+			http.Error(response, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(response, request)
+	})
+}
+
+// ReloadBlockedHandler re-reads -blocked-names.
+func (s *Server) ReloadBlockedHandler(response http.ResponseWriter, request *http.Request) {
+	reloadFile(response, request, fileReload{
+		name:     "blocked",
+		flag:     "blocked-names",
+		filename: *blockedNames,
+		what:     "blocked names",
+		countKey: "patterns",
+		load:     s.Blocked.Load,
+	})
+}
@@ -0,0 +1,325 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/net/context"
+	storage "google.golang.org/api/storage/v1"
+)
+
+const delimiter = "/"
+
+var (
+	hidePlaceholders = flag.Bool("hide-folder-placeholders", true, "Hide the empty objects named like a folder that some tools create, they still show up as folders.")
+	folderPageSize   = flag.Int("folder-page-size", 200, "Number of entries a folder shows at first, the rest are loaded with show more. 0 shows all of them.")
+)
+
+// IsFolderPlaceholder reports whether object is an empty object standing in
+// for a folder, like "photos/".
+func IsFolderPlaceholder(object *storage.Object) bool {
+	return object.Size == 0 && strings.HasSuffix(object.Name, delimiter)
+}
+
+// HidePlaceholders filters the folder placeholders out of a freshly fetched
+// listing in place, unless -hide-folder-placeholders is disabled.
+func HidePlaceholders(items []*storage.Object) []*storage.Object {
+	if !*hidePlaceholders {
+		return items
+	}
+	kept := items[:0]
+	for _, item := range items {
+		if !IsFolderPlaceholder(item) {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+// ObjectLink is how a listing links to an object: Url is the signed URL of
+// objects that aren't played, and objects that can't be served or signed are
+// not Available.
+type ObjectLink struct {
+	Url       string
+	Available bool
+}
+
+// Presign signs object up front, so that a failure shows as unavailable
+// instead of as a link to nowhere. Videos link to their play page and aren't
+// signed. Links signed ahead by PrefetchPage are used when there are any.
+func (s *Server) Presign(object *storage.Object) ObjectLink {
+	if link, ok := s.Cache.takeLink(object); ok {
+		return link
+	}
+	return s.presign(object)
+}
+
+func (s *Server) presign(object *storage.Object) ObjectLink {
+	if !Available(object) {
+		return ObjectLink{}
+	}
+	if IsVideo(object) {
+		return ObjectLink{Available: true}
+	}
+	signed := s.SignObject(object)
+	return ObjectLink{Url: signed, Available: signed != ""}
+}
+
+// PresignLinks is Presign for every object of items, by name.
+func (s *Server) PresignLinks(items ...[]*storage.Object) map[string]ObjectLink {
+	links := make(map[string]ObjectLink)
+	for _, list := range items {
+		for _, object := range list {
+			links[object.Name] = s.Presign(object)
+		}
+	}
+	return links
+}
+
+// BrowseEntry is either a folder (a common prefix) or an object inside the
+// folder being browsed.
+type BrowseEntry struct {
+	Name   string
+	Path   string
+	Folder bool
+	Object *storage.Object
+	// Url is the signed URL of objects that aren't played. Objects that
+	// can't be served or signed are not Available.
+	Url       string
+	Available bool
+	// Transcoding is set when a transcoding job was started for the object.
+	Transcoding bool
+}
+
+// sortObject returns the object an entry is sorted by. Folders have no
+// metadata of their own and sort like an empty object named after them.
+func (e BrowseEntry) sortObject() *storage.Object {
+	if e.Object == nil {
+		return &storage.Object{Name: e.Path}
+	}
+	return e.Object
+}
+
+// SortEntries sorts folders and objects together by option.
+func SortEntries(entries []BrowseEntry, option SortOption) {
+	objects := make([]*storage.Object, len(entries))
+	for i, entry := range entries {
+		objects[i] = entry.sortObject()
+	}
+	items := newSortItems(objects)
+	order := make([]int, len(entries))
+	for i := range order {
+		order[i] = i
+	}
+	less := option.less()
+	sort.SliceStable(order, func(i, j int) bool { return less(items[order[i]], items[order[j]]) })
+
+	sorted := make([]BrowseEntry, len(entries))
+	for i, index := range order {
+		sorted[i] = entries[index]
+	}
+	copy(entries, sorted)
+}
+
+// FoldersFirst moves all folders in front of the objects while keeping the
+// relative order of both groups.
+func FoldersFirst(entries []BrowseEntry) []BrowseEntry {
+	result := make([]BrowseEntry, 0, len(entries))
+	for _, entry := range entries {
+		if entry.Folder {
+			result = append(result, entry)
+		}
+	}
+	for _, entry := range entries {
+		if !entry.Folder {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// ListFolder returns the sub folders and objects directly under prefix.
+func (s *Server) ListFolder(ctx context.Context, prefix string) (prefixes []string, items []*storage.Object, err error) {
+	ctx, span := StartSpan(ctx, "storage.list", attribute.String("prefix", prefix))
+	defer func() {
+		span.SetAttributes(attribute.Int("objects", len(items)), attribute.Int("folders", len(prefixes)))
+		EndSpan(span, err)
+	}()
+	call := s.ObjectsList().Delimiter(delimiter).Context(ctx)
+	if prefix := StorageName(prefix); prefix != "" {
+		call.Prefix(prefix)
+	}
+	for {
+		res, err := call.Do()
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, folder := range res.Prefixes {
+			if name, ok := VirtualName(folder); ok && !s.Blocked.Blocked(name) {
+				prefixes = append(prefixes, name)
+			}
+		}
+		items = append(items, s.Blocked.Hide(HidePlaceholders(VirtualObjects(res.Items)))...)
+		if res.NextPageToken == "" {
+			return prefixes, items, nil
+		}
+		call.PageToken(res.NextPageToken)
+	}
+}
+
+// ParentFolder returns the prefix of the folder containing prefix.
+func ParentFolder(prefix string) string {
+	trimmed := strings.TrimSuffix(prefix, delimiter)
+	if i := strings.LastIndex(trimmed, delimiter); i >= 0 {
+		return trimmed[:i+1]
+	}
+	return ""
+}
+
+type BrowsePage struct {
+	Prefix  string
+	Parent  string
+	Entries []BrowseEntry
+	Sort    SortOption
+	URL     string
+	// CanTranscode is set when the user may start transcoding jobs.
+	CanTranscode bool
+	// ShowTrash links to the trash for users that can restore from it.
+	ShowTrash bool
+	// ShowDeleted links to the objects deleted from the folder when
+	// -allow-undelete is set.
+	ShowDeleted bool
+	// NextOffset is where show more continues, 0 when all entries are
+	// shown. Remaining is the number of entries after them.
+	NextOffset int
+	Remaining  int
+}
+
+func (s *Server) BrowseHandler(response http.ResponseWriter, request *http.Request) {
+	prefix := mux.Vars(request)["prefix"]
+	if prefix != "" && !strings.HasSuffix(prefix, delimiter) {
+		prefix += delimiter
+	}
+	response.Header().Set("Content-type", "text/html")
+
+	prefixes, items, err := s.ListFolder(request.Context(), prefix)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"prefix":        prefix,
+			"internalError": err,
+		}).Warn("Failed getting folder listing.")
+	}
+
+	entries := make([]BrowseEntry, 0, len(prefixes)+len(items))
+	for _, folder := range prefixes {
+		entries = append(entries, BrowseEntry{
+			Name:   strings.TrimPrefix(folder, prefix),
+			Path:   folder,
+			Folder: true,
+		})
+	}
+	for _, object := range items {
+		entries = append(entries, BrowseEntry{
+			Name:        strings.TrimPrefix(object.Name, prefix),
+			Path:        object.Name,
+			Object:      object,
+			Transcoding: s.Transcodes.Job(object.Name) != "",
+		})
+	}
+
+	option := ParseSort(request)
+	SortEntries(entries, option)
+	if *foldersFirst {
+		entries = FoldersFirst(entries)
+	}
+	entries, next := PageEntries(entries, ParseOffset(request), *folderPageSize)
+	for i := range entries {
+		if entry := &entries[i]; !entry.Folder {
+			link := s.Presign(entry.Object)
+			entry.Url, entry.Available = link.Url, link.Available
+		}
+	}
+
+	page := BrowsePage{
+		Prefix:       prefix,
+		Parent:       ParentFolder(prefix),
+		Entries:      entries,
+		Sort:         option,
+		URL:          Link(request.URL.RequestURI()),
+		CanTranscode: *enableTranscode && CurrentUser(request) != "",
+		ShowTrash:    TrashEnabled() && CurrentUser(request) != "",
+		ShowDeleted:  *allowUndelete && CurrentUser(request) != "",
+		NextOffset:   next,
+	}
+	if next > 0 {
+		page.Remaining = len(prefixes) + len(items) - next
+	}
+	if WantsJSON(request) {
+		writeJSON(response, http.StatusOK, page.JSON())
+		return
+	}
+	s.Render(response, request, "browse.html", page)
+}
+
+// ParseOffset reads the offset query parameter of folder pages.
+func ParseOffset(request *http.Request) int {
+	offset, err := strconv.Atoi(request.FormValue("offset"))
+	if err != nil || offset < 0 {
+		return 0
+	}
+	return offset
+}
+
+// PageEntries returns the size entries starting at offset and the offset of
+// the ones after them, or 0 when there are no more.
+func PageEntries(entries []BrowseEntry, offset int, size int) ([]BrowseEntry, int) {
+	if offset > len(entries) {
+		offset = len(entries)
+	}
+	if size <= 0 || offset+size >= len(entries) {
+		return entries[offset:], 0
+	}
+	return entries[offset : offset+size], offset + size
+}
+
+// BrowseEntryJSON is the JSON form of a folder entry.
+type BrowseEntryJSON struct {
+	Name      string `json:"name"`
+	Path      string `json:"path"`
+	Folder    bool   `json:"folder,omitempty"`
+	Size      uint64 `json:"size,omitempty"`
+	Updated   string `json:"updated,omitempty"`
+	Url       string `json:"url,omitempty"`
+	Available bool   `json:"available"`
+}
+
+// BrowseJSON is the JSON form of a folder page. Next is the offset query
+// parameter of the following page, 0 on the last one.
+type BrowseJSON struct {
+	Prefix  string            `json:"prefix"`
+	Entries []BrowseEntryJSON `json:"entries"`
+	Next    int               `json:"next,omitempty"`
+}
+
+func (p BrowsePage) JSON() BrowseJSON {
+	result := BrowseJSON{Prefix: p.Prefix, Entries: []BrowseEntryJSON{}, Next: p.NextOffset}
+	for _, entry := range p.Entries {
+		item := BrowseEntryJSON{Name: entry.Name, Path: entry.Path, Folder: entry.Folder, Available: entry.Folder || entry.Available}
+		if entry.Object != nil {
+			item.Size = entry.Object.Size
+			item.Updated = entry.Object.Updated
+			item.Url = entry.Url
+			if item.Available && IsVideo(entry.Object) {
+				item.Url = Link("/play/", ObjectPath(entry.Path))
+			}
+		}
+		result.Entries = append(result.Entries, item)
+	}
+	return result
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"golang.org/x/net/context"
+	storage "google.golang.org/api/storage/v1"
+)
+
+func TestHidePlaceholders(t *testing.T) {
+	tests := []struct {
+		name            string
+		placeholderData string
+		hide            bool
+		want            []string
+	}{
+		{"empty placeholder", "", true, []string{"photos/a.jpg", "photos/b.jpg"}},
+		{"placeholder with data", "x", true, []string{"photos/", "photos/a.jpg", "photos/b.jpg"}},
+		{"hiding disabled", "", false, []string{"photos/", "photos/a.jpg", "photos/b.jpg"}},
+	}
+	for _, test := range tests {
+		setFlag(t, hidePlaceholders, test.hide)
+		bucket := &fakeBucket{}
+		bucket.add("photos/", test.placeholderData, storage.Object{})
+		bucket.add("photos/a.jpg", "jpeg", storage.Object{})
+		bucket.add("photos/b.jpg", "", storage.Object{})
+		bucket.add("top.mp4", "video", storage.Object{})
+		s := newTestServer(t, bucket)
+
+		_, items, err := s.ListFolder(context.Background(), "photos/")
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		var names []string
+		for _, item := range items {
+			names = append(names, item.Name)
+		}
+		if !reflect.DeepEqual(names, test.want) {
+			t.Errorf("%s: listed %v, want %v", test.name, names, test.want)
+		}
+
+		prefixes, items, err := s.ListFolder(context.Background(), "")
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(prefixes, []string{"photos/"}) {
+			t.Errorf("%s: folders %v, want [photos/]", test.name, prefixes)
+		}
+		if len(items) != 1 || items[0].Name != "top.mp4" {
+			t.Errorf("%s: root listed %d objects, want top.mp4", test.name, len(items))
+		}
+	}
+}
+
+func TestIsFolderPlaceholder(t *testing.T) {
+	tests := []struct {
+		object storage.Object
+		want   bool
+	}{
+		{storage.Object{Name: "photos/"}, true},
+		{storage.Object{Name: "photos/2020/"}, true},
+		{storage.Object{Name: "photos/", Size: 3}, false},
+		{storage.Object{Name: "photos"}, false},
+		{storage.Object{Name: "photos/empty.txt"}, false},
+	}
+	for _, test := range tests {
+		object := test.object
+		if got := IsFolderPlaceholder(&object); got != test.want {
+			t.Errorf("IsFolderPlaceholder(%q, size %d) = %v, want %v", object.Name, object.Size, got, test.want)
+		}
+	}
+}
+
+func TestPresignLinks(t *testing.T) {
+	setFlag(t, proxyOnly, true)
+	setFlag(t, verifyListing, true)
+	s := &Server{Cache: NewListingCache()}
+	s.Blocked.patterns = []string{"secret.txt"}
+	updated := "2016-01-02T15:04:05Z"
+	links := s.PresignLinks([]*storage.Object{
+		{Name: "notes.txt", Size: 10, Updated: updated},
+		{Name: "clip.mp4", Size: 10, Updated: updated},
+		{Name: "secret.txt", Size: 10, Updated: updated},
+	}, []*storage.Object{
+		{Name: "gone.txt"},
+	})
+
+	tests := []struct {
+		name      string
+		available bool
+		signed    bool
+	}{
+		{"notes.txt", true, true},
+		{"clip.mp4", true, false},
+		{"secret.txt", false, false},
+		{"gone.txt", false, false},
+	}
+	for _, test := range tests {
+		link, ok := links[test.name]
+		if !ok {
+			t.Errorf("%s: no link", test.name)
+			continue
+		}
+		if link.Available != test.available || (link.Url != "") != test.signed {
+			t.Errorf("%s: %+v, want available %v, signed %v", test.name, link, test.available, test.signed)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"google.golang.org/api/googleapi"
+)
+
+// Buckets rarely come and go, their list is cached for longer than object
+// listings.
+const bucketListTTL = 10 * time.Minute
+
+// BucketInfo is a bucket the credentials can list.
+type BucketInfo struct {
+	Name         string `json:"name"`
+	Location     string `json:"location,omitempty"`
+	StorageClass string `json:"storageClass,omitempty"`
+	// Current is set for the bucket this instance serves.
+	Current bool `json:"current,omitempty"`
+}
+
+// BucketCache keeps the last bucket list of the project.
+type BucketCache struct {
+	mutex   sync.Mutex
+	buckets []BucketInfo
+	fetched time.Time
+}
+
+func (c *BucketCache) get() ([]BucketInfo, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.buckets == nil || time.Since(c.fetched) > bucketListTTL {
+		return nil, false
+	}
+	return c.buckets, true
+}
+
+func (c *BucketCache) put(buckets []BucketInfo) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.buckets, c.fetched = buckets, time.Now()
+}
+
+// ListBuckets returns the buckets of the project, from the cache when it is
+// fresh.
+func (s *Server) ListBuckets() ([]BucketInfo, error) {
+	if buckets, ok := s.Buckets.get(); ok {
+		return buckets, nil
+	}
+	buckets := []BucketInfo{}
+	call := s.Storage().Buckets.List(projectID).Fields("nextPageToken", "items(name,location,storageClass)")
+	for {
+		res, err := call.Do()
+		if err != nil {
+			return nil, RequesterPaysHint(err)
+		}
+		for _, bucket := range res.Items {
+			buckets = append(buckets, BucketInfo{
+				Name:         bucket.Name,
+				Location:     bucket.Location,
+				StorageClass: bucket.StorageClass,
+				Current:      bucket.Name == bucketName,
+			})
+		}
+		if res.NextPageToken == "" {
+			break
+		}
+		call.PageToken(res.NextPageToken)
+	}
+	s.Buckets.put(buckets)
+	return buckets, nil
+}
+
+// BucketsHandler lists the buckets of the project the credentials can see.
+func (s *Server) BucketsHandler(response http.ResponseWriter, request *http.Request) {
+	buckets, err := s.ListBuckets()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"project":       projectID,
+			"internalError": err,
+		}).Warn("Failed listing buckets.")
+		if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == http.StatusForbidden {
+			writeJSONError(response, http.StatusForbidden, "the service account may not list the buckets of the project, it needs storage.buckets.list")
+			return
+		}
+		writeJSONError(response, http.StatusBadGateway, err.Error())
+		return
+	}
+	writeJSON(response, http.StatusOK, buckets)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"flag"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	storage "google.golang.org/api/storage/v1"
+)
+
+var cacheTTL = flag.Duration("cache-ttl", time.Minute, "How long object listings are cached, 0 disables caching.")
+
+type listingEntry struct {
+	items   []*storage.Object
+	fetched time.Time
+}
+
+// presignedLink is a link signed before the page showing it was asked for.
+type presignedLink struct {
+	link   ObjectLink
+	signed time.Time
+}
+
+// ListingCache keeps recent object listings per prefix so that pages and
+// API calls don't list the bucket on every request.
+type ListingCache struct {
+	mutex   sync.Mutex
+	entries map[string]listingEntry
+	// notified is set while bucket notifications invalidate the cache, the
+	// entries don't expire then.
+	notified bool
+	// links are signed ahead for the next index page, by object name and
+	// generation.
+	links map[string]presignedLink
+	// ctx is cancelled when the cached listings are invalidated, stopping
+	// work done for them in the background.
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func NewListingCache() *ListingCache {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &ListingCache{
+		entries: make(map[string]listingEntry),
+		links:   make(map[string]presignedLink),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+}
+
+// Context returns a context that is done once the current listings are
+// invalidated.
+func (c *ListingCache) Context() context.Context {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.ctx
+}
+
+func (c *ListingCache) get(prefix string) ([]*storage.Object, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.entries[prefix]
+	if !ok || !c.notified && time.Since(entry.fetched) > *cacheTTL {
+		return nil, false
+	}
+	return entry.items, true
+}
+
+func (c *ListingCache) put(prefix string, items []*storage.Object) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries[prefix] = listingEntry{items: items, fetched: time.Now()}
+}
+
+// fresh reports whether the listing of prefix is cached and stays so for at
+// least half of -cache-ttl.
+func (c *ListingCache) fresh(prefix string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.entries[prefix]
+	return ok && (c.notified || time.Since(entry.fetched) < *cacheTTL/2)
+}
+
+func linkKey(object *storage.Object) string {
+	return object.Name + "#" + strconv.FormatInt(object.Generation, 10)
+}
+
+func (c *ListingCache) putLink(object *storage.Object, link ObjectLink) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.links[linkKey(object)] = presignedLink{link: link, signed: time.Now()}
+}
+
+// takeLink returns the link signed ahead for object, once. Links signed more
+// than half of their expiry ago are signed again instead, so that pages
+// don't hand out links about to expire.
+func (c *ListingCache) takeLink(object *storage.Object) (ObjectLink, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	key := linkKey(object)
+	presigned, ok := c.links[key]
+	delete(c.links, key)
+	return presigned.link, ok && time.Since(presigned.signed) < signedUrlExpiry/2
+}
+
+// Notified reports whether notifications currently keep the cache fresh.
+func (c *ListingCache) Notified() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.notified
+}
+
+// SetNotified switches between expiring entries after -cache-ttl and keeping
+// them until they are invalidated.
+func (c *ListingCache) SetNotified(notified bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.notified = notified
+}
+
+// Invalidate drops all cached listings and the links signed for them.
+func (c *ListingCache) Invalidate() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries = make(map[string]listingEntry)
+	c.links = make(map[string]presignedLink)
+	c.cancel()
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+}
+
+// CachedObjects is ListObjects served from the listing cache. The returned
+// slice is shared and must not be modified, sort a copy instead.
+func (s *Server) CachedObjects(ctx context.Context, prefix string) ([]*storage.Object, error) {
+	if *cacheTTL <= 0 {
+		return s.ListObjects(ctx, prefix)
+	}
+	if items, ok := s.Cache.get(prefix); ok {
+		return items, nil
+	}
+	items, err := s.ListObjects(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	s.Cache.put(prefix, items)
+	return items, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	storage "google.golang.org/api/storage/v1"
+)
+
+var verifyChecksums = flag.Bool("verify-checksums", false, "Verify the CRC32C and MD5 of complete proxied downloads against the object metadata. A mismatch is logged and reported in the X-Checksum-Verified trailer, as the bytes are sent by then. Such downloads have no Content-Length.")
+
+const checksumTrailer = "X-Checksum-Verified"
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Checksums hashes the bytes written to it the way Cloud Storage does.
+type Checksums struct {
+	crc32c hash.Hash32
+	md5    hash.Hash
+}
+
+func NewChecksums() *Checksums {
+	return &Checksums{crc32c: crc32.New(crc32cTable), md5: md5.New()}
+}
+
+func (c *Checksums) Write(p []byte) (int, error) {
+	c.crc32c.Write(p)
+	return c.md5.Write(p)
+}
+
+// Verify compares the hashes with the ones in the metadata of object.
+// Composite objects only have a CRC32C.
+func (c *Checksums) Verify(object *storage.Object) error {
+	if object.Crc32c != "" {
+		var sum [4]byte
+		binary.BigEndian.PutUint32(sum[:], c.crc32c.Sum32())
+		if actual := base64.StdEncoding.EncodeToString(sum[:]); actual != object.Crc32c {
+			return fmt.Errorf("CRC32C is %s instead of %s", actual, object.Crc32c)
+		}
+	}
+	if object.Md5Hash != "" {
+		if actual := base64.StdEncoding.EncodeToString(c.md5.Sum(nil)); actual != object.Md5Hash {
+			return fmt.Errorf("MD5 is %s instead of %s", actual, object.Md5Hash)
+		}
+	}
+	return nil
+}
+
+// logChecksum logs the outcome of verifying a download of object.
+func logChecksum(object *storage.Object, err error) {
+	if err != nil {
+		log.WithFields(log.Fields{
+			"objectName":    object.Name,
+			"generation":    object.Generation,
+			"internalError": err,
+		}).Error("Checksum mismatch.")
+	}
+}
+
+type verifyResult struct {
+	Name     string `json:"name"`
+	Verified bool   `json:"verified"`
+	Error    string `json:"error,omitempty"`
+}
+
+// VerifyHandler downloads an object without serving it and checks it
+// against its checksums.
+func (s *Server) VerifyHandler(response http.ResponseWriter, request *http.Request) {
+	objectName := mux.Vars(request)["objectName"]
+	object, err := s.GetObject(request.Context(), objectName)
+	if err != nil {
+		writeJSONError(response, http.StatusNotFound, "object not found")
+		return
+	}
+	if object.Crc32c == "" && object.Md5Hash == "" {
+		writeJSONError(response, http.StatusConflict, "object has no checksums")
+		return
+	}
+	res, err := s.Storage().Objects.Get(bucketName, StorageName(objectName)).Generation(object.Generation).Context(request.Context()).Download()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"objectName":    objectName,
+			"internalError": err,
+		}).Warn("Failed downloading object.")
+		writeJSONError(response, http.StatusBadGateway, "could not download object")
+		return
+	}
+	defer res.Body.Close()
+	checksums := NewChecksums()
+	if _, err := io.Copy(checksums, res.Body); err != nil {
+		writeJSONError(response, http.StatusBadGateway, "download interrupted")
+		return
+	}
+	err = checksums.Verify(object)
+	logChecksum(object, err)
+	result := verifyResult{Name: objectName, Verified: err == nil}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	writeJSON(response, http.StatusOK, result)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var debug = flag.Bool("debug", false, "Log debug messages and dump the value of every flag at startup.")
+
+const redacted = "***"
+
+// secretFlags hold credentials that must never show up in the logs.
+var secretFlags = map[string]bool{
+	"cookie-secret":  true,
+	"webhook-secret": true,
+}
+
+// redactFlag returns the value of a flag as it may be logged.
+func redactFlag(name string, value string) string {
+	if value == "" {
+		return value
+	}
+	if secretFlags[name] {
+		return redacted
+	}
+	if name == "users" {
+		// Keep the user names, they help more than they hurt.
+		users := strings.Split(value, ",")
+		for i, pair := range users {
+			users[i] = strings.SplitN(pair, ":", 2)[0] + ":" + redacted
+		}
+		return strings.Join(users, ",")
+	}
+	return value
+}
+
+// SigningMode describes how object URLs are handed out.
+func SigningMode() string {
+	if *proxyOnly {
+		return "proxy-only"
+	}
+	if IPRestricted() {
+		return "ip-restricted"
+	}
+	if *publicBucket {
+		return "public"
+	}
+	return *signingVersion
+}
+
+// LogConfig logs the effective configuration, and every flag with -debug.
+func (s *Server) LogConfig(addr string) {
+	extensions := make([]string, 0, len(mediaTypes))
+	for extension := range mediaTypes {
+		extensions = append(extensions, extension)
+	}
+	sort.Strings(extensions)
+	log.WithFields(log.Fields{
+		"bucket":      bucketName,
+		"project":     projectID,
+		"addr":        addr,
+		"rootPrefix":  *rootPrefix,
+		"mediaTypes":  strings.Join(extensions, ","),
+		"cacheTTL":    *cacheTTL,
+		"auth":        s.AuthEnabled(),
+		"signingMode": SigningMode(),
+		"dryRun":      *dryRun,
+	}).Info("Configuration.")
+
+	if !*debug {
+		return
+	}
+	fields := log.Fields{}
+	flag.VisitAll(func(f *flag.Flag) {
+		fields[f.Name] = redactFlag(f.Name, f.Value.String())
+	})
+	log.WithFields(fields).Debug("Flags.")
+}
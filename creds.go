@@ -0,0 +1,169 @@
+package main
+
+import (
+	"crypto/rsa"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/oauth2/google"
+	storage "google.golang.org/api/storage/v1"
+	cloud "google.golang.org/cloud/storage"
+)
+
+var pemSecondary = flag.String("pem-secondary", "", "PEM file of a second key of the service account while keys are rotated, checked at startup and shown in /admin/debug/storage. New URLs are always signed with -pem. The overlap is up to GCS, which accepts URLs signed with any key the service account still has, so links signed with the old key work until it is deleted from the account. The server validates nothing with RSA keys itself, share and -sign-ip-restrict links are signed with -cookie-secret, so it needs neither key to accept them.")
+
+func init() {
+	flag.StringVar(pemFilename, "pem", *pemFilename, "Google Service Account PEM file, same as -pemFilename.")
+}
+
+// Credentials are the storage client and signing keys loaded from -creds,
+// -pem and -pem-secondary.
+type Credentials struct {
+	Service          *storage.Service
+	SignedURLOptions *cloud.SignedURLOptions
+	// SigningKey is only parsed for V4 signing.
+	SigningKey *rsa.PrivateKey
+	// SecondaryKey is the key being rotated in or out. It neither signs nor
+	// validates anything, the app's own links are HMACs of -cookie-secret.
+	// It is loaded to make sure it is usable before the old key goes away.
+	SecondaryKey *rsa.PrivateKey
+}
+
+// LoadCredentials reads the service account files and creates a storage
+// client from them.
+func LoadCredentials() (*Credentials, error) {
+	client, err := google.DefaultClient(storageContext(), scope)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get default client: %v", err)
+	}
+	service, err := storage.New(client)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create storage service: %v", err)
+	}
+	creds := &Credentials{Service: service}
+	if *proxyOnly || *publicBucket {
+		return creds, nil
+	}
+
+	pemFile, err := ioutil.ReadFile(*pemFilename)
+	if err != nil {
+		return nil, err
+	}
+	creds.SignedURLOptions = &cloud.SignedURLOptions{
+		GoogleAccessID: *googleAccessId,
+		PrivateKey:     pemFile,
+		Method:         "GET",
+	}
+	if *signingVersion == "v4" {
+		creds.SigningKey, err = ParsePrivateKey(pemFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse %s: %v", *pemFilename, err)
+		}
+	}
+	if *pemSecondary != "" {
+		creds.SecondaryKey, err = loadSecondaryKey(*pemSecondary, pemFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return creds, nil
+}
+
+// loadSecondaryKey reads the -pem-secondary key and makes sure it isn't the
+// primary key again.
+func loadSecondaryKey(filename string, primaryFile []byte) (*rsa.PrivateKey, error) {
+	secondaryFile, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	secondary, err := ParsePrivateKey(secondaryFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %v", filename, err)
+	}
+	if primary, err := ParsePrivateKey(primaryFile); err == nil && primary.Equal(secondary) {
+		return nil, errors.New("-pem-secondary is the same key as -pem")
+	}
+	return secondary, nil
+}
+
+// Check makes sure the credentials work by listing an object within
+// -root-prefix, which may be all they are allowed to list, and signing a URL
+// with them the way the server will, with the V4 key when there is one.
+func (c *Credentials) Check() error {
+	if _, err := c.Service.Objects.List(bucketName).Prefix(*rootPrefix).MaxResults(1).Fields("items(name)").Do(); err != nil {
+		return fmt.Errorf("unable to list bucket: %v", RequesterPaysHint(err))
+	}
+	if c.SignedURLOptions == nil {
+		return nil
+	}
+	var err error
+	if c.SigningKey != nil {
+		_, err = SignV4(c.SigningKey, *googleAccessId, bucketName, "check", nil, time.Now(), time.Minute)
+	} else {
+		options := *c.SignedURLOptions
+		options.Expires = time.Now().Add(time.Minute)
+		_, err = cloud.SignedURL(bucketName, "check", &options)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to sign URL: %v", err)
+	}
+	return nil
+}
+
+// Storage returns the current storage client.
+func (s *Server) Storage() *storage.Service {
+	return s.Credentials().Service
+}
+
+// Credentials returns the current credentials. They are replaced as a whole
+// on reload, so callers must not keep them between requests.
+func (s *Server) Credentials() *Credentials {
+	s.credentialsMutex.RLock()
+	defer s.credentialsMutex.RUnlock()
+	return s.credentials
+}
+
+func (s *Server) SetCredentials(creds *Credentials) {
+	s.credentialsMutex.Lock()
+	defer s.credentialsMutex.Unlock()
+	s.credentials = creds
+}
+
+type reloadResult struct {
+	Reloaded bool `json:"reloaded"`
+}
+
+// ReloadCredsHandler re-reads the credential files and switches to them once
+// they have been checked. The old credentials stay in use when the new ones
+// don't work. Only admins can reload.
+func (s *Server) ReloadCredsHandler(response http.ResponseWriter, request *http.Request) {
+	if !IsAdmin(request) {
+		writeJSONError(response, http.StatusForbidden, "only admins can reload credentials")
+		return
+	}
+	Audit(request, "reload-creds", log.Fields{})
+	creds, err := LoadCredentials()
+	if err == nil {
+		err = creds.Check()
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"internalError": err,
+		}).Warn("Failed reloading credentials, keeping the old ones.")
+		writeJSONError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	if *dryRun {
+		writeJSON(response, http.StatusOK, reloadResult{Reloaded: false})
+		return
+	}
+	s.SetCredentials(creds)
+	log.Info("Reloaded credentials.")
+	s.CheckSigning()
+	writeJSON(response, http.StatusOK, reloadResult{Reloaded: true})
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2/google"
+)
+
+const metadataEmailUrl = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/email"
+
+// StorageDebug is what /admin/debug/storage reports. It names files and
+// accounts but never includes keys or tokens.
+type StorageDebug struct {
+	// CredentialSource is file for -creds or GOOGLE_APPLICATION_CREDENTIALS,
+	// gcloud for the user's gcloud credentials and metadata for the
+	// service account of the instance.
+	CredentialSource string `json:"credentialSource"`
+	CredentialFile   string `json:"credentialFile,omitempty"`
+	CredentialType   string `json:"credentialType,omitempty"`
+	CredentialError  string `json:"credentialError,omitempty"`
+	ServiceAccount   string `json:"serviceAccount,omitempty"`
+	Project          string `json:"project,omitempty"`
+	UserProject      string `json:"userProject,omitempty"`
+	// SigningMode is proxy-only, public, v2 or v4. URLs are signed with the
+	// PEM key for SigningAccount in the last two.
+	SigningMode    string     `json:"signingMode"`
+	SigningKey     string     `json:"signingKey,omitempty"`
+	SecondaryKey   string     `json:"secondaryKey,omitempty"`
+	SigningAccount string     `json:"signingAccount,omitempty"`
+	SigningError   string     `json:"signingError,omitempty"`
+	SigningChecked *time.Time `json:"signingChecked,omitempty"`
+	Bucket         string     `json:"bucket"`
+	ListOk         bool       `json:"listOk"`
+	ListError      string     `json:"listError,omitempty"`
+}
+
+// credentialFile holds the fields of a JSON credential file that are safe to
+// show.
+type credentialFile struct {
+	Type        string `json:"type"`
+	ClientEmail string `json:"client_email"`
+}
+
+// describeCredentials fills in where the default credentials come from, as
+// google.DefaultClient finds them.
+func describeCredentials(info *StorageDebug) {
+	info.CredentialFile = os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+	if info.CredentialFile != "" {
+		info.CredentialSource = "file"
+	}
+	creds, err := google.FindDefaultCredentials(context.Background(), scope)
+	if err != nil {
+		info.CredentialSource = "none"
+		info.CredentialError = err.Error()
+		return
+	}
+	info.Project = creds.ProjectID
+	if len(creds.JSON) == 0 {
+		info.CredentialSource = "metadata"
+		info.ServiceAccount = metadataEmail()
+		return
+	}
+	if info.CredentialSource == "" {
+		info.CredentialSource = "gcloud"
+	}
+	var file credentialFile
+	if json.Unmarshal(creds.JSON, &file) == nil {
+		info.CredentialType = file.Type
+		info.ServiceAccount = file.ClientEmail
+	}
+}
+
+// metadataEmail asks the metadata server for the instance's service
+// account.
+func metadataEmail() string {
+	request, err := http.NewRequest("GET", metadataEmailUrl, nil)
+	if err != nil {
+		return ""
+	}
+	request.Header.Set("Metadata-Flavor", "Google")
+	client := &http.Client{Timeout: 2 * time.Second}
+	res, err := client.Do(request)
+	if err != nil {
+		return ""
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return ""
+	}
+	email, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(email))
+}
+
+// DebugStorageHandler reports the effective storage client configuration and
+// whether listing the bucket works, for admins troubleshooting credentials.
+func (s *Server) DebugStorageHandler(response http.ResponseWriter, request *http.Request) {
+	if !IsAdmin(request) {
+		writeJSONError(response, http.StatusForbidden, "only admins can see the storage configuration")
+		return
+	}
+	info := StorageDebug{
+		SigningMode: SigningMode(),
+		UserProject: *userProject,
+		Bucket:      bucketName,
+	}
+	describeCredentials(&info)
+	if s.Credentials().SignedURLOptions != nil {
+		info.SigningKey = *pemFilename
+		if s.Credentials().SecondaryKey != nil {
+			info.SecondaryKey = *pemSecondary
+		}
+		info.SigningAccount = *googleAccessId
+	}
+	if checked, err := s.Signing.get(); !checked.IsZero() {
+		info.SigningChecked = &checked
+		if err != nil {
+			info.SigningError = err.Error()
+		}
+	}
+	_, err := s.Storage().Objects.List(bucketName).Prefix(*rootPrefix).MaxResults(1).Fields("items(name)").Do()
+	info.ListOk = err == nil
+	if err != nil {
+		info.ListError = RequesterPaysHint(err).Error()
+	}
+	writeJSON(response, http.StatusOK, info)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/api/googleapi"
+	storage "google.golang.org/api/storage/v1"
+)
+
+var (
+	allowDelete   = flag.Bool("allow-delete", false, "Allow authenticated users to delete objects.")
+	maxBatchSize  = flag.Int("max-batch-size", 100, "Maximum number of objects deleted by one bulk delete request.")
+	deleteWorkers = flag.Int("delete-workers", 8, "Number of objects deleted concurrently by a bulk delete request.")
+)
+
+type DeleteResult struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// DeleteBlockedReason explains why object can't be deleted because of a hold
+// or retention period, or returns "" when nothing prevents its deletion.
+func DeleteBlockedReason(object *storage.Object) string {
+	if object.TemporaryHold {
+		return "object is under a temporary hold"
+	}
+	if object.EventBasedHold {
+		return "object is under an event-based hold"
+	}
+	if object.RetentionExpirationTime != "" {
+		until, err := time.Parse(time.RFC3339, object.RetentionExpirationTime)
+		if err == nil && until.After(time.Now()) {
+			return "object is under retention until " + until.Format(time.RFC1123)
+		}
+	}
+	return ""
+}
+
+// isRetentionError reports whether a delete failed because of a retention
+// policy or hold.
+func isRetentionError(err error) bool {
+	apiError, ok := err.(*googleapi.Error)
+	if !ok || apiError.Code != http.StatusForbidden {
+		return false
+	}
+	for _, item := range apiError.Errors {
+		if item.Reason == "retentionPolicyNotMet" {
+			return true
+		}
+	}
+	message := strings.ToLower(apiError.Message)
+	return strings.Contains(message, "retention") || strings.Contains(message, "hold")
+}
+
+// deleteFailure turns a failed delete into a message for the user.
+func (s *Server) deleteFailure(ctx context.Context, objectName string, err error) string {
+	if !isRetentionError(err) {
+		return err.Error()
+	}
+	if object, getErr := s.GetObject(ctx, objectName); getErr == nil {
+		if reason := DeleteBlockedReason(object); reason != "" {
+			return reason
+		}
+	}
+	return "object is protected by a retention policy or hold"
+}
+
+// DeleteObject deletes objectName from the bucket, or only records the
+// intent in dry-run mode.
+func (s *Server) DeleteObject(request *http.Request, objectName string) error {
+	Audit(request, "delete", log.Fields{"objectName": objectName})
+	if *dryRun {
+		return nil
+	}
+	return s.Storage().Objects.Delete(bucketName, StorageName(objectName)).Do()
+}
+
+// RemoveObject moves objectName to the trash when it is enabled and deletes
+// it otherwise.
+func (s *Server) RemoveObject(request *http.Request, objectName string) error {
+	if TrashEnabled() {
+		return s.TrashObject(request, objectName)
+	}
+	return s.DeleteObject(request, objectName)
+}
+
+// BulkDeleteHandler deletes the objects named in a JSON array using a bounded
+// number of workers and reports the outcome per object.
+func (s *Server) BulkDeleteHandler(response http.ResponseWriter, request *http.Request) {
+	if !RequireJSON(response, request) {
+		return
+	}
+	var names []string
+	if err := json.NewDecoder(request.Body).Decode(&names); err != nil {
+		if isBodyTooLarge(err) {
+			writeJSONError(response, http.StatusRequestEntityTooLarge, "request too large")
+			return
+		}
+		writeJSONError(response, http.StatusBadRequest, "expected a JSON array of object names")
+		return
+	}
+	if len(names) == 0 {
+		writeJSONError(response, http.StatusBadRequest, "no objects to delete")
+		return
+	}
+	if len(names) > *maxBatchSize {
+		writeJSONError(response, http.StatusBadRequest, fmt.Sprintf("at most %d objects can be deleted at once", *maxBatchSize))
+		return
+	}
+
+	for _, objectName := range names {
+		if objectName == "" {
+			writeJSONError(response, http.StatusBadRequest, "object names must not be empty")
+			return
+		}
+	}
+
+	results := make(map[string]DeleteResult, len(names))
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	work := make(chan string)
+	for i := 0; i < *deleteWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for objectName := range work {
+				result := DeleteResult{Status: "deleted"}
+				if err := s.RemoveObject(request, objectName); err != nil {
+					log.WithFields(log.Fields{
+						"objectName":    objectName,
+						"internalError": err,
+					}).Warn("Failed deleting object.")
+					result = DeleteResult{Status: "failed", Reason: s.deleteFailure(request.Context(), objectName, err)}
+				}
+				mutex.Lock()
+				results[objectName] = result
+				mutex.Unlock()
+			}
+		}()
+	}
+	for _, objectName := range names {
+		work <- objectName
+	}
+	close(work)
+	wg.Wait()
+
+	s.Cache.Invalidate()
+	writeJSON(response, http.StatusOK, results)
+}
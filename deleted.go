@@ -0,0 +1,208 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"google.golang.org/api/googleapi"
+	storage "google.golang.org/api/storage/v1"
+)
+
+var (
+	allowUndelete = flag.Bool("allow-undelete", false, "List objects deleted from a versioned bucket at /deleted and let admins restore them.")
+	deletedWindow = flag.Duration("deleted-window", 7*24*time.Hour, "How far back /deleted looks for objects deleted in a versioned bucket, unless its since parameter says otherwise.")
+)
+
+// deletedListingFields adds when generations stopped being live to
+// listingFields.
+const deletedListingFields = "nextPageToken,prefixes,items(name,size,updated,timeCreated,timeDeleted,contentType,cacheControl,generation,metadata)"
+
+// DeletedEntry is an object without a live generation, Object being the
+// generation that was live last.
+type DeletedEntry struct {
+	Name   string
+	Object *storage.Object
+	// DeletedAt is the RFC 3339 time the object was deleted.
+	DeletedAt string
+}
+
+// ParseSince reads the since query parameter, an RFC 3339 time or a
+// duration before now, falling back to -deleted-window.
+func ParseSince(request *http.Request, now time.Time) (time.Time, bool) {
+	value := request.FormValue("since")
+	if value == "" {
+		return now.Add(-*deletedWindow), true
+	}
+	if since, err := time.Parse(time.RFC3339, value); err == nil {
+		return since, true
+	}
+	if window, err := time.ParseDuration(value); err == nil && window > 0 {
+		return now.Add(-window), true
+	}
+	return time.Time{}, false
+}
+
+// ListDeleted returns the objects below prefix deleted after since, the most
+// recently deleted first. Only versioned buckets keep deleted generations.
+func (s *Server) ListDeleted(request *http.Request, prefix string, since time.Time) ([]DeletedEntry, error) {
+	call := s.Storage().Objects.List(bucketName).Versions(true).Context(request.Context())
+	if !*fullMetadata {
+		call.Fields(deletedListingFields)
+	}
+	latest := make(map[string]*storage.Object)
+	err := listPages(call, prefix, func(page []*storage.Object) error {
+		for _, object := range s.Blocked.Hide(HidePlaceholders(page)) {
+			if current, ok := latest[object.Name]; !ok || object.Generation > current.Generation {
+				latest[object.Name] = object
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, RequesterPaysHint(err)
+	}
+	var entries []DeletedEntry
+	for name, object := range latest {
+		// The newest generation of a live object was never deleted.
+		deleted, err := time.Parse(time.RFC3339Nano, object.TimeDeleted)
+		if err != nil || deleted.Before(since) {
+			continue
+		}
+		entries = append(entries, DeletedEntry{Name: name, Object: object, DeletedAt: deleted.UTC().Format(time.RFC3339)})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].DeletedAt != entries[j].DeletedAt {
+			return entries[i].DeletedAt > entries[j].DeletedAt
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries, nil
+}
+
+// Versioned reports whether the bucket keeps deleted generations.
+func (s *Server) Versioned() (bool, error) {
+	bucket, err := s.Storage().Buckets.Get(bucketName).Fields("versioning").Do()
+	if err != nil {
+		return false, err
+	}
+	return bucket.Versioning != nil && bucket.Versioning.Enabled, nil
+}
+
+// UndeleteObject copies the deleted generation object back to its name with
+// the metadata it had, or only records the intent in dry-run mode. It fails if
+// the object was recreated meanwhile.
+func (s *Server) UndeleteObject(request *http.Request, object *storage.Object) error {
+	Audit(request, "undelete", log.Fields{"objectName": object.Name, "generation": object.Generation})
+	if *dryRun {
+		return nil
+	}
+	copied := &storage.Object{
+		CacheControl:       object.CacheControl,
+		ContentDisposition: object.ContentDisposition,
+		ContentEncoding:    object.ContentEncoding,
+		ContentLanguage:    object.ContentLanguage,
+		ContentType:        object.ContentType,
+		Metadata:           object.Metadata,
+	}
+	call := s.Storage().Objects.Rewrite(bucketName, StorageName(object.Name), bucketName, StorageName(object.Name), copied).
+		SourceGeneration(object.Generation).IfGenerationMatch(0).Context(request.Context())
+	for {
+		res, err := call.Do()
+		if err != nil {
+			return err
+		}
+		if res.Done {
+			return nil
+		}
+		// Large objects are copied in several calls.
+		call.RewriteToken(res.RewriteToken)
+	}
+}
+
+type DeletedPage struct {
+	Entries []DeletedEntry
+	Prefix  string
+	Since   string
+	// Unversioned is set when the bucket doesn't keep deleted objects.
+	Unversioned bool
+	// CanRestore is set for admins, the only users allowed to restore.
+	CanRestore bool
+}
+
+// DeletedHandler lists the objects deleted within the window given by the
+// since query parameter, below the prefix one.
+func (s *Server) DeletedHandler(response http.ResponseWriter, request *http.Request) {
+	prefix := request.FormValue("prefix")
+	if prefix != "" && hasBadSegment(prefix) {
+		http.Error(response, "prefix must not contain empty, . or .. segments.", http.StatusBadRequest)
+		return
+	}
+	since, ok := ParseSince(request, time.Now())
+	if !ok {
+		http.Error(response, "since must be an RFC 3339 time or a duration like 24h.", http.StatusBadRequest)
+		return
+	}
+	page := DeletedPage{Prefix: prefix, Since: since.UTC().Format(time.RFC3339), CanRestore: IsAdmin(request)}
+	if versioned, err := s.Versioned(); err == nil && !versioned {
+		page.Unversioned = true
+	}
+	entries, err := s.ListDeleted(request, prefix, since)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"prefix":        prefix,
+			"internalError": err,
+		}).Warn("Failed listing deleted objects.")
+	}
+	page.Entries = entries
+	NoIndex(response)
+	response.Header().Set("Content-type", "text/html")
+	s.Render(response, request, "deleted.html", page)
+}
+
+// UndeleteHandler restores the generation query parameter of a deleted
+// object. Only admins can restore.
+func (s *Server) UndeleteHandler(response http.ResponseWriter, request *http.Request) {
+	if !IsAdmin(request) {
+		writeJSONError(response, http.StatusForbidden, "only admins can restore deleted objects")
+		return
+	}
+	if !RequireJSON(response, request) {
+		return
+	}
+	objectName := mux.Vars(request)["objectName"]
+	generation, ok := parseGeneration(request.FormValue("generation"))
+	if !ok || generation == 0 {
+		writeJSONError(response, http.StatusBadRequest, "generation must be the generation to restore")
+		return
+	}
+	object, err := s.GetGeneration(request.Context(), objectName, generation)
+	if err != nil {
+		writeJSONError(response, http.StatusNotFound, "generation not found")
+		return
+	}
+	if err := s.UndeleteObject(request, object); err != nil {
+		if apiErr, ok := err.(*googleapi.Error); ok {
+			switch apiErr.Code {
+			case http.StatusPreconditionFailed:
+				writeJSONError(response, http.StatusConflict, objectName+" exists again, delete or rename it first")
+				return
+			case http.StatusNotFound:
+				writeJSONError(response, http.StatusNotFound, "generation not found")
+				return
+			}
+		}
+		log.WithFields(log.Fields{
+			"objectName":    objectName,
+			"generation":    generation,
+			"internalError": err,
+		}).Warn("Failed restoring deleted object.")
+		writeJSONError(response, http.StatusBadGateway, err.Error())
+		return
+	}
+	s.Cache.Invalidate()
+	writeJSON(response, http.StatusOK, DeleteResult{Status: "restored"})
+}
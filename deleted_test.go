@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+func TestUndelete(t *testing.T) {
+	setFlag(t, allowUndelete, true)
+	setFlag(t, adminUsers, "alice")
+	bucket := &fakeBucket{}
+	bucket.add("clips/a.mp4", "video", storage.Object{
+		ContentType:  "video/mp4",
+		CacheControl: "public, max-age=60",
+		Metadata:     map[string]string{"title": "A"},
+		Generation:   5,
+	})
+	bucket.remove("clips/a.mp4")
+	s := newTestServer(t, bucket)
+	s.Users = map[string]string{"alice": "secret", "bob": "secret"}
+	handler := s.Handler(s.NewRouter())
+	undelete := func(user string, generation string, contentType string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("POST", "/api/undelete/clips/a.mp4?generation="+generation, nil)
+		request.SetBasicAuth(user, "secret")
+		if contentType != "" {
+			request.Header.Set("Content-Type", contentType)
+		}
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response
+	}
+
+	tests := []struct {
+		name        string
+		user        string
+		generation  string
+		contentType string
+		status      int
+	}{
+		{"non-admin", "bob", "5", "application/json", http.StatusForbidden},
+		{"form post", "alice", "5", "text/plain", http.StatusUnsupportedMediaType},
+		{"unknown generation", "alice", "4", "application/json", http.StatusNotFound},
+		{"restore", "alice", "5", "application/json", http.StatusOK},
+		{"restored already", "alice", "5", "application/json", http.StatusConflict},
+	}
+	for _, test := range tests {
+		if response := undelete(test.user, test.generation, test.contentType); response.Code != test.status {
+			t.Errorf("%s: status %d, want %d: %s", test.name, response.Code, test.status, response.Body)
+		}
+	}
+
+	restored, ok := bucket.objects["clips/a.mp4"]
+	if !ok {
+		t.Fatal("the object wasn't restored")
+	}
+	if string(restored.data) != "video" {
+		t.Errorf("restored data %q, want video", restored.data)
+	}
+	object := restored.object
+	if object.ContentType != "video/mp4" || object.CacheControl != "public, max-age=60" || object.Metadata["title"] != "A" {
+		t.Errorf("restored metadata %q, %q, %v, want those of the deleted generation", object.ContentType, object.CacheControl, object.Metadata)
+	}
+}
+
+func TestUndeleteNeedsFlag(t *testing.T) {
+	s := &Server{Users: map[string]string{"alice": "secret"}}
+	setFlag(t, adminUsers, "alice")
+	handler := s.Handler(s.NewRouter())
+	for _, test := range []struct{ method, path string }{
+		{"GET", "/deleted"},
+		{"POST", "/api/undelete/clips/a.mp4?generation=5"},
+	} {
+		request := httptest.NewRequest(test.method, test.path, nil)
+		request.SetBasicAuth("alice", "secret")
+		request.Header.Set("Content-Type", "application/json")
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		if response.Code != http.StatusNotFound {
+			t.Errorf("%s %s without -allow-undelete: status %d, want %d", test.method, test.path, response.Code, http.StatusNotFound)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+	humanize "github.com/dustin/go-humanize"
+	"github.com/gorilla/mux"
+	"google.golang.org/api/googleapi"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// DiffSide is one of the generations compared on /diff.
+type DiffSide struct {
+	Generation int64
+	// Object is nil when the generation doesn't exist (anymore).
+	Object      *storage.Object
+	DownloadUrl string
+}
+
+// DiffRow is a metadata field of both generations.
+type DiffRow struct {
+	Key     string
+	From    string
+	To      string
+	Changed bool
+}
+
+type DiffPage struct {
+	Name string
+	From DiffSide
+	To   DiffSide
+	Rows []DiffRow
+}
+
+// parseGeneration reads a generation query parameter, 0 when it is missing.
+func parseGeneration(value string) (int64, bool) {
+	if value == "" {
+		return 0, true
+	}
+	generation, err := strconv.ParseInt(value, 10, 64)
+	return generation, err == nil && generation > 0
+}
+
+// diffSide fetches a generation of objectName. Missing generations leave
+// Object nil, other errors are returned.
+func (s *Server) diffSide(request *http.Request, objectName string, generation int64) (DiffSide, error) {
+	side := DiffSide{Generation: generation}
+	object, err := s.GetGeneration(request.Context(), objectName, generation)
+	if err != nil {
+		if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == http.StatusNotFound {
+			return side, nil
+		}
+		return side, err
+	}
+	side.Object = object
+	side.Generation = object.Generation
+	side.DownloadUrl = s.SignObjectWith(object, url.Values{"generation": {strconv.FormatInt(object.Generation, 10)}})
+	return side, nil
+}
+
+// diffRows lists the metadata of both sides, a missing side has empty
+// values.
+func diffRows(from, to *storage.Object) []DiffRow {
+	fields := []struct {
+		key   string
+		value func(*storage.Object) string
+	}{
+		{"generation", func(o *storage.Object) string { return strconv.FormatInt(o.Generation, 10) }},
+		{"size", func(o *storage.Object) string {
+			return humanize.Bytes(o.Size) + " (" + strconv.FormatUint(o.Size, 10) + ")"
+		}},
+		{"md5", func(o *storage.Object) string { return o.Md5Hash }},
+		{"crc32c", func(o *storage.Object) string { return o.Crc32c }},
+		{"updated", func(o *storage.Object) string { return o.Updated }},
+		{"content_type", func(o *storage.Object) string { return o.ContentType }},
+	}
+	rows := make([]DiffRow, 0, len(fields))
+	for _, field := range fields {
+		row := DiffRow{Key: field.key}
+		if from != nil {
+			row.From = field.value(from)
+		}
+		if to != nil {
+			row.To = field.value(to)
+		}
+		row.Changed = row.From != row.To
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// DiffHandler compares the metadata of two generations of an object. from
+// is required, to defaults to the live object.
+func (s *Server) DiffHandler(response http.ResponseWriter, request *http.Request) {
+	objectName := mux.Vars(request)["objectName"]
+	from, fromOk := parseGeneration(request.FormValue("from"))
+	to, toOk := parseGeneration(request.FormValue("to"))
+	if !fromOk || !toOk || from == 0 {
+		http.Error(response, "from must be a generation, to a generation or empty for the live object.", http.StatusBadRequest)
+		return
+	}
+	page := DiffPage{Name: objectName}
+	var err error
+	if page.From, err = s.diffSide(request, objectName, from); err == nil {
+		page.To, err = s.diffSide(request, objectName, to)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"objectName":    objectName,
+			"internalError": err,
+		}).Warn("Failed getting generations for diff.")
+		http.Error(response, "Failed getting the generations.", http.StatusBadGateway)
+		return
+	}
+	page.Rows = diffRows(page.From.Object, page.To.Object)
+	NoIndex(response)
+	response.Header().Set("Content-type", "text/html")
+	s.Render(response, request, "diff.html", page)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+var downloadFilename = flag.String("download-filename", "base", "File name downloads are saved under: key for the full object name, base for its last segment, display for the slug or cleaned up name with the extension, or metadata:FIELD for a metadata field, falling back to base.")
+
+// ValidateDownloadFilename checks -download-filename.
+func ValidateDownloadFilename(value string) error {
+	switch value {
+	case "key", "base", "display":
+		return nil
+	}
+	if field := strings.TrimPrefix(value, "metadata:"); field != value {
+		if field == "" {
+			return errors.New("download filename metadata: needs a field name")
+		}
+		return nil
+	}
+	return fmt.Errorf("download filename must be key, base, display or metadata:FIELD, got %q", value)
+}
+
+// DownloadFilename returns the name object is saved under when downloaded.
+func (s *Server) DownloadFilename(object *storage.Object) string {
+	base := path.Base(object.Name)
+	switch *downloadFilename {
+	case "key":
+		return object.Name
+	case "base":
+		return base
+	case "display":
+		name := path.Base(s.Aliases.DisplayName(object.Name))
+		if extension := path.Ext(object.Name); !strings.HasSuffix(name, extension) {
+			name += extension
+		}
+		return name
+	}
+	if name := object.Metadata[strings.TrimPrefix(*downloadFilename, "metadata:")]; name != "" {
+		return name
+	}
+	return base
+}
+
+// isAttrChar reports whether RFC 5987 lets c appear unescaped in an
+// extended parameter value.
+func isAttrChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", c) >= 0
+}
+
+// ContentDisposition returns a Content-Disposition header value saving as
+// filename. Names that aren't plain ASCII get an RFC 5987 filename* next to
+// an ASCII fallback for old browsers.
+func ContentDisposition(disposition string, filename string) string {
+	fallback := strings.Map(func(r rune) rune {
+		// Some browsers percent-decode filename, so % goes as well.
+		if r < 0x20 || r > 0x7e || r == '"' || r == '\\' || r == '%' {
+			return '_'
+		}
+		return r
+	}, filename)
+	value := disposition + `; filename="` + fallback + `"`
+	if fallback == filename {
+		return value
+	}
+	var encoded strings.Builder
+	for i := 0; i < len(filename); i++ {
+		if c := filename[i]; isAttrChar(c) {
+			encoded.WriteByte(c)
+		} else {
+			fmt.Fprintf(&encoded, "%%%02X", c)
+		}
+	}
+	return value + "; filename*=UTF-8''" + encoded.String()
+}
+
+// AttachmentParams are the signed URL parameters downloading object as
+// DownloadFilename.
+func (s *Server) AttachmentParams(object *storage.Object) url.Values {
+	return url.Values{"response-content-disposition": {ContentDisposition("attachment", s.DownloadFilename(object))}}
+}
+
+// setAttachment makes the proxied generation of objectName a download. A
+// missing object gets the base name, the proxy answers 404 for it anyway.
+func (s *Server) setAttachment(response http.ResponseWriter, request *http.Request, objectName string, generation int64) {
+	filename := path.Base(objectName)
+	if object, err := s.GetGeneration(request.Context(), objectName, generation); err == nil {
+		filename = s.DownloadFilename(object)
+	}
+	response.Header().Set("Content-Disposition", ContentDisposition("attachment", filename))
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"mime"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"google.golang.org/api/googleapi"
+	storage "google.golang.org/api/storage/v1"
+)
+
+var allowEdit = flag.Bool("allow-edit", false, "Let admins edit the custom metadata, content type and cache control of objects at /edit/.")
+
+// Custom metadata is sent as x-goog-meta- headers by the XML API, so keys
+// must be header tokens. GCS allows 8 KiB of keys and values per object.
+var metadataKeyRegexp = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+.^_`|~-]+$")
+
+const maxMetadataSize = 8 << 10
+
+// MetadataEdit is the JSON body of /api/metadata/, the metadata an object
+// should have afterwards. Metageneration is the one the edit was based on,
+// edits of an object changed meanwhile are refused.
+type MetadataEdit struct {
+	ContentType    string            `json:"contentType"`
+	CacheControl   string            `json:"cacheControl"`
+	Metadata       map[string]string `json:"metadata"`
+	Metageneration int64             `json:"metageneration"`
+}
+
+// MetadataChange is one field a MetadataEdit changes. Metadata keys are
+// fields of their own.
+type MetadataChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// validText reports whether value is UTF-8 without control characters.
+func validText(value string) bool {
+	return utf8.ValidString(value) && strings.IndexFunc(value, unicode.IsControl) < 0
+}
+
+// ValidateMetadataEdit checks the fields of edit before they are sent to the
+// bucket.
+func ValidateMetadataEdit(edit MetadataEdit) error {
+	if edit.ContentType != "" {
+		if _, _, err := mime.ParseMediaType(edit.ContentType); err != nil {
+			return fmt.Errorf("content type %q is invalid: %v", edit.ContentType, err)
+		}
+	}
+	if !validText(edit.CacheControl) {
+		return errors.New("cache control must not contain control characters")
+	}
+	size := 0
+	for key, value := range edit.Metadata {
+		if !metadataKeyRegexp.MatchString(key) {
+			return fmt.Errorf("metadata key %q must be letters, digits or one of !#$%%&'*+-.^_`|~", key)
+		}
+		if !validText(value) {
+			return fmt.Errorf("metadata value of %q must not contain control characters", key)
+		}
+		size += len(key) + len(value)
+	}
+	if size > maxMetadataSize {
+		return fmt.Errorf("metadata is %d bytes, at most %d are allowed", size, maxMetadataSize)
+	}
+	return nil
+}
+
+// MetadataChanges lists what edit changes about object, the built-in fields
+// first and metadata keys in order.
+func MetadataChanges(object *storage.Object, edit MetadataEdit) []MetadataChange {
+	var changes []MetadataChange
+	if object.ContentType != edit.ContentType {
+		changes = append(changes, MetadataChange{Field: "contentType", Old: object.ContentType, New: edit.ContentType})
+	}
+	if object.CacheControl != edit.CacheControl {
+		changes = append(changes, MetadataChange{Field: "cacheControl", Old: object.CacheControl, New: edit.CacheControl})
+	}
+	keys := make(map[string]bool)
+	for key := range object.Metadata {
+		keys[key] = true
+	}
+	for key := range edit.Metadata {
+		keys[key] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+	for _, key := range sorted {
+		old, hadKey := object.Metadata[key]
+		value, hasKey := edit.Metadata[key]
+		if old != value || hadKey != hasKey {
+			changes = append(changes, MetadataChange{Field: "metadata." + key, Old: old, New: value})
+		}
+	}
+	return changes
+}
+
+// metadataPatch turns edit into a patch of object. Patches merge metadata,
+// so only changed keys are sent and removed ones are sent as null, as are
+// cleared fields.
+func metadataPatch(object *storage.Object, edit MetadataEdit) *storage.Object {
+	patch := &storage.Object{ContentType: edit.ContentType, CacheControl: edit.CacheControl}
+	if edit.ContentType == "" {
+		patch.NullFields = append(patch.NullFields, "ContentType")
+	}
+	if edit.CacheControl == "" {
+		patch.NullFields = append(patch.NullFields, "CacheControl")
+	}
+	for key, value := range edit.Metadata {
+		if old, ok := object.Metadata[key]; !ok || old != value {
+			if patch.Metadata == nil {
+				patch.Metadata = make(map[string]string)
+			}
+			patch.Metadata[key] = value
+		}
+	}
+	for key := range object.Metadata {
+		if _, ok := edit.Metadata[key]; !ok {
+			patch.NullFields = append(patch.NullFields, "Metadata."+key)
+		}
+	}
+	return patch
+}
+
+// EditMetadata applies edit to object unless it was changed since, or only
+// records the intent in dry-run mode.
+func (s *Server) EditMetadata(request *http.Request, object *storage.Object, edit MetadataEdit, changes []MetadataChange) error {
+	Audit(request, "edit-metadata", log.Fields{"objectName": object.Name, "changes": changes})
+	if *dryRun {
+		return nil
+	}
+	_, err := s.Storage().Objects.Patch(bucketName, StorageName(object.Name), metadataPatch(object, edit)).
+		IfMetagenerationMatch(edit.Metageneration).Context(request.Context()).Do()
+	if err != nil {
+		return err
+	}
+	// Listings carry the metadata, sorting and filters look at it.
+	s.Cache.Invalidate()
+	return nil
+}
+
+// EditPage is the model rendered by edit.html.
+type EditPage struct {
+	Name           string
+	ObjectName     string
+	ContentType    string
+	CacheControl   string
+	Metageneration int64
+	// Keys are the metadata keys in order.
+	Keys     []string
+	Metadata map[string]string
+}
+
+// EditHandler shows the metadata editor of an object to admins.
+func (s *Server) EditHandler(response http.ResponseWriter, request *http.Request) {
+	if !IsAdmin(request) {
+		http.Error(response, "Only admins can edit metadata.", http.StatusForbidden)
+		return
+	}
+	objectName := mux.Vars(request)["objectName"]
+	object, err := s.GetObject(request.Context(), objectName)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"objectName":    objectName,
+			"internalError": err,
+		}).Warn("Failed getting object to edit.")
+		http.NotFound(response, request)
+		return
+	}
+	keys := make([]string, 0, len(object.Metadata))
+	for key := range object.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	NoIndex(response)
+	response.Header().Set("Content-type", "text/html")
+	// Pages left open would submit a stale metageneration.
+	response.Header().Set("Cache-Control", "no-store")
+	s.Render(response, request, "edit.html", EditPage{
+		Name:           CleanupName(object.Name),
+		ObjectName:     object.Name,
+		ContentType:    object.ContentType,
+		CacheControl:   object.CacheControl,
+		Metageneration: object.Metageneration,
+		Keys:           keys,
+		Metadata:       object.Metadata,
+	})
+}
+
+type metadataResult struct {
+	Name    string           `json:"name"`
+	Changes []MetadataChange `json:"changes"`
+	Applied bool             `json:"applied"`
+	DryRun  bool             `json:"dryRun,omitempty"`
+}
+
+// MetadataHandler lists the changes of a MetadataEdit with preview=1 and
+// applies them otherwise.
+func (s *Server) MetadataHandler(response http.ResponseWriter, request *http.Request) {
+	if !IsAdmin(request) {
+		writeJSONError(response, http.StatusForbidden, "only admins can edit metadata")
+		return
+	}
+	if !RequireJSON(response, request) {
+		return
+	}
+	objectName := mux.Vars(request)["objectName"]
+	var edit MetadataEdit
+	if err := json.NewDecoder(request.Body).Decode(&edit); err != nil {
+		writeJSONError(response, http.StatusBadRequest, "expected a JSON object with contentType, cacheControl, metadata and metageneration")
+		return
+	}
+	if err := ValidateMetadataEdit(edit); err != nil {
+		writeJSONError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	object, err := s.GetObject(request.Context(), objectName)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"objectName":    objectName,
+			"internalError": err,
+		}).Warn("Failed getting object to edit.")
+		writeJSONError(response, http.StatusNotFound, "object not found")
+		return
+	}
+	if object.Metageneration != edit.Metageneration {
+		writeJSONError(response, http.StatusConflict, "the object was changed meanwhile, reload to see its current metadata")
+		return
+	}
+	changes := MetadataChanges(object, edit)
+	result := metadataResult{Name: objectName, Changes: changes, DryRun: *dryRun}
+	if request.FormValue("preview") == "1" || len(changes) == 0 {
+		writeJSON(response, http.StatusOK, result)
+		return
+	}
+	if err := s.EditMetadata(request, object, edit, changes); err != nil {
+		if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == http.StatusPreconditionFailed {
+			writeJSONError(response, http.StatusConflict, "the object was changed meanwhile, reload to see its current metadata")
+			return
+		}
+		log.WithFields(log.Fields{
+			"objectName":    objectName,
+			"internalError": err,
+		}).Warn("Failed editing object metadata.")
+		writeJSONError(response, http.StatusBadGateway, err.Error())
+		return
+	}
+	result.Applied = true
+	writeJSON(response, http.StatusOK, result)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+	"image"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+	storage "google.golang.org/api/storage/v1"
+)
+
+var embedExpiry = flag.Duration("embed-expiry", time.Hour, "How long the URLs returned by /api/embed are valid.")
+
+// EmbedInfo is what an external system needs to show an object and to fetch
+// a fresh URL before the current one expires.
+type EmbedInfo struct {
+	Name        string `json:"name"`
+	ContentType string `json:"contentType"`
+	Url         string `json:"url"`
+	// ExpiresAt is null for URLs that don't expire.
+	ExpiresAt *time.Time `json:"expiresAt"`
+	Width     int        `json:"width,omitempty"`
+	Height    int        `json:"height,omitempty"`
+}
+
+// SignedUntil returns when a URL signed now for expiry lapses, or nil when
+// URLs aren't signed.
+func SignedUntil(now time.Time, expiry time.Duration) *time.Time {
+	if *proxyOnly || *publicBucket {
+		return nil
+	}
+	// Restricted links aren't GCS URLs, the V4 limit doesn't apply.
+	if *signingVersion == "v4" && !IPRestricted() && expiry > maxV4Expiry {
+		expiry = maxV4Expiry
+	}
+	expiresAt := now.Add(expiry).UTC().Truncate(time.Second)
+	return &expiresAt
+}
+
+// dimensions returns the size of object from its width and height metadata,
+// reading the header of images without them.
+func (s *Server) dimensions(ctx context.Context, object *storage.Object) (int, int) {
+	width, _ := strconv.Atoi(object.Metadata["width"])
+	height, _ := strconv.Atoi(object.Metadata["height"])
+	if width > 0 && height > 0 || MediaKind(object) != "image" {
+		return width, height
+	}
+	res, err := s.Storage().Objects.Get(bucketName, StorageName(object.Name)).Context(ctx).Download()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"objectName":    object.Name,
+			"internalError": err,
+		}).Warn("Failed downloading image.")
+		return 0, 0
+	}
+	defer res.Body.Close()
+	config, _, err := image.DecodeConfig(res.Body)
+	if err != nil {
+		return 0, 0
+	}
+	return config.Width, config.Height
+}
+
+// EmbedHandler returns the metadata and a signed URL of an object together
+// with the exact time the URL expires.
+func (s *Server) EmbedHandler(response http.ResponseWriter, request *http.Request) {
+	objectName := mux.Vars(request)["objectName"]
+	object, err := s.GetObject(request.Context(), objectName)
+	if err != nil {
+		writeJSONError(response, http.StatusNotFound, "object not found")
+		return
+	}
+	// Take the time before signing, so the URL is valid at least until the
+	// returned expiry.
+	expiresAt := SignedUntil(time.Now(), *embedExpiry)
+	signedUrl := s.signUrl(object.Name, nil, *embedExpiry)
+	if signedUrl == "" {
+		writeJSONError(response, http.StatusInternalServerError, "could not sign url")
+		return
+	}
+	width, height := s.dimensions(request.Context(), object)
+	response.Header().Set("Cache-Control", "no-store")
+	writeJSON(response, http.StatusOK, EmbedInfo{
+		Name:        object.Name,
+		ContentType: ContentType(object),
+		Url:         signedUrl,
+		ExpiresAt:   expiresAt,
+		Width:       width,
+		Height:      height,
+	})
+}
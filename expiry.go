@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"time"
+
+	humanize "github.com/dustin/go-humanize"
+	storage "google.golang.org/api/storage/v1"
+)
+
+var (
+	objectTTLDays     = flag.Int("object-ttl-days", 0, "Age in days at which a lifecycle rule deletes objects. When set, pages show when each object is expected to go. This is a display hint only.")
+	expiryWarningDays = flag.Int("expiry-warning-days", 3, "Objects expected to be deleted within this many days are highlighted.")
+)
+
+// Expiry is when a lifecycle rule is expected to delete an object.
+type Expiry struct {
+	At   time.Time
+	Soon bool
+}
+
+// In describes the expiry relative to now, like "3 days from now".
+func (e Expiry) In() string {
+	return humanize.Time(e.At)
+}
+
+// CreatedTime parses the creation time of object, see UpdatedTime.
+func CreatedTime(object *storage.Object) time.Time {
+	created, err := time.Parse(time.RFC3339Nano, object.TimeCreated)
+	if err != nil {
+		return time.Time{}
+	}
+	return created
+}
+
+// ExpiryOf estimates when object expires from -object-ttl-days, or returns
+// nil without a TTL or creation time. Objects from the search index have no
+// creation time.
+func ExpiryOf(object *storage.Object) *Expiry {
+	created := CreatedTime(object)
+	if *objectTTLDays <= 0 || created.IsZero() {
+		return nil
+	}
+	at := created.AddDate(0, 0, *objectTTLDays)
+	return &Expiry{
+		At:   at,
+		Soon: time.Until(at) < time.Duration(*expiryWarningDays)*24*time.Hour,
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+// fakeBucket answers the JSON API requests the handlers make for bucketName
+// from memory: object metadata, media downloads with ranges, listings with
+// folders, rewrites and media uploads.
+type fakeBucket struct {
+	mutex   sync.Mutex
+	objects map[string]*fakeObject
+	// deleted are the last generations of deleted objects, as a versioned
+	// bucket keeps them.
+	deleted map[string]*fakeObject
+	// metadata and media count the requests of each kind.
+	metadata int
+	media    int
+}
+
+type fakeObject struct {
+	object storage.Object
+	data   []byte
+}
+
+// add stores data as storageName, the remaining metadata taken from object.
+func (b *fakeBucket) add(storageName string, data string, object storage.Object) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.objects == nil {
+		b.objects = make(map[string]*fakeObject)
+	}
+	object.Name = storageName
+	object.Bucket = bucketName
+	object.Size = uint64(len(data))
+	if object.Generation == 0 {
+		object.Generation = 1
+	}
+	if object.Updated == "" {
+		object.Updated = "2020-01-01T00:00:00Z"
+	}
+	b.objects[storageName] = &fakeObject{object: object, data: []byte(data)}
+}
+
+// remove deletes the live object storageName, keeping its generation.
+func (b *fakeBucket) remove(storageName string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.deleted == nil {
+		b.deleted = make(map[string]*fakeObject)
+	}
+	stored := b.objects[storageName]
+	stored.object.TimeDeleted = "2020-01-02T00:00:00Z"
+	b.deleted[storageName] = stored
+	delete(b.objects, storageName)
+}
+
+// find returns the live object storageName, or the given generation of it,
+// which may have been deleted.
+func (b *fakeBucket) find(storageName string, generation string) (*fakeObject, bool) {
+	if generation == "" {
+		stored, ok := b.objects[storageName]
+		return stored, ok
+	}
+	for _, stored := range []*fakeObject{b.objects[storageName], b.deleted[storageName]} {
+		if stored != nil && generation == strconv.FormatInt(stored.object.Generation, 10) {
+			return stored, true
+		}
+	}
+	return nil, false
+}
+
+func fakeError(response http.ResponseWriter, code int, message string) {
+	response.Header().Set("Content-Type", "application/json")
+	response.WriteHeader(code)
+	json.NewEncoder(response).Encode(map[string]interface{}{"error": map[string]interface{}{"code": code, "message": message}})
+}
+
+// counts returns how many metadata and media requests were made.
+func (b *fakeBucket) counts() (int, int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.metadata, b.media
+}
+
+func (b *fakeBucket) ServeHTTP(response http.ResponseWriter, request *http.Request) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	base := "/storage/v1/b/" + bucketName + "/o"
+	path := request.URL.EscapedPath()
+	// Media uploads go to /upload/storage/v1 or, with a base path that isn't
+	// the API's, to the base path itself.
+	path = strings.TrimPrefix(path, "/upload")
+	if !strings.HasPrefix(path, base) {
+		http.NotFound(response, request)
+		return
+	}
+	if path == base && request.Method == "POST" {
+		b.insert(response, request)
+		return
+	}
+	if path == base {
+		b.list(response, request)
+		return
+	}
+	names := strings.SplitN(strings.TrimPrefix(path, base+"/"), "/rewriteTo/b/"+bucketName+"/o/", 2)
+	if len(names) == 2 {
+		b.rewrite(response, request, names[0], names[1])
+		return
+	}
+	name, err := url.PathUnescape(names[0])
+	stored, ok := b.find(name, request.URL.Query().Get("generation"))
+	if err != nil || !ok {
+		fakeError(response, http.StatusNotFound, "No such object.")
+		return
+	}
+	if request.URL.Query().Get("alt") == "media" {
+		b.media++
+		response.Header().Set("Content-Type", stored.object.ContentType)
+		http.ServeContent(response, request, "", time.Time{}, bytes.NewReader(stored.data))
+		return
+	}
+	b.metadata++
+	response.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(response).Encode(stored.object)
+}
+
+// rewrite copies a generation of source to destination. Like the API, it
+// takes the metadata of the copy from the request body only.
+func (b *fakeBucket) rewrite(response http.ResponseWriter, request *http.Request, source string, destination string) {
+	query := request.URL.Query()
+	source, sourceErr := url.PathUnescape(source)
+	destination, destinationErr := url.PathUnescape(destination)
+	stored, ok := b.find(source, query.Get("sourceGeneration"))
+	if sourceErr != nil || destinationErr != nil || !ok {
+		fakeError(response, http.StatusNotFound, "No such object.")
+		return
+	}
+	if _, exists := b.objects[destination]; exists && query.Get("ifGenerationMatch") == "0" {
+		fakeError(response, http.StatusPreconditionFailed, "Precondition failed.")
+		return
+	}
+	var copied storage.Object
+	if err := json.NewDecoder(request.Body).Decode(&copied); err != nil {
+		fakeError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	copied.Name = destination
+	copied.Bucket = bucketName
+	copied.Size = stored.object.Size
+	copied.Generation = stored.object.Generation + 1
+	copied.Updated = "2020-01-03T00:00:00Z"
+	b.objects[destination] = &fakeObject{object: copied, data: stored.data}
+	response.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(response).Encode(storage.RewriteResponse{Done: true, Resource: &copied})
+}
+
+// insert stores a multipart media upload. Like the API, it refuses to
+// replace an existing object when ifGenerationMatch is 0.
+func (b *fakeBucket) insert(response http.ResponseWriter, request *http.Request) {
+	_, params, err := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	if err != nil {
+		fakeError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	reader := multipart.NewReader(request.Body, params["boundary"])
+	var object storage.Object
+	part, err := reader.NextPart()
+	if err == nil {
+		err = json.NewDecoder(part).Decode(&object)
+	}
+	var data []byte
+	if err == nil {
+		if part, err = reader.NextPart(); err == nil {
+			data, err = ioutil.ReadAll(part)
+		}
+	}
+	if err != nil {
+		fakeError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	generation := int64(1)
+	if stored, exists := b.objects[object.Name]; exists {
+		if request.URL.Query().Get("ifGenerationMatch") == "0" {
+			fakeError(response, http.StatusPreconditionFailed, "Precondition failed.")
+			return
+		}
+		generation = stored.object.Generation + 1
+	}
+	if b.objects == nil {
+		b.objects = make(map[string]*fakeObject)
+	}
+	object.Bucket = bucketName
+	object.Size = uint64(len(data))
+	object.Generation = generation
+	object.Updated = "2020-01-03T00:00:00Z"
+	b.objects[object.Name] = &fakeObject{object: object, data: data}
+	response.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(response).Encode(object)
+}
+
+func (b *fakeBucket) list(response http.ResponseWriter, request *http.Request) {
+	prefix := request.URL.Query().Get("prefix")
+	var names []string
+	for name := range b.objects {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	listing := storage.Objects{Items: []*storage.Object{}}
+	separator := request.URL.Query().Get("delimiter")
+	folders := make(map[string]bool)
+	for _, name := range names {
+		// Names continuing past the separator are rolled up into a folder.
+		if i := strings.Index(name[len(prefix):], separator); separator != "" && i >= 0 {
+			folder := name[:len(prefix)+i+len(separator)]
+			if !folders[folder] {
+				folders[folder] = true
+				listing.Prefixes = append(listing.Prefixes, folder)
+			}
+			continue
+		}
+		object := b.objects[name].object
+		listing.Items = append(listing.Items, &object)
+	}
+	response.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(response).Encode(project(listing, request.URL.Query().Get("fields")))
+}
+
+// project keeps the item fields that a fields parameter such as
+// listingFields asks for, like the API does.
+func project(listing storage.Objects, fields string) interface{} {
+	start := strings.Index(fields, "items(")
+	if start < 0 {
+		return listing
+	}
+	fields = fields[start+len("items("):]
+	keep := strings.Split(fields[:strings.Index(fields, ")")], ",")
+	items := []map[string]interface{}{}
+	for _, item := range listing.Items {
+		encoded, _ := json.Marshal(item)
+		var all map[string]interface{}
+		json.Unmarshal(encoded, &all)
+		projected := make(map[string]interface{})
+		for _, key := range keep {
+			if value, ok := all[key]; ok {
+				projected[key] = value
+			}
+		}
+		items = append(items, projected)
+	}
+	return map[string]interface{}{"items": items, "prefixes": listing.Prefixes}
+}
+
+// newTestServer returns a Server whose storage client talks to bucket.
+func newTestServer(t testing.TB, bucket *fakeBucket) *Server {
+	t.Helper()
+	fake := httptest.NewServer(bucket)
+	t.Cleanup(fake.Close)
+	service, err := storage.New(fake.Client())
+	if err != nil {
+		t.Fatal(err)
+	}
+	service.BasePath = fake.URL + "/storage/v1/"
+	s := new(Server)
+	s.SetCredentials(&Credentials{Service: service})
+	s.Cache = NewListingCache()
+	return s
+}
+
+// setFlag changes a flag for the rest of the test.
+func setFlag[T any](t testing.TB, flag *T, value T) {
+	t.Helper()
+	old := *flag
+	*flag = value
+	t.Cleanup(func() { *flag = old })
+}
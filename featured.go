@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+var (
+	featuredFile     = flag.String("featured", "", "File of object names shown in a Featured row above the index, one per line in the order they are shown. Objects that aren't listed are skipped. Reloaded by -admin-users through /admin/reload-featured.")
+	featuredMetadata = flag.Bool("featured-metadata", false, "Feature objects whose featured metadata is true as well, after those of -featured.")
+)
+
+// Featured holds the object names of -featured.
+type Featured struct {
+	mutex sync.RWMutex
+	names []string
+}
+
+// ReadFeatured reads the object names from a file, skipping empty lines,
+// comments starting with # and repeated names.
+func ReadFeatured(filename string) ([]string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var names []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || seen[line] {
+			continue
+		}
+		seen[line] = true
+		names = append(names, line)
+	}
+	return names, scanner.Err()
+}
+
+// Load replaces the names with the ones in filename. The old names stay in
+// place when the file can't be read.
+func (f *Featured) Load(filename string) (int, error) {
+	return loadLocked(&f.mutex, filename, ReadFeatured, func(names []string) int {
+		f.names = names
+		return len(names)
+	})
+}
+
+// Names returns the featured object names in order.
+func (f *Featured) Names() []string {
+	f.mutex.RLock()
+	defer f.mutex.RUnlock()
+	return f.names
+}
+
+// FeaturedObjects picks the featured objects out of listing, those of
+// -featured in their order, followed by those labelled with -featured-metadata
+// newest first. Blocked, hidden and unavailable objects aren't in listing or
+// are dropped, so they never show.
+func (s *Server) FeaturedObjects(listing []*storage.Object) []*storage.Object {
+	names := s.Featured.Names()
+	if len(names) == 0 && !*featuredMetadata {
+		return nil
+	}
+	byName := make(map[string]*storage.Object, len(listing))
+	for _, object := range listing {
+		byName[object.Name] = object
+	}
+	var featured []*storage.Object
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if object, ok := byName[name]; ok && Available(object) {
+			featured = append(featured, object)
+			seen[name] = true
+		}
+	}
+	if *featuredMetadata {
+		labelled := keep(listing, func(object *storage.Object) bool {
+			return !seen[object.Name] && Available(object) && object.Metadata["featured"] == "true"
+		})
+		SortByUpdated(labelled)
+		featured = append(featured, labelled...)
+	}
+	return featured
+}
+
+// ReloadFeaturedHandler re-reads -featured.
+func (s *Server) ReloadFeaturedHandler(response http.ResponseWriter, request *http.Request) {
+	reloadFile(response, request, fileReload{
+		name:     "featured",
+		flag:     "featured",
+		filename: *featuredFile,
+		what:     "featured objects",
+		countKey: "featured",
+		load:     s.Featured.Load,
+	})
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"flag"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	storage "google.golang.org/api/storage/v1"
+)
+
+var (
+	feedItems    = flag.Int("feed-items", 50, "Number of the newest videos in /feed.xml.")
+	feedCacheTTL = flag.Duration("feed-cache-ttl", 30*time.Second, "How long each rendered /feed.xml is reused, per prefix.")
+)
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Atom    string     `xml:"xmlns:atom,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Self        rssSelf   `xml:"atom:link"`
+	Items       []rssItem `xml:"item"`
+}
+
+// rssSelf is the URL of the feed itself, which feed readers use to tell
+// the feeds of different prefixes apart.
+type rssSelf struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr"`
+	Type string `xml:"type,attr"`
+}
+
+type rssItem struct {
+	Title     string       `xml:"title"`
+	Link      string       `xml:"link"`
+	Guid      rssGuid      `xml:"guid"`
+	PubDate   string       `xml:"pubDate,omitempty"`
+	Enclosure rssEnclosure `xml:"enclosure"`
+}
+
+type rssGuid struct {
+	Value       string `xml:",chardata"`
+	IsPermaLink bool   `xml:"isPermaLink,attr"`
+}
+
+type rssEnclosure struct {
+	Url    string `xml:"url,attr"`
+	Length uint64 `xml:"length,attr"`
+	Type   string `xml:"type,attr"`
+}
+
+type feedEntry struct {
+	body  []byte
+	built time.Time
+}
+
+// FeedCache keeps rendered feeds for -feed-cache-ttl, by origin and prefix
+// as the links in a feed depend on both.
+type FeedCache struct {
+	mutex   sync.Mutex
+	entries map[string]feedEntry
+}
+
+func (c *FeedCache) get(key string) ([]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.entries[key]
+	if !ok || time.Since(entry.built) > *feedCacheTTL {
+		return nil, false
+	}
+	return entry.body, true
+}
+
+func (c *FeedCache) put(key string, body []byte) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]feedEntry)
+	}
+	// Drop what expired so that odd prefixes don't pile up.
+	for other, entry := range c.entries {
+		if time.Since(entry.built) > *feedCacheTTL {
+			delete(c.entries, other)
+		}
+	}
+	c.entries[key] = feedEntry{body: body, built: time.Now()}
+}
+
+// feedItem describes object for the feed. The enclosure goes through /raw/
+// so that it doesn't expire like a signed URL would.
+func (s *Server) feedItem(origin string, object *storage.Object) rssItem {
+	item := rssItem{
+		Title: s.Aliases.DisplayName(object.Name),
+		Link:  origin + Link("/play/", s.Aliases.PlayPath(object.Name)),
+		// A replaced object is a new episode.
+		Guid: rssGuid{Value: bucketName + "/" + object.Name + "#" + strconv.FormatInt(object.Generation, 10)},
+		Enclosure: rssEnclosure{
+			Url:    origin + Link("/raw/", ObjectPath(object.Name)),
+			Length: object.Size,
+			Type:   ContentType(object),
+		},
+	}
+	if updated, err := time.Parse(time.RFC3339Nano, object.Updated); err == nil {
+		item.PubDate = updated.Format(time.RFC1123Z)
+	}
+	return item
+}
+
+// BuildFeed renders the feed of the newest videos below prefix.
+func (s *Server) BuildFeed(request *http.Request, prefix string) ([]byte, error) {
+	items, err := s.CachedObjects(request.Context(), prefix)
+	if err != nil {
+		return nil, err
+	}
+	videos := keep(FilterVideos(items), Available)
+	SortByUpdated(videos)
+	if *feedItems > 0 && len(videos) > *feedItems {
+		videos = videos[:*feedItems]
+	}
+
+	origin := requestOrigin(request)
+	title := Translate(*defaultLang, "videos")
+	link := origin + Link("/")
+	self := origin + Link("/feed.xml")
+	if prefix != "" {
+		title += " - " + strings.TrimSuffix(prefix, delimiter)
+		link += "?" + url.Values{"prefix": {prefix}}.Encode()
+		self += "?" + url.Values{"prefix": {prefix}}.Encode()
+	}
+	feed := rssFeed{
+		Version: "2.0",
+		Atom:    "http://www.w3.org/2005/Atom",
+		Channel: rssChannel{
+			Title:       title,
+			Link:        link,
+			Description: title + " (" + bucketName + "/" + prefix + ")",
+			Self:        rssSelf{Href: self, Rel: "self", Type: "application/rss+xml"},
+			Items:       make([]rssItem, 0, len(videos)),
+		},
+	}
+	for _, video := range videos {
+		feed.Channel.Items = append(feed.Channel.Items, s.feedItem(origin, video))
+	}
+	var body bytes.Buffer
+	body.WriteString(xml.Header)
+	encoder := xml.NewEncoder(&body)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(feed); err != nil {
+		return nil, err
+	}
+	return body.Bytes(), nil
+}
+
+// FeedHandler serves the RSS feed of the videos below the prefix query
+// parameter, the whole bucket without one.
+func (s *Server) FeedHandler(response http.ResponseWriter, request *http.Request) {
+	prefix := request.FormValue("prefix")
+	if prefix != "" && hasBadSegment(prefix) {
+		http.Error(response, "prefix must not contain empty, . or .. segments.", http.StatusBadRequest)
+		return
+	}
+	key := requestOrigin(request) + "\x00" + prefix
+	body, ok := s.Feeds.get(key)
+	if !ok {
+		var err error
+		body, err = s.BuildFeed(request, prefix)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"prefix":        prefix,
+				"internalError": err,
+			}).Warn("Failed building feed.")
+			http.Error(response, "Failed listing the videos.", http.StatusBadGateway)
+			return
+		}
+		s.Feeds.put(key, body)
+	}
+	response.Header().Set("Content-type", "application/rss+xml; charset=utf-8")
+	response.Write(body)
+}
@@ -1,25 +1,27 @@
 package main
 
 import (
+	"crypto/rand"
 	"flag"
 	"fmt"
 	"html/template"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
 	"regexp"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 	humanize "github.com/dustin/go-humanize"
 	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/net/context"
-	"golang.org/x/oauth2/google"
+	"golang.org/x/time/rate"
 	storage "google.golang.org/api/storage/v1"
-	cloud "google.golang.org/cloud/storage"
+	transcoder "google.golang.org/api/transcoder/v1"
 )
 
 const (
@@ -31,47 +33,231 @@ const (
 )
 
 var (
-	jsonFile       = flag.String("creds", "key.json", "A path to your JSON key file for your service account downloaded from Google Developer Console, not needed if you run it on Compute Engine instances.")
-	host           = flag.String("host", "0.0.0.0", "IP of host to run webserver on")
-	port           = flag.Int("port", 8080, "Port to run webserver on")
-	googleAccessId = flag.String("googleAccessId", "115985846185-gmc25e88t3ochacb6hednp2obujn0c5k@developer.gserviceaccount.com", "Google service account client email address xx@developer.gserviceaccount.com")
-	pemFilename    = flag.String("pemFilename", "key.pem", "Google Service Account PEM file.")
+	jsonFile               = flag.String("creds", "key.json", "A path to your JSON key file for your service account downloaded from Google Developer Console, not needed if you run it on Compute Engine instances.")
+	host                   = flag.String("host", "0.0.0.0", "IP of host to run webserver on")
+	port                   = flag.Int("port", 8080, "Port to run webserver on")
+	googleAccessId         = flag.String("googleAccessId", "115985846185-gmc25e88t3ochacb6hednp2obujn0c5k@developer.gserviceaccount.com", "Google service account client email address xx@developer.gserviceaccount.com")
+	pemFilename            = flag.String("pemFilename", "key.pem", "Google Service Account PEM file.")
+	foldersFirst           = flag.Bool("folders-first", true, "List folders before files in the browse view.")
+	cookieSecret           = flag.String("cookie-secret", "", "Secret used to sign share tokens and cookies. A random secret is generated when empty, which invalidates them on restart.")
+	users                  = flag.String("users", "", "Comma separated list of user:password pairs. When set, HTTP basic auth is required.")
+	verifyListing          = flag.Bool("verify-listing", true, "Show listed objects with zero size or missing metadata as unavailable instead of linking to them.")
+	verifyObjects          = flag.Bool("verify-objects", false, "Check that an object exists before redirecting to or returning its signed URL. Costs an extra request per link.")
+	maxBodySize            = flag.Int64("max-body-size", 1<<20, "Maximum size in bytes of non-GET request bodies, 0 for no limit.")
+	maxUploadSize          = flag.Int64("max-upload-size", 10<<30, "Maximum size in bytes of an upload request, 0 for no limit.")
+	maxConcurrentDownloads = flag.Int("max-concurrent-downloads", 0, "Maximum number of downloads streamed through the server at once, 0 for unlimited.")
+	defaultCacheControl    = flag.String("default-cache-control", "", "Cache-Control header used for objects without Cache-Control metadata.")
+	signCacheControl       = flag.Bool("signed-cache-control", false, "Also apply -default-cache-control to signed URLs through response-cache-control.")
+	signContentType        = flag.Bool("signed-content-type", false, "Set response-content-type on signed URLs of objects stored without a usable content type.")
+	caseInsensitive        = flag.Bool("case-insensitive", false, "Redirect requests for missing objects to an object whose name only differs in case.")
+	dryRun                 = flag.Bool("dry-run", false, "Log the bucket changes mutating handlers would make and report success without performing them.")
+	fullMetadata           = flag.Bool("full-metadata", false, "Fetch the full metadata of every listed object instead of only the fields the pages use.")
+	allowUpload            = flag.Bool("allow-upload", false, "Allow authenticated users to upload files. Existing objects are only replaced by uploads with overwrite=1.")
+	channels               = flag.String("channels", "", "Comma separated list of name=prefix pairs shown as channels in the navigation bar, e.g. news=news/,sports=sports/")
 )
 
+var stripExtension = regexp.MustCompile("\\.(mp4|m3u8)$")
+
 func fatalf(service *storage.Service, errorMessage string, args ...interface{}) {
 	log.Fatalf("Dying with error:\n"+errorMessage, args...)
 }
 
 type Server struct {
-	StorageService       *storage.Service
-	Templates            *template.Template
-	StorageAccessOptions *cloud.SignedURLOptions
+	Templates          *template.Template
+	Channels           []Channel
+	Users              map[string]string
+	CookieSecret       []byte
+	DownloadSlots      chan struct{}
+	Cache              *ListingCache
+	Index              *ObjectIndex
+	LocalizedTemplates map[string]*template.Template
+	DownloadLimiter    *rate.Limiter
+	Previews           *PreviewCache
+	Signing            SigningHealth
+	ListPipeline       []string
+	HidePattern        *regexp.Regexp
+	GroupPattern       *regexp.Regexp
+	Banner             BannerBoard
+	Blocked            BlockList
+	Aliases            Aliases
+	Featured           Featured
+	Transcoder         *transcoder.Service
+	Transcodes         TranscodeJobs
+	Buckets            BucketCache
+	// BucketListings caches the listings of -search-buckets by bucket name.
+	BucketListings *ListingCache
+	UploadHooks    []UploadHook
+	Warmth         CacheWarmth
+	Feeds          FeedCache
+
+	credentialsMutex sync.RWMutex
+	credentials      *Credentials
+}
+
+// Channel is a friendly name for an object name prefix.
+type Channel struct {
+	Name   string
+	Prefix string
+}
+
+// ParseChannels parses a comma separated list of name=prefix pairs, keeping
+// the order in which they were given.
+func ParseChannels(input string) ([]Channel, error) {
+	var result []Channel
+	seen := make(map[string]bool)
+	for _, pair := range strings.Split(input, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid channel %q, expected name=prefix", pair)
+		}
+		if seen[parts[0]] {
+			return nil, fmt.Errorf("duplicate channel %q", parts[0])
+		}
+		seen[parts[0]] = true
+		result = append(result, Channel{Name: parts[0], Prefix: parts[1]})
+	}
+	return result, nil
+}
+
+func (s *Server) FindChannel(name string) (Channel, bool) {
+	for _, channel := range s.Channels {
+		if channel.Name == name {
+			return channel, true
+		}
+	}
+	return Channel{}, false
 }
 
-type ByUpdated []*storage.Object
+// IndexPage is the model rendered by index.html.
+type IndexPage struct {
+	Items []*storage.Object
+	// Featured are the objects of the Featured row, only set on the first
+	// page when not searching.
+	Featured      []*storage.Object
+	Channels      []Channel
+	ActiveChannel string
+	Recent        []string
+	Query         string
+	Sort          SortOption
+	Media         string
+	MinSize       string
+	MaxSize       string
+	Posters       map[string]string
+	// Links are the pre-signed links of Items and Featured by name.
+	Links      map[string]ObjectLink
+	Pagination Pagination
+	// URL is the request URL the navigation links are built from, State
+	// the browsing state they preserve.
+	URL   string
+	State string
+	// View is the layout, list or grid.
+	View string
+	// Prefix is the folder the search is scoped to.
+	Prefix string
+	// Groups are the sections of the current page, a single one without a
+	// title unless -group-regex is set.
+	Groups []ObjectGroup
+	// ListingETag is only set with -auto-refresh-seconds, the page reloads
+	// once the listing below RefreshPrefix no longer matches it.
+	ListingETag   string
+	RefreshPrefix string
+}
 
-func (a ByUpdated) Len() int           { return len(a) }
-func (a ByUpdated) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
-func (a ByUpdated) Less(i, j int) bool { return a[i].Updated > a[j].Updated }
+// ByUpdated sorts the newest objects first, objects updated at the same time
+// by name.
+type ByUpdated []sortItem
 
-func (s *Server) SignUrl(objectName string) string {
-	s.StorageAccessOptions.Expires = time.Now().Add(time.Second * 60 * 60 * 6) //expire in 6 hours
-	getURL, err := cloud.SignedURL(bucketName, UrlEscape(objectName), s.StorageAccessOptions)
-	if err == nil {
-		return getURL
-	} else {
+func (a ByUpdated) Len() int      { return len(a) }
+func (a ByUpdated) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a ByUpdated) Less(i, j int) bool {
+	if !a[i].updated.Equal(a[j].updated) {
+		return a[i].updated.After(a[j].updated)
+	}
+	return a[i].object.Name < a[j].object.Name
+}
+
+// SortByUpdated sorts items in place using ByUpdated.
+func SortByUpdated(items []*storage.Object) {
+	wrapped := newSortItems(items)
+	sort.Stable(ByUpdated(wrapped))
+	for i, item := range wrapped {
+		items[i] = item.object
+	}
+}
+
+func IsVideo(object *storage.Object) bool {
+	return strings.HasSuffix(object.Name, ".mp4") || IsStream(object.Name)
+}
+
+// Available reports whether the listing metadata describes an object that
+// can be served. Objects deleted while being listed show up without size or
+// timestamps.
+func Available(object *storage.Object) bool {
+	if !*verifyListing {
+		return true
+	}
+	return object.Size > 0 && object.Updated != ""
+}
+
+// VerifyObject reports whether objectName exists. It only asks the bucket
+// when -verify-objects is set and trusts the caller otherwise.
+func (s *Server) VerifyObject(ctx context.Context, objectName string) bool {
+	if !*verifyObjects {
+		return true
+	}
+	if _, err := s.GetObject(ctx, objectName); err != nil {
 		log.WithFields(log.Fields{
 			"objectName":    objectName,
 			"internalError": err,
-		}).Warn("Error signing URL.")
-		return ""
+		}).Info("Refusing to sign missing object.")
+		return false
 	}
+	return true
+}
+
+// FilterByName returns the objects whose name contains query, ignoring case.
+// The result is always a new slice, even for an empty query.
+func FilterByName(objectList []*storage.Object, query string) []*storage.Object {
+	query = strings.ToLower(query)
+	var result = make([]*storage.Object, 0, len(objectList))
+	for _, object := range objectList {
+		if strings.Contains(strings.ToLower(object.Name), query) {
+			result = append(result, object)
+		}
+	}
+	return result
+}
+
+// FindCaseInsensitive looks for an object whose name differs from objectName
+// only in case. It is a no-op unless -case-insensitive is set as it needs
+// the listing of the whole bucket.
+func (s *Server) FindCaseInsensitive(ctx context.Context, objectName string) (string, bool) {
+	if !*caseInsensitive {
+		return "", false
+	}
+	items, err := s.CachedObjects(ctx, "")
+	if err != nil {
+		log.WithFields(log.Fields{
+			"internalError": err,
+		}).Warn("Failed getting video list.")
+		return "", false
+	}
+	for _, item := range items {
+		if item.Name != objectName && strings.EqualFold(item.Name, objectName) {
+			return item.Name, true
+		}
+	}
+	return "", false
 }
 
 func FilterVideos(objectList []*storage.Object) []*storage.Object {
 	var videoObjects = make([]*storage.Object, 0, len(objectList))
 	for _, object := range objectList {
-		if strings.HasSuffix(object.Name, ".mp4") {
+		if IsVideo(object) {
 			videoObjects = append(videoObjects, object)
 		}
 	}
@@ -79,89 +265,716 @@ func FilterVideos(objectList []*storage.Object) []*storage.Object {
 }
 
 func CleanupName(objectName string) string {
-	return strings.TrimSuffix(objectName, ".mp4")
+	return stripExtension.ReplaceAllString(objectName, "")
+}
+
+// listingFields are the object fields the pages need from a listing.
+const listingFields = "nextPageToken,prefixes,items(name,size,updated,timeCreated,contentType,cacheControl,generation,metadata)"
+
+// ObjectsList starts an object listing that only fetches listingFields
+// unless -full-metadata is set.
+func (s *Server) ObjectsList() *storage.ObjectsListCall {
+	call := s.Storage().Objects.List(bucketName)
+	if !*fullMetadata {
+		call.Fields(listingFields)
+	}
+	return call
+}
+
+// ListObjects returns every object whose name starts with prefix, following
+// the listing across all result pages.
+func (s *Server) ListObjects(ctx context.Context, prefix string) ([]*storage.Object, error) {
+	ctx, span := StartSpan(ctx, "storage.list", attribute.String("prefix", prefix))
+	var items []*storage.Object
+	err := s.ListPages(detach(ctx), prefix, func(page []*storage.Object) error {
+		items = append(items, page...)
+		return nil
+	})
+	span.SetAttributes(attribute.Int("objects", len(items)))
+	EndSpan(span, err)
+	if err != nil {
+		return nil, RequesterPaysHint(err)
+	}
+	return items, nil
+}
+
+// ListPages calls handle with the objects of every listing page as it
+// arrives. It stops at the first error returned by handle or when ctx is
+// done.
+func (s *Server) ListPages(ctx context.Context, prefix string, handle func([]*storage.Object) error) error {
+	return listPages(s.ObjectsList().Context(ctx), prefix, func(page []*storage.Object) error {
+		return handle(s.Blocked.Hide(HidePlaceholders(page)))
+	})
+}
+
+// listPages is ListPages for a prepared listing call, keeping folder
+// placeholders.
+func listPages(call *storage.ObjectsListCall, prefix string, handle func([]*storage.Object) error) error {
+	if prefix := StorageName(prefix); prefix != "" {
+		call.Prefix(prefix)
+	}
+	for {
+		res, err := call.Do()
+		if err != nil {
+			return err
+		}
+		if err := handle(VirtualObjects(res.Items)); err != nil {
+			return err
+		}
+		if res.NextPageToken == "" {
+			return nil
+		}
+		call.PageToken(res.NextPageToken)
+	}
+}
+
+// IndexPrefixes returns the prefixes IndexObjects lists for prefix.
+func (s *Server) IndexPrefixes(prefix string) []string {
+	if len(s.Channels) == 0 || prefix != "" {
+		return []string{prefix}
+	}
+	prefixes := make([]string, 0, len(s.Channels))
+	for _, channel := range s.Channels {
+		prefixes = append(prefixes, channel.Prefix)
+	}
+	return prefixes
+}
+
+// IndexObjects returns the objects shown on the index matching query:
+// everything in the bucket, or only what is in a channel when channels are
+// configured. A prefix scopes the search to a folder instead, which only
+// lists that folder.
+func (s *Server) IndexObjects(ctx context.Context, prefix string, query string) []*storage.Object {
+	if len(s.Channels) == 0 || prefix != "" {
+		items, err := s.SearchObjects(ctx, prefix, query)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"internalError": err,
+			}).Warn("Failed getting video list.")
+		}
+		return items
+	}
+
+	var items []*storage.Object
+	seen := make(map[string]bool)
+	for _, channel := range s.Channels {
+		channelItems, err := s.SearchObjects(ctx, channel.Prefix, query)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"channel":       channel.Name,
+				"internalError": err,
+			}).Warn("Failed getting video list.")
+			continue
+		}
+		// Channel prefixes may overlap.
+		for _, item := range channelItems {
+			if !seen[item.Name] {
+				seen[item.Name] = true
+				items = append(items, item)
+			}
+		}
+	}
+	SortByUpdated(items)
+	return items
+}
+
+// NewIndexPage filters, sorts and paginates items as requested. prefixes are
+// the ones items were listed from, see PrefetchPage.
+func (s *Server) NewIndexPage(request *http.Request, prefixes []string, items []*storage.Object) (IndexPage, error) {
+	filter, err := s.ListFilter(request)
+	if err != nil {
+		return IndexPage{}, err
+	}
+	option := ParseSort(request)
+	listing := items
+	items = filter(items)
+	var featured []*storage.Object
+	if request.FormValue("q") == "" && ParsePage(request) == 1 {
+		featured = s.FeaturedObjects(items)
+	}
+	SortObjects(items, option)
+	sorted := items
+	items, pagination := Paginate(sorted, ParsePage(request), *pageSize)
+	if next := pagination.Next(); next != 0 && *prefetchNext {
+		nextItems, _ := Paginate(sorted, next, *pageSize)
+		s.PrefetchPage(prefixes, nextItems, listing)
+	}
+	posters := s.Posters(items, listing)
+	for name, poster := range s.Posters(featured, listing) {
+		posters[name] = poster
+	}
+	return IndexPage{
+		Items:      items,
+		Featured:   featured,
+		Channels:   s.Channels,
+		Query:      request.FormValue("q"),
+		Sort:       option,
+		Media:      ParseMedia(request),
+		MinSize:    request.FormValue("minSize"),
+		MaxSize:    request.FormValue("maxSize"),
+		Posters:    posters,
+		Links:      s.PresignLinks(items, featured),
+		Pagination: pagination,
+		URL:        Link(request.URL.RequestURI()),
+		State:      BrowsingState(request),
+		Groups:     GroupObjects(s.GroupPattern, items),
+	}, nil
 }
 
 func (s *Server) RootHandler(response http.ResponseWriter, request *http.Request) {
+	if target := s.HomeTarget(request); target != "" {
+		http.Redirect(response, request, target, http.StatusFound)
+		return
+	}
 	response.Header().Set("Content-type", "text/html")
 
-	// List all objects in a bucket
-	res, err := s.StorageService.Objects.List(bucketName).Do()
+	prefix := request.FormValue("prefix")
+	page, err := s.NewIndexPage(request, s.IndexPrefixes(prefix), s.IndexObjects(request.Context(), prefix, request.FormValue("q")))
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page.Recent = s.RecentlyPlayed(request)
+	page.Prefix = prefix
+	page.View = ChooseView(response, request)
+	s.SetRefresh(request, &page, prefix)
+	s.Render(response, request, "index.html", page)
+}
+
+func (s *Server) ChannelHandler(response http.ResponseWriter, request *http.Request) {
+	channel, ok := s.FindChannel(mux.Vars(request)["channelName"])
+	if !ok {
+		http.NotFound(response, request)
+		return
+	}
+	response.Header().Set("Content-type", "text/html")
+
+	items, err := s.SearchObjects(request.Context(), channel.Prefix, request.FormValue("q"))
 	if err != nil {
 		log.WithFields(log.Fields{
+			"channel":       channel.Name,
 			"internalError": err,
 		}).Warn("Failed getting video list.")
 	}
 
-	sort.Sort(ByUpdated(res.Items))
-
-	s.Templates.ExecuteTemplate(response, "index.html", res)
+	page, err := s.NewIndexPage(request, []string{channel.Prefix}, items)
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page.ActiveChannel = channel.Name
+	page.View = ChooseView(response, request)
+	s.SetRefresh(request, &page, channel.Prefix)
+	s.Render(response, request, "index.html", page)
 }
 
+// VideoInfo is what the play pages show. VideoUrl is the URL of the media
+// whatever its kind.
 type VideoInfo struct {
 	Name        string
-	VideoUrl    string
-	SubUrl      string
-	DownloadUrl string
+	ObjectName  string
+	Kind        string
+	ContentType string
+	Size        uint64
+	// Preview is the rendered content of small text objects.
+	Preview  template.HTML
+	VideoUrl string
+	SubUrl   string
+	// ThumbnailsUrl is the track of the seek bar thumbnails, "" without
+	// one.
+	ThumbnailsUrl string
+	DownloadUrl   string
+	Stream        bool
+	CanDelete     bool
+	DeleteBlocked string
+	ConsoleUrl    string
+	CanEdit       bool
+	ACL           *ObjectACL
+	Poster        string
+	Prev          string
+	Next          string
+	// State is the browsing state the video was opened from, which orders
+	// Prev and Next.
+	State string
+	// Autoplay continues with Next when the video ends.
+	Autoplay bool
+}
+
+// playTemplates are the play pages per media kind, other objects get the
+// generic play.html.
+var playTemplates = map[string]string{
+	"video": "play_video.html",
+	"audio": "play_audio.html",
+	"image": "play_image.html",
+}
+
+// PlayTemplate returns the play page for object.
+func PlayTemplate(object *storage.Object) string {
+	if name, ok := playTemplates[MediaKind(object)]; ok {
+		return name
+	}
+	return "play.html"
 }
 
 func UrlEscape(input string) string {
 	return strings.Replace(url.QueryEscape(input), "+", "%20", -1)
 }
 
+// ConsoleUrl returns the page of objectName in the Google Cloud Console.
+func ConsoleUrl(objectName string) string {
+	return "https://console.cloud.google.com/storage/browser/_details/" + bucketName + "/" +
+		string(ObjectPath(StorageName(objectName))) + "?project=" + projectID
+}
+
+// ObjectPath escapes objectName for use in a URL path, keeping the slashes
+// between its segments.
+func ObjectPath(objectName string) template.URL {
+	segments := strings.Split(objectName, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return template.URL(strings.Join(segments, "/"))
+}
+
 func (s *Server) PlayHandler(response http.ResponseWriter, request *http.Request) {
-	response.Header().Set("Content-type", "text/html")
 	vars := mux.Vars(request)
-	objectName := vars["objectName"]
+	objectName := s.Aliases.Resolve(vars["objectName"])
+	// BlockObjects only saw the slug.
+	if objectName != vars["objectName"] && s.Blocked.Blocked(objectName) {
+		logBlocked(request, objectName)
+		http.Error(response, "Forbidden", http.StatusForbidden)
+		return
+	}
 
 	// List all objects in a bucket
-	res, err := s.StorageService.Objects.Get(bucketName, objectName).Do()
+	res, err := s.GetObject(request.Context(), objectName)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"objectName":    objectName,
 			"internalError": err,
 		}).Warn("Failed getting info for video.")
+		if canonical, ok := s.FindCaseInsensitive(request.Context(), objectName); ok {
+			target := url.URL{Path: "/play/" + canonical, RawQuery: request.URL.RawQuery}
+			http.Redirect(response, request, Link(target.String()), http.StatusMovedPermanently)
+			return
+		}
+		http.NotFound(response, request)
+		return
+	}
+
+	subName := stripExtension.ReplaceAllString(res.Name, ".vtt")
+
+	_, span := StartSpan(request.Context(), "sign", attribute.String("object", res.Name))
+	var info VideoInfo
+	if IsStream(res.Name) {
+		info = VideoInfo{
+			Name:     CleanupName(res.Name),
+			VideoUrl: HLSPath(res.Name),
+			SubUrl:   s.SignUrlFor(subName, *inlineExpiry),
+			Stream:   true,
+		}
+	} else {
+		signedUrl := s.signObject(res, nil, *inlineExpiry)
+		info = VideoInfo{
+			Name:        CleanupName(res.Name),
+			VideoUrl:    signedUrl,
+			SubUrl:      s.SignUrlFor(subName, *inlineExpiry),
+			DownloadUrl: s.SignObjectFor(res, *downloadExpiryHours, s.AttachmentParams(res)),
+		}
+	}
+	EndSpan(span, nil)
+	info.Kind = MediaKind(res)
+	info.ContentType = ContentType(res)
+	info.Size = res.Size
+	if info.Kind == "video" {
+		info.ThumbnailsUrl = s.ThumbnailsUrl(request.Context(), res)
+	}
+
+	NoIndex(response)
+	response.Header().Add("Vary", "Accept")
+	if WantsJSON(request) {
+		writeJSON(response, http.StatusOK, VideoMetadata{
+			Name:          res.Name,
+			ContentType:   res.ContentType,
+			Size:          res.Size,
+			Updated:       res.Updated,
+			Url:           info.VideoUrl,
+			SubUrl:        info.SubUrl,
+			ThumbnailsUrl: info.ThumbnailsUrl,
+			DownloadUrl:   info.DownloadUrl,
+			Stream:        info.Stream,
+		})
+		return
+	}
+
+	info.ObjectName = res.Name
+	info.Poster = s.Poster(request.Context(), res)
+	info.CanDelete = *allowDelete && CurrentUser(request) != ""
+	info.DeleteBlocked = DeleteBlockedReason(res)
+	info.State = BrowsingState(request)
+	info.Autoplay = request.FormValue("autoplay") == "1"
+	if order, err := s.PlayOrder(request); err == nil {
+		info.Prev, info.Next, _ = Neighbors(order, res.Name)
+	} else {
+		log.WithFields(log.Fields{
+			"objectName":    res.Name,
+			"internalError": err,
+		}).Warn("Failed getting neighbors of video.")
+	}
+	if IsAdmin(request) {
+		info.ConsoleUrl = ConsoleUrl(res.Name)
+		info.CanEdit = *allowEdit
+		if *allowACL {
+			info.ACL = s.ObjectACLFor(res.Name)
+		}
 	}
 
-	mkvRegexp := regexp.MustCompile("\\.mp4$")
-	signedUrl := s.SignUrl(res.Name)
+	response.Header().Set("Content-type", "text/html")
+	s.RememberPlayed(response, request, res.Name)
+	if info.Kind == "other" && TextPreviewable(res) {
+		info.Preview, err = s.TextPreview(request.Context(), res)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"objectName":    res.Name,
+				"internalError": err,
+			}).Warn("Failed fetching text preview.")
+		}
+	}
+	playCounts.Increment(res.Name)
+	s.Render(response, request, PlayTemplate(res), info)
+}
 
-	info := VideoInfo{
-		Name:        CleanupName(res.Name),
-		VideoUrl:    signedUrl,
-		SubUrl:      s.SignUrl(mkvRegexp.ReplaceAllString(res.Name, ".vtt")),
-		DownloadUrl: signedUrl + "&response-content-disposition=attachment%3B%20filename%3D%22" + UrlEscape(res.Name),
+// RawHandler redirects straight to the signed URL of an object.
+func (s *Server) RawHandler(response http.ResponseWriter, request *http.Request) {
+	objectName := mux.Vars(request)["objectName"]
+	if !s.VerifyObject(request.Context(), objectName) {
+		http.NotFound(response, request)
+		return
 	}
+	signedUrl := s.SignUrlContext(request.Context(), objectName)
+	if signedUrl == "" {
+		http.Error(response, "Could not sign URL.", http.StatusInternalServerError)
+		return
+	}
+	NoIndex(response)
+	http.Redirect(response, request, signedUrl, http.StatusFound)
+}
 
-	s.Templates.ExecuteTemplate(response, "play.html", info)
+// NewRouter registers the routes enabled by the flags.
+func (s *Server) NewRouter() *mux.Router {
+	r := mux.NewRouter().StrictSlash(false)
+	r.MethodNotAllowedHandler = MethodNotAllowed(r)
+	r.Use(NameSpans)
+	r.Use(s.BlockObjects)
+	r.HandleFunc("/", s.RootHandler).Methods(readMethods...)
+	r.HandleFunc("/robots.txt", RobotsHandler).Methods(readMethods...)
+	r.HandleFunc("/readyz", s.ReadyzHandler).Methods(readMethods...)
+	r.HandleFunc("/metrics", s.MetricsHandler).Methods(readMethods...)
+	r.PathPrefix("/static/").Methods(readMethods...).Handler(StaticHandler())
+	r.HandleFunc("/play/{objectName:.*}", s.PlayHandler).Methods(readMethods...)
+	r.HandleFunc("/channel/{channelName}", s.ChannelHandler).Methods(readMethods...)
+	r.HandleFunc("/hls/{objectName:.*}", s.HLSHandler).Methods(readMethods...)
+	r.HandleFunc("/thumbnails/{objectName:.+}", s.ThumbnailsHandler).Methods(readMethods...)
+	r.HandleFunc("/raw/{objectName:.*}", s.RawHandler).Methods(readMethods...)
+	r.HandleFunc("/preview/{objectName:.*}", s.PreviewHandler).Methods(readMethods...)
+	r.HandleFunc("/api/url/{objectName:.*}", s.URLHandler).Methods(readMethods...)
+	r.HandleFunc("/url/{objectName:.*}", s.TextURLHandler).Methods(readMethods...)
+	r.HandleFunc("/api/embed/{objectName:.*}", s.EmbedHandler).Methods(readMethods...)
+	r.HandleFunc("/api/suggest", s.SuggestHandler).Methods(readMethods...)
+	r.HandleFunc("/api/next", s.NextHandler).Methods(readMethods...)
+	r.HandleFunc("/api/objects/stream", s.StreamHandler).Methods(readMethods...)
+	r.HandleFunc("/api/changes", s.ChangesHandler).Methods(readMethods...)
+	r.HandleFunc("/verify/{objectName:.*}", s.RequireUser(s.VerifyHandler)).Methods(readMethods...)
+	r.HandleFunc("/api/inventory.jsonl", s.RequireUser(s.InventoryHandler)).Methods(readMethods...)
+	r.HandleFunc("/api/buckets", s.RequireUser(s.BucketsHandler)).Methods(readMethods...)
+	r.HandleFunc("/search", s.RequireUser(s.SearchHandler)).Methods(readMethods...)
+	r.HandleFunc("/random", s.RandomHandler).Methods(readMethods...)
+	r.HandleFunc("/feed.xml", s.FeedHandler).Methods(readMethods...)
+	r.HandleFunc("/diff/{objectName:.*}", s.RequireUser(s.DiffHandler)).Methods(readMethods...)
+	r.HandleFunc("/s/{token}", s.ShareHandler).Methods(readMethods...)
+	if IPRestricted() {
+		r.HandleFunc("/r/{token}", s.RestrictedHandler).Methods(readMethods...)
+	}
+	if *proxyOnly {
+		r.HandleFunc("/download/{objectName:.*}", s.DownloadHandler).Methods(readMethods...)
+	}
+	r.HandleFunc("/share/{objectName:.*}", s.RequireUser(s.ShareLinkHandler)).Methods(readMethods...)
+	if *allowDelete {
+		r.HandleFunc("/api/bulk-delete", s.RequireUser(s.BulkDeleteHandler)).Methods("POST")
+	}
+	if TrashEnabled() {
+		r.HandleFunc("/trash", s.RequireUser(s.TrashHandler)).Methods(readMethods...)
+		r.HandleFunc("/api/restore/{objectName:.*}", s.RequireUser(s.RestoreHandler)).Methods("POST")
+	}
+	r.HandleFunc("/admin/reload-creds", s.RequireUser(s.ReloadCredsHandler)).Methods("POST")
+	r.HandleFunc("/admin/reload-blocked", s.RequireUser(s.ReloadBlockedHandler)).Methods("POST")
+	r.HandleFunc("/admin/reload-aliases", s.RequireUser(s.ReloadAliasesHandler)).Methods("POST")
+	r.HandleFunc("/admin/reload-featured", s.RequireUser(s.ReloadFeaturedHandler)).Methods("POST")
+	r.HandleFunc("/admin/debug/storage", s.RequireUser(s.DebugStorageHandler)).Methods(readMethods...)
+	r.HandleFunc("/admin/banner", s.RequireUser(s.BannerHandler)).Methods("GET", "POST")
+	if *allowACL {
+		r.HandleFunc("/api/acl/{objectName:.*}", s.RequireUser(s.ACLHandler)).Methods("POST")
+	}
+	if *allowUndelete {
+		r.HandleFunc("/deleted", s.RequireUser(s.DeletedHandler)).Methods(readMethods...)
+		r.HandleFunc("/api/undelete/{objectName:.*}", s.RequireUser(s.UndeleteHandler)).Methods("POST")
+	}
+	if *allowEdit {
+		r.HandleFunc("/edit/{objectName:.*}", s.RequireUser(s.EditHandler)).Methods(readMethods...)
+		r.HandleFunc("/api/metadata/{objectName:.*}", s.RequireUser(s.MetadataHandler)).Methods("POST")
+	}
+	if *allowUpload {
+		r.HandleFunc("/upload", s.RequireUser(s.UploadHandler)).Methods("POST")
+	}
+	if *enableTranscode {
+		r.HandleFunc("/transcode/{objectName:.*}", s.RequireUser(s.TranscodeHandler)).Methods("POST")
+		r.HandleFunc("/api/transcode/{objectName:.*}", s.RequireUser(s.TranscodeStatusHandler)).Methods("GET")
+	}
+	r.HandleFunc("/browse/", s.BrowseHandler).Methods(readMethods...)
+	r.HandleFunc("/browse/{prefix:.*}", s.BrowseHandler).Methods(readMethods...)
+	return r
 }
 
 func main() {
 	flag.Parse()
+	log.AddHook(redactHook{})
+	if *debug {
+		log.SetLevel(log.DebugLevel)
+	}
 
 	if *jsonFile != "" {
 		os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", *jsonFile)
 	}
-	client, err := google.DefaultClient(context.Background(), scope)
+	if *signingVersion != "v2" && *signingVersion != "v4" {
+		log.WithFields(log.Fields{
+			"signingVersion": *signingVersion,
+		}).Fatal("Unknown signing version, expected v2 or v4.")
+	}
+	creds, err := LoadCredentials()
 	if err != nil {
-		log.Fatalf("Unable to get default client: %v", err)
+		log.Fatal(err)
 	}
 
-	service, err := storage.New(client)
+	root, err := ValidateRootPrefix(*rootPrefix)
 	if err != nil {
-		log.Fatalf("Unable to create storage service: %v", err)
+		log.WithFields(log.Fields{
+			"rootPrefix": *rootPrefix,
+		}).Fatal(err)
 	}
-
-	// Settings for signed url
-	pemFile, err := ioutil.ReadFile(*pemFilename)
+	*rootPrefix = root
+	external, err := ValidateExternalUrl(*externalUrl)
 	if err != nil {
 		log.WithFields(log.Fields{
-			"PEM File": pemFilename,
+			"externalUrl": *externalUrl,
 		}).Fatal(err)
 	}
+	*externalUrl = external
+	if err := ValidateSort(*defaultSort, *defaultOrder); err != nil {
+		log.Fatal(err)
+	}
+	if err := ValidateView(*defaultView); err != nil {
+		log.Fatal(err)
+	}
+	if err := ValidateCacheBustParam(*cacheBustParam); err != nil {
+		log.Fatal(err)
+	}
+	if *inlineExpiry <= 0 || *shareExpiry <= 0 || *downloadExpiryHours <= 0 {
+		log.Fatal("-inline-expiry, -share-expiry and -download-expiry-hours must be positive.")
+	}
+	if *signingVersion == "v4" && !IPRestricted() && *inlineExpiry > maxV4Expiry {
+		log.WithFields(log.Fields{
+			"inlineExpiry": *inlineExpiry,
+		}).Warn("V4 signed URLs are valid for seven days at most, -inline-expiry is capped.")
+	}
+	trash, err := ValidateTrashPrefix(*trashPrefix)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"trashPrefix": *trashPrefix,
+		}).Fatal(err)
+	}
+	*trashPrefix = trash
+	home, err := ValidateHomeRedirect(*homeRedirect)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"homeRedirect": *homeRedirect,
+		}).Fatal(err)
+	}
+	*homeRedirect = home
 
 	server := new(Server)
-	server.StorageService = service
+	server.SetCredentials(creds)
+	server.Users, err = ParseUsers(*users)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *cookieSecret != "" {
+		server.CookieSecret = []byte(*cookieSecret)
+	} else {
+		server.CookieSecret = make([]byte, 32)
+		if _, err := rand.Read(server.CookieSecret); err != nil {
+			log.Fatalf("Unable to generate cookie secret: %v", err)
+		}
+		log.Warn("No -cookie-secret given, share and -sign-ip-restrict links will not survive a restart.")
+	}
+	if *maxConcurrentDownloads > 0 {
+		server.DownloadSlots = make(chan struct{}, *maxConcurrentDownloads)
+	}
+	server.DownloadLimiter = NewRateLimiter(*downloadGlobalRateKbps)
+	server.Cache = NewListingCache()
+	server.Previews = NewPreviewCache()
+	server.BucketListings = NewListingCache()
+	if *indexDB != "" {
+		server.Index, err = OpenObjectIndex(*indexDB)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"indexDB": *indexDB,
+			}).Fatal(err)
+		}
+		go server.RunIndexer(*indexInterval)
+	}
+	if *pubsubSubscription != "" {
+		service, err := NewPubsubService()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"subscription": *pubsubSubscription,
+			}).Fatal(err)
+		}
+		go server.RunNotifications(service, *pubsubSubscription)
+	}
+	if *enableTranscode {
+		if *transcodeProject == "" {
+			log.Fatal("-enable-transcode requires -transcode-project.")
+		}
+		server.Transcoder, err = NewTranscoderService()
+		if err != nil {
+			log.WithFields(log.Fields{
+				"transcodeProject": *transcodeProject,
+			}).Fatal(err)
+		}
+	}
+	if TrashEnabled() && *trashRetentionDays > 0 {
+		go server.RunTrashPurge(*trashPurgeInterval)
+	}
+	if *playCountsFile != "" && *playCountsObject != "" {
+		log.Fatal("-play-counts-file and -play-counts-object are mutually exclusive.")
+	}
+	var countsStore playCountsStore
+	if *playCountsFile != "" {
+		countsStore = playCountsFileStore(*playCountsFile)
+	}
+	if *playCountsObject != "" {
+		countsStore = playCountsObjectStore{server: server, name: *playCountsObject}
+	}
+	if countsStore != nil {
+		if err := playCounts.Load(countsStore); err != nil {
+			log.WithFields(log.Fields{
+				"playCounts": countsStore.String(),
+			}).Fatal(err)
+		}
+		go playCounts.RunSaver(countsStore, *playCountsSaveInterval)
+		playCounts.SaveOnExit(countsStore)
+	}
+	if *webhookUrl != "" {
+		go server.RunWebhook(&Webhook{
+			Url:       *webhookUrl,
+			Secret:    []byte(*webhookSecret),
+			StateFile: *webhookState,
+			Client:    &http.Client{Timeout: 30 * time.Second},
+		}, *webhookInterval)
+	}
+	if *blockedNames != "" {
+		if _, err := server.Blocked.Load(*blockedNames); err != nil {
+			log.WithFields(log.Fields{
+				"blockedNames": *blockedNames,
+			}).Fatal(err)
+		}
+	}
+	if *aliasesFile != "" {
+		if _, err := server.Aliases.Load(*aliasesFile); err != nil {
+			log.WithFields(log.Fields{
+				"aliases": *aliasesFile,
+			}).Fatal(err)
+		}
+	}
+	if *featuredFile != "" {
+		if _, err := server.Featured.Load(*featuredFile); err != nil {
+			log.WithFields(log.Fields{
+				"featured": *featuredFile,
+			}).Fatal(err)
+		}
+	}
+	banner, err := ValidateBanner(Banner{Text: *bannerText, Level: *bannerLevel})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"bannerLevel": *bannerLevel,
+		}).Fatal(err)
+	}
+	server.Banner.Set(banner)
+	server.ListPipeline, err = ParseListPipeline(*listFilters)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"listFilters": *listFilters,
+		}).Fatal(err)
+	}
+	if *hidePattern != "" {
+		server.HidePattern, err = regexp.Compile(*hidePattern)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"hidePattern": *hidePattern,
+			}).Fatal(err)
+		}
+	}
+	if *groupRegex != "" {
+		server.GroupPattern, err = CompileGroupRegex(*groupRegex)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"groupRegex": *groupRegex,
+			}).Fatal(err)
+		}
+	}
+	contentTypeOverrides, err = ParseContentTypeOverrides(*contentTypeOverridesFlag)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"contentTypeOverrides": *contentTypeOverridesFlag,
+		}).Fatal(err)
+	}
+	restrictedNetworks, err = ParseNetworks(*signIPRestrict)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"signIPRestrict": *signIPRestrict,
+		}).Fatal(err)
+	}
+	// GCS object names are at most 1024 bytes.
+	if *uploadMaxNameLength <= 0 || *uploadMaxNameLength > 1024 {
+		log.WithFields(log.Fields{
+			"uploadMaxNameLength": *uploadMaxNameLength,
+		}).Fatal("-upload-max-name-length must be between 1 and 1024.")
+	}
+	if *deleteWorkers < 1 {
+		log.WithFields(log.Fields{
+			"deleteWorkers": *deleteWorkers,
+		}).Fatal("-delete-workers must be at least 1.")
+	}
+	if *warmBeforeReady && !*warmCache {
+		log.Fatal("-warm-before-ready requires -warm-cache.")
+	}
+	if err := ValidateDownloadFilename(*downloadFilename); err != nil {
+		log.WithFields(log.Fields{
+			"downloadFilename": *downloadFilename,
+		}).Fatal(err)
+	}
+	server.UploadHooks, err = ParseUploadHooks(*uploadHooksFlag)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"uploadHooks": *uploadHooksFlag,
+		}).Fatal(err)
+	}
+	server.Channels, err = ParseChannels(*channels)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"channels": *channels,
+		}).Fatal(err)
+	}
 
 	humanTime := func(inputTime string) string {
 		parsedTime, err := time.Parse(time.RFC3339Nano, inputTime)
@@ -178,25 +991,59 @@ func main() {
 	server.Templates = template.Must(template.New("main").Funcs(template.FuncMap{
 		"humanSize":    humanize.Bytes,
 		"humanTime":    humanTime,
-		"sign":         server.SignUrl,
+		"expiry":       ExpiryOf,
 		"filterVideos": FilterVideos,
 		"cleanupName":  CleanupName,
+		"isStream":     IsStream,
+		"isVideo":      IsVideo,
+		"canTranscode": CanTranscode,
+		"dryRun":       func() bool { return *dryRun },
+		"banner":       server.Banner.Current,
+		"sortKeys":     SortKeys,
+		"mediaKinds":   MediaKinds,
+		"views":        Views,
+		"buildURL":     BuildURL,
+		"iconFor":      IconFor,
+		"objectPath":   ObjectPath,
+		"playPath":     server.Aliases.PlayPath,
+		"displayName":  server.Aliases.DisplayName,
+		"link":         Link,
+		"t":            func(key string) string { return Translate(*defaultLang, key) },
 	}).ParseGlob("templates/*.html"))
-	server.StorageAccessOptions = &cloud.SignedURLOptions{
-		GoogleAccessID: *googleAccessId,
-		PrivateKey:     pemFile,
-		Method:         "GET",
+	if _, ok := messages[*defaultLang]; !ok {
+		log.WithFields(log.Fields{
+			"defaultLang": *defaultLang,
+		}).Fatal("No translation for the default language.")
+	}
+	server.LocalizedTemplates, err = LocalizeTemplates(server.Templates)
+	if err != nil {
+		log.Fatalf("Unable to localize templates: %v", err)
 	}
 
-	r := mux.NewRouter().StrictSlash(false)
-	r.HandleFunc("/", server.RootHandler)
-	r.HandleFunc("/play/{objectName}", server.PlayHandler)
+	if *tracing {
+		if err := InitTracing(); err != nil {
+			log.Fatalf("Unable to set up tracing: %v", err)
+		}
+	}
+
+	r := server.NewRouter()
 
 	addr := fmt.Sprintf("%s:%d", *host, *port)
+	server.LogConfig(addr)
+	if *publicBucket && !*proxyOnly {
+		server.CheckPublic()
+	}
+	server.CheckSigning()
+	if *signingCheckInterval > 0 {
+		go server.RunSigningCheck(*signingCheckInterval)
+	}
+	if *warmCache {
+		server.StartWarmCache()
+	}
 	log.WithFields(
 		log.Fields{
 			"host": *host,
 			"port": *port,
 		}).Info("Starting webserver.")
-	log.Fatal(http.ListenAndServe(addr, r))
+	log.Fatal(http.ListenAndServe(addr, server.Handler(r)))
 }
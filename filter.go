@@ -0,0 +1,178 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+var (
+	listFilters = flag.String("list-filters", "hide,media,name,size,date", "Comma separated filters applied in order to the index listings before sorting: hide, media, name, size and date.")
+	hidePattern = flag.String("hide-pattern", "", "Regular expression of object names hidden from the index listings.")
+)
+
+// ListFilter narrows down a listing.
+type ListFilter func([]*storage.Object) []*storage.Object
+
+// listStages build the filter of each -list-filters stage from the request.
+// Stages return nil when the request doesn't ask them to filter.
+var listStages = map[string]func(s *Server, request *http.Request) (ListFilter, error){
+	"hide": func(s *Server, request *http.Request) (ListFilter, error) {
+		if s.HidePattern == nil {
+			return nil, nil
+		}
+		return HideMatching(s.HidePattern), nil
+	},
+	"media": func(s *Server, request *http.Request) (ListFilter, error) {
+		return MediaFilter(ParseMedia(request)), nil
+	},
+	"name": func(s *Server, request *http.Request) (ListFilter, error) {
+		if query := request.FormValue("q"); query != "" {
+			return NameFilter(query), nil
+		}
+		return nil, nil
+	},
+	"size": func(s *Server, request *http.Request) (ListFilter, error) {
+		sizes, err := ParseSizeRange(request)
+		if err != nil || sizes.Min == 0 && sizes.Max == 0 {
+			return nil, err
+		}
+		return SizeFilter(sizes), nil
+	},
+	"date": func(s *Server, request *http.Request) (ListFilter, error) {
+		dates, err := ParseDateRange(request)
+		if err != nil || dates.Since.IsZero() && dates.Until.IsZero() {
+			return nil, err
+		}
+		return DateFilter(dates), nil
+	},
+}
+
+// ParseListPipeline checks the -list-filters stages.
+func ParseListPipeline(input string) ([]string, error) {
+	var stages []string
+	for _, stage := range strings.Split(input, ",") {
+		stage = strings.TrimSpace(stage)
+		if stage == "" {
+			continue
+		}
+		if _, ok := listStages[stage]; !ok {
+			return nil, fmt.Errorf("unknown list filter %q", stage)
+		}
+		stages = append(stages, stage)
+	}
+	return stages, nil
+}
+
+// Chain applies filters in order. The result is always a new slice, so it
+// can be sorted even when items is a cached listing.
+func Chain(filters ...ListFilter) ListFilter {
+	return func(items []*storage.Object) []*storage.Object {
+		items = append(make([]*storage.Object, 0, len(items)), items...)
+		for _, filter := range filters {
+			if filter != nil {
+				items = filter(items)
+			}
+		}
+		return items
+	}
+}
+
+// ListFilter returns the -list-filters pipeline for the request.
+func (s *Server) ListFilter(request *http.Request) (ListFilter, error) {
+	filters := make([]ListFilter, 0, len(s.ListPipeline))
+	for _, stage := range s.ListPipeline {
+		filter, err := listStages[stage](s, request)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	return Chain(filters...), nil
+}
+
+// keep returns the items matching, reusing their slice.
+func keep(items []*storage.Object, matching func(*storage.Object) bool) []*storage.Object {
+	kept := items[:0]
+	for _, item := range items {
+		if matching(item) {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+// HideMatching hides the objects whose name matches pattern.
+func HideMatching(pattern *regexp.Regexp) ListFilter {
+	return func(items []*storage.Object) []*storage.Object {
+		return keep(items, func(item *storage.Object) bool { return !pattern.MatchString(item.Name) })
+	}
+}
+
+func MediaFilter(kind string) ListFilter {
+	return func(items []*storage.Object) []*storage.Object { return FilterMedia(items, kind) }
+}
+
+func NameFilter(query string) ListFilter {
+	return func(items []*storage.Object) []*storage.Object { return FilterByName(items, query) }
+}
+
+func SizeFilter(sizes SizeRange) ListFilter {
+	return func(items []*storage.Object) []*storage.Object { return FilterBySize(items, sizes.Min, sizes.Max) }
+}
+
+// DateRange limits listings to objects updated in it. Zero times leave the
+// range open.
+type DateRange struct {
+	Since time.Time
+	Until time.Time
+}
+
+// parseDate reads an RFC 3339 time or a plain date. A plain date as the end
+// of a range includes the whole day.
+func parseDate(name string, value string, end bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if date, err := time.Parse(time.RFC3339, value); err == nil {
+		return date, nil
+	}
+	date, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s %q", name, value)
+	}
+	if end {
+		date = date.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return date, nil
+}
+
+// ParseDateRange reads the since and until query parameters.
+func ParseDateRange(request *http.Request) (DateRange, error) {
+	since, err := parseDate("since", request.FormValue("since"), false)
+	if err != nil {
+		return DateRange{}, err
+	}
+	until, err := parseDate("until", request.FormValue("until"), true)
+	if err != nil {
+		return DateRange{}, err
+	}
+	if !until.IsZero() && since.After(until) {
+		return DateRange{}, fmt.Errorf("since is after until")
+	}
+	return DateRange{Since: since, Until: until}, nil
+}
+
+func DateFilter(dates DateRange) ListFilter {
+	return func(items []*storage.Object) []*storage.Object {
+		return keep(items, func(item *storage.Object) bool {
+			updated := UpdatedTime(item)
+			return !updated.Before(dates.Since) && (dates.Until.IsZero() || !updated.After(dates.Until))
+		})
+	}
+}
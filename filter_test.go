@@ -0,0 +1,94 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+// firstFilter keeps the first object only, so its result depends on the
+// filters before it.
+func firstFilter(items []*storage.Object) []*storage.Object {
+	if len(items) > 1 {
+		return items[:1]
+	}
+	return items
+}
+
+func filterFixture() []*storage.Object {
+	return []*storage.Object{
+		{Name: "a/dog.mp4", Size: 10},
+		{Name: "b/cat.mp4", Size: 500},
+		{Name: "c/cat.mp4", Size: 20},
+	}
+}
+
+func listedNames(items []*storage.Object) []string {
+	result := []string{}
+	for _, item := range items {
+		result = append(result, item.Name)
+	}
+	return result
+}
+
+func TestChainOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		filters []ListFilter
+		want    []string
+	}{
+		{"name then first", []ListFilter{NameFilter("cat"), firstFilter}, []string{"b/cat.mp4"}},
+		{"first then name", []ListFilter{firstFilter, NameFilter("cat")}, []string{}},
+		{"name, first, size", []ListFilter{NameFilter("cat"), firstFilter, SizeFilter(SizeRange{Max: 100})}, []string{}},
+		{"name, size, first", []ListFilter{NameFilter("cat"), SizeFilter(SizeRange{Max: 100}), firstFilter}, []string{"c/cat.mp4"}},
+		{"stages left out", []ListFilter{nil, NameFilter("cat"), nil}, []string{"b/cat.mp4", "c/cat.mp4"}},
+		{"no stages", nil, []string{"a/dog.mp4", "b/cat.mp4", "c/cat.mp4"}},
+	}
+	for _, test := range tests {
+		items := filterFixture()
+		if got := listedNames(Chain(test.filters...)(items)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: %v, want %v", test.name, got, test.want)
+		}
+		if got := listedNames(items); !reflect.DeepEqual(got, listedNames(filterFixture())) {
+			t.Errorf("%s: changed the listing it filtered to %v", test.name, got)
+		}
+	}
+}
+
+func TestListFilterFollowsFlagOrder(t *testing.T) {
+	listStages["first"] = func(s *Server, request *http.Request) (ListFilter, error) {
+		return firstFilter, nil
+	}
+	t.Cleanup(func() { delete(listStages, "first") })
+
+	tests := []struct {
+		flag string
+		want []string
+	}{
+		{"name,first", []string{"b/cat.mp4"}},
+		{"first,name", []string{}},
+		{" first , size ", []string{}},
+		{"size,first", []string{"b/cat.mp4"}},
+	}
+	for _, test := range tests {
+		pipeline, err := ParseListPipeline(test.flag)
+		if err != nil {
+			t.Fatalf("ParseListPipeline(%q): %v", test.flag, err)
+		}
+		s := &Server{ListPipeline: pipeline}
+		filter, err := s.ListFilter(httptest.NewRequest("GET", "/?q=cat&minSize=15", nil))
+		if err != nil {
+			t.Fatalf("%q: %v", test.flag, err)
+		}
+		if got := listedNames(filter(filterFixture())); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("-list-filters=%q: %v, want %v", test.flag, got, test.want)
+		}
+	}
+
+	if _, err := ParseListPipeline("name,unknown"); err == nil {
+		t.Errorf("ParseListPipeline accepted an unknown stage")
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"path"
+	"regexp"
+	"strings"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+var groupRegex = flag.String("group-regex", "", `Regular expression with named groups matched against file names to group the index into sections, e.g. (?P<series>.+)\.S(?P<season>\d+)E\d+ makes a section per series and season. Videos not matching go to a section of their own.`)
+
+// ObjectGroup is a section of the index. The Other group holds the objects
+// whose names don't match -group-regex.
+type ObjectGroup struct {
+	Title string
+	Other bool
+	Items []*storage.Object
+}
+
+// CompileGroupRegex compiles -group-regex, which needs at least one named
+// group.
+func CompileGroupRegex(expression string) (*regexp.Regexp, error) {
+	compiled, err := regexp.Compile(expression)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range compiled.SubexpNames() {
+		if name != "" {
+			return compiled, nil
+		}
+	}
+	return nil, errors.New("group regex needs a named group like (?P<series>...)")
+}
+
+// GroupTitle returns the values of the named groups of pattern in the file
+// name of objectName, or false when it doesn't match.
+func GroupTitle(pattern *regexp.Regexp, objectName string) (string, bool) {
+	match := pattern.FindStringSubmatch(path.Base(objectName))
+	if match == nil {
+		return "", false
+	}
+	var values []string
+	for i, name := range pattern.SubexpNames() {
+		if name != "" && match[i] != "" {
+			values = append(values, match[i])
+		}
+	}
+	return strings.Join(values, " "), true
+}
+
+// GroupObjects sorts items into groups in the order their first object
+// appears, keeping the order within each group, with Other last. Without a
+// pattern all items are in a single group without a title.
+func GroupObjects(pattern *regexp.Regexp, items []*storage.Object) []ObjectGroup {
+	if pattern == nil {
+		return []ObjectGroup{{Items: items}}
+	}
+	var groups []ObjectGroup
+	index := make(map[string]int)
+	other := ObjectGroup{Other: true}
+	for _, item := range items {
+		title, ok := GroupTitle(pattern, item.Name)
+		if !ok {
+			other.Items = append(other.Items, item)
+			continue
+		}
+		i, seen := index[title]
+		if !seen {
+			i = len(groups)
+			index[title] = i
+			groups = append(groups, ObjectGroup{Title: title})
+		}
+		groups[i].Items = append(groups[i].Items, item)
+	}
+	if len(other.Items) > 0 {
+		groups = append(groups, other)
+	}
+	return groups
+}
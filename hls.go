@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+)
+
+// Manifests are small text files, anything larger than this is not a
+// playlist we want to rewrite in memory.
+const maxManifestSize = 1 << 20
+
+var uriAttributeRegexp = regexp.MustCompile(`URI="([^"]*)"`)
+
+func IsStream(objectName string) bool {
+	return strings.HasSuffix(objectName, ".m3u8")
+}
+
+func HLSPath(objectName string) string {
+	return Link((&url.URL{Path: "/hls/" + objectName}).String())
+}
+
+// resolveSegment resolves a URI found in the manifest stored at manifestName
+// to the URL the player should fetch. Playlists are routed back through the
+// HLS handler so that their own segments get rewritten, everything else is
+// signed directly.
+func (s *Server) resolveSegment(manifestName string, uri string) string {
+	if parsed, err := url.Parse(uri); err != nil || parsed.IsAbs() {
+		return uri
+	}
+	var objectName string
+	if strings.HasPrefix(uri, "/") {
+		objectName = strings.TrimPrefix(path.Clean(uri), "/")
+	} else {
+		objectName = path.Join(path.Dir(manifestName), uri)
+	}
+	if IsStream(objectName) {
+		return HLSPath(objectName)
+	}
+	return s.SignUrlFor(objectName, *inlineExpiry)
+}
+
+// RewriteManifest copies an HLS playlist from input to output replacing every
+// segment, playlist and key URI with a URL the browser can fetch.
+func (s *Server) RewriteManifest(manifestName string, input io.Reader, output io.Writer) error {
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#"):
+			line = uriAttributeRegexp.ReplaceAllStringFunc(line, func(attribute string) string {
+				uri := uriAttributeRegexp.FindStringSubmatch(attribute)[1]
+				return `URI="` + s.resolveSegment(manifestName, uri) + `"`
+			})
+		default:
+			line = s.resolveSegment(manifestName, line)
+		}
+		if _, err := io.WriteString(output, line+"\n"); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func setManifestHeaders(response http.ResponseWriter) {
+	NoIndex(response)
+	response.Header().Set("Content-type", "application/vnd.apple.mpegurl")
+	// Signed segment URLs expire, so the rewritten manifest must not be cached
+	// for longer than they are valid.
+	response.Header().Set("Cache-Control", "private, max-age=60")
+}
+
+func (s *Server) HLSHandler(response http.ResponseWriter, request *http.Request) {
+	objectName := mux.Vars(request)["objectName"]
+	if !IsStream(objectName) {
+		http.NotFound(response, request)
+		return
+	}
+
+	if request.Method == "HEAD" {
+		// The length of the rewritten manifest isn't known without
+		// downloading it, only whether it exists.
+		if _, err := s.GetObject(request.Context(), objectName); err != nil {
+			http.NotFound(response, request)
+			return
+		}
+		setManifestHeaders(response)
+		return
+	}
+	res, err := s.Storage().Objects.Get(bucketName, StorageName(objectName)).Context(request.Context()).Download()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"objectName":    objectName,
+			"internalError": err,
+		}).Warn("Failed downloading manifest.")
+		http.NotFound(response, request)
+		return
+	}
+	defer res.Body.Close()
+
+	setManifestHeaders(response)
+	err = s.RewriteManifest(objectName, io.LimitReader(res.Body, maxManifestSize), response)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"objectName":    objectName,
+			"internalError": err,
+		}).Warn("Failed rewriting manifest.")
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+)
+
+func (s *Server) valueMAC(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.CookieSecret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// SignValue encodes payload together with its HMAC so that it can be handed
+// to clients and verified when it comes back.
+func (s *Server) SignValue(payload []byte) string {
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(s.valueMAC(payload))
+}
+
+// VerifyValue returns the payload of a value created by SignValue, or false
+// if it was tampered with.
+func (s *Server) VerifyValue(value string) ([]byte, bool) {
+	parts := strings.SplitN(value, ".", 2)
+	if len(parts) != 2 {
+		return nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, false
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, s.valueMAC(payload)) {
+		return nil, false
+	}
+	return payload, true
+}
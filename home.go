@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var homeRedirect = flag.String("home-redirect", "index", "What / shows: index for the video index, latest to play the newest video or a folder like news/ to browse. Links within the site and requests with a query string, like searches, still get the index.")
+
+// ValidateHomeRedirect checks -home-redirect and returns folders as a
+// prefix ending with the delimiter.
+func ValidateHomeRedirect(target string) (string, error) {
+	switch target {
+	case "index", "latest":
+		return target, nil
+	case "", delimiter:
+		return "", errors.New("home redirect must be index, latest or a folder")
+	}
+	prefix := strings.TrimPrefix(target, delimiter)
+	if !strings.HasSuffix(prefix, delimiter) {
+		prefix += delimiter
+	}
+	if hasBadSegment(prefix) {
+		return "", errors.New("home redirect folder must not contain empty, . or .. segments")
+	}
+	return prefix, nil
+}
+
+// HomeTarget returns where a request for / is sent instead of the index, or
+// "" to show the index. Only visitors arriving from elsewhere are sent on,
+// so that the links back to the videos keep working.
+func (s *Server) HomeTarget(request *http.Request) string {
+	if request.URL.Path != "/" || request.URL.RawQuery != "" || fromSite(request) {
+		return ""
+	}
+	switch *homeRedirect {
+	case "index":
+		return ""
+	case "latest":
+		return s.latestVideo(request)
+	}
+	return Link("/browse/", ObjectPath(*homeRedirect))
+}
+
+// fromSite reports whether the request follows a link on one of our pages.
+func fromSite(request *http.Request) bool {
+	referer, err := url.Parse(request.Referer())
+	return err == nil && referer.Host != "" && referer.Host == request.Host
+}
+
+// latestVideo returns the play page of the most recently updated video, or
+// "" when there is none.
+func (s *Server) latestVideo(request *http.Request) string {
+	items, err := s.CachedObjects(request.Context(), "")
+	if err == nil {
+		var filter ListFilter
+		if filter, err = s.ListFilter(request); err == nil {
+			items = FilterVideos(filter(items))
+		}
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"internalError": err,
+		}).Warn("Failed finding the latest video.")
+		return ""
+	}
+	if len(items) == 0 {
+		return ""
+	}
+	SortObjects(items, SortOption{Key: "updated", Order: "desc"})
+	return Link("/play/", ObjectPath(items[0].Name))
+}
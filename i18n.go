@@ -0,0 +1,447 @@
+package main
+
+import (
+	"flag"
+	"html/template"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+var defaultLang = flag.String("default-lang", "en", "Language used when the browser accepts none of the translated ones.")
+
+const fallbackLang = "en"
+
+// messages maps a language to the translated UI strings. Keys missing from a
+// language fall back to English.
+var messages = map[string]map[string]string{
+	"en": {
+		"lang":                "en",
+		"sort":                "Sort",
+		"sort_updated":        "Newest",
+		"sort_name":           "Name",
+		"sort_size":           "Size",
+		"videos":              "Videos",
+		"all":                 "All",
+		"search":              "Search",
+		"recently_played":     "Recently played",
+		"stream":              "stream",
+		"unavailable":         "unavailable",
+		"delete":              "Delete",
+		"delete_confirm":      "Delete this object?",
+		"download":            "Download",
+		"up":                  "Up",
+		"dry_run":             "Dry-run mode: changes are logged but not applied.",
+		"media_video":         "Videos",
+		"media_audio":         "Audio",
+		"media_image":         "Images",
+		"media_all":           "All files",
+		"previous":            "Previous",
+		"next":                "Next",
+		"open_in_console":     "Open in Cloud Console",
+		"min_size":            "Min. size",
+		"max_size":            "Max. size",
+		"public":              "Public",
+		"private":             "Private",
+		"make_public":         "Make public",
+		"make_private":        "Make private",
+		"acl_bucket":          "Bucket access",
+		"acl_uniform":         "The bucket uses uniform bucket-level access, access can only be changed for the whole bucket.",
+		"dismiss":             "Close",
+		"sort_expires":        "Expiring soon",
+		"sort_popular":        "Most played",
+		"expires":             "expires",
+		"view_list":           "List",
+		"view_grid":           "Grid",
+		"search_in":           "in",
+		"search_everywhere":   "Search everywhere",
+		"transcode":           "Make web-friendly",
+		"transcode_pending":   "queued",
+		"transcode_running":   "converting",
+		"transcode_succeeded": "converted",
+		"transcode_failed":    "conversion failed",
+		"trash":               "Trash",
+		"trash_empty":         "The trash is empty.",
+		"restore":             "Restore",
+		"trashed":             "deleted",
+		"purged":              "removed for good",
+		"show_more":           "Show more",
+		"group_other":         "Other",
+		"diff":                "Changes between generations",
+		"diff_missing":        "generation not found",
+		"diff_generation":     "Generation",
+		"diff_size":           "Size",
+		"diff_md5":            "MD5",
+		"diff_crc32c":         "CRC32C",
+		"diff_updated":        "Updated",
+		"diff_content_type":   "Content type",
+		"search_buckets":      "Search all buckets",
+		"search_failed":       "These buckets could not be searched:",
+		"search_no_results":   "Nothing found.",
+		"featured":            "Featured",
+		"edit_metadata":       "Edit metadata",
+		"content_type":        "Content type",
+		"cache_control":       "Cache control",
+		"custom_metadata":     "Custom metadata",
+		"metadata_key":        "Key",
+		"metadata_value":      "Value",
+		"metadata_field":      "Field",
+		"metadata_before":     "Before",
+		"metadata_after":      "After",
+		"add_field":           "Add field",
+		"review_changes":      "Review changes",
+		"apply_changes":       "Apply changes",
+		"no_changes":          "Nothing changed.",
+		"duplicate_key":       "Duplicate key:",
+		"deleted":             "Deleted objects",
+		"deleted_since":       "Deleted since",
+		"deleted_none":        "Nothing was deleted in this time.",
+		"deleted_unversioned": "Object versioning is off for this bucket, deleted objects are gone for good.",
+	},
+	"de": {
+		"lang":                "de",
+		"sort":                "Sortieren",
+		"sort_updated":        "Neueste",
+		"sort_name":           "Name",
+		"sort_size":           "Größe",
+		"videos":              "Videos",
+		"all":                 "Alle",
+		"search":              "Suchen",
+		"recently_played":     "Zuletzt angesehen",
+		"stream":              "Stream",
+		"unavailable":         "nicht verfügbar",
+		"delete":              "Löschen",
+		"delete_confirm":      "Dieses Objekt löschen?",
+		"download":            "Herunterladen",
+		"up":                  "Nach oben",
+		"dry_run":             "Testmodus: Änderungen werden protokolliert, aber nicht ausgeführt.",
+		"media_video":         "Videos",
+		"media_audio":         "Audio",
+		"media_image":         "Bilder",
+		"media_all":           "Alle Dateien",
+		"previous":            "Zurück",
+		"next":                "Weiter",
+		"open_in_console":     "In der Cloud Console öffnen",
+		"min_size":            "Min. Größe",
+		"max_size":            "Max. Größe",
+		"public":              "Öffentlich",
+		"private":             "Privat",
+		"make_public":         "Öffentlich machen",
+		"make_private":        "Privat machen",
+		"acl_bucket":          "Bucket-Zugriff",
+		"acl_uniform":         "Der Bucket nutzt einheitlichen Zugriff auf Bucket-Ebene, der Zugriff kann nur für den ganzen Bucket geändert werden.",
+		"dismiss":             "Schließen",
+		"sort_expires":        "Läuft bald ab",
+		"sort_popular":        "Meistgespielt",
+		"expires":             "läuft ab",
+		"view_list":           "Liste",
+		"view_grid":           "Raster",
+		"search_in":           "in",
+		"search_everywhere":   "Überall suchen",
+		"transcode":           "Für das Web umwandeln",
+		"transcode_pending":   "in der Warteschlange",
+		"transcode_running":   "wird umgewandelt",
+		"transcode_succeeded": "umgewandelt",
+		"transcode_failed":    "Umwandlung fehlgeschlagen",
+		"trash":               "Papierkorb",
+		"trash_empty":         "Der Papierkorb ist leer.",
+		"restore":             "Wiederherstellen",
+		"trashed":             "gelöscht",
+		"purged":              "endgültig entfernt",
+		"show_more":           "Mehr anzeigen",
+		"group_other":         "Sonstige",
+		"diff":                "Änderungen zwischen Generationen",
+		"diff_missing":        "Generation nicht gefunden",
+		"diff_generation":     "Generation",
+		"diff_size":           "Größe",
+		"diff_md5":            "MD5",
+		"diff_crc32c":         "CRC32C",
+		"diff_updated":        "Geändert",
+		"diff_content_type":   "Inhaltstyp",
+		"search_buckets":      "Alle Buckets durchsuchen",
+		"search_failed":       "Diese Buckets konnten nicht durchsucht werden:",
+		"search_no_results":   "Nichts gefunden.",
+		"featured":            "Empfohlen",
+		"edit_metadata":       "Metadaten bearbeiten",
+		"content_type":        "Inhaltstyp",
+		"cache_control":       "Cache-Steuerung",
+		"custom_metadata":     "Eigene Metadaten",
+		"metadata_key":        "Schlüssel",
+		"metadata_value":      "Wert",
+		"metadata_field":      "Feld",
+		"metadata_before":     "Vorher",
+		"metadata_after":      "Nachher",
+		"add_field":           "Feld hinzufügen",
+		"review_changes":      "Änderungen prüfen",
+		"apply_changes":       "Änderungen übernehmen",
+		"no_changes":          "Nichts geändert.",
+		"duplicate_key":       "Doppelter Schlüssel:",
+		"deleted":             "Gelöschte Objekte",
+		"deleted_since":       "Gelöscht seit",
+		"deleted_none":        "In dieser Zeit wurde nichts gelöscht.",
+		"deleted_unversioned": "Die Objektversionierung ist für diesen Bucket aus, gelöschte Objekte sind endgültig weg.",
+	},
+	"es": {
+		"lang":                "es",
+		"sort":                "Ordenar",
+		"sort_updated":        "Recientes",
+		"sort_name":           "Nombre",
+		"sort_size":           "Tamaño",
+		"videos":              "Vídeos",
+		"all":                 "Todos",
+		"search":              "Buscar",
+		"recently_played":     "Vistos recientemente",
+		"stream":              "stream",
+		"unavailable":         "no disponible",
+		"delete":              "Eliminar",
+		"delete_confirm":      "¿Eliminar este objeto?",
+		"download":            "Descargar",
+		"up":                  "Subir",
+		"dry_run":             "Modo de prueba: los cambios se registran pero no se aplican.",
+		"media_video":         "Vídeos",
+		"media_audio":         "Audio",
+		"media_image":         "Imágenes",
+		"media_all":           "Todos los archivos",
+		"previous":            "Anterior",
+		"next":                "Siguiente",
+		"open_in_console":     "Abrir en Cloud Console",
+		"min_size":            "Tamaño mín.",
+		"max_size":            "Tamaño máx.",
+		"public":              "Público",
+		"private":             "Privado",
+		"make_public":         "Hacer público",
+		"make_private":        "Hacer privado",
+		"acl_bucket":          "Acceso del bucket",
+		"acl_uniform":         "El bucket usa acceso uniforme a nivel de bucket, el acceso solo se puede cambiar para todo el bucket.",
+		"dismiss":             "Cerrar",
+		"sort_expires":        "Caducan pronto",
+		"sort_popular":        "Más vistos",
+		"expires":             "caduca",
+		"view_list":           "Lista",
+		"view_grid":           "Cuadrícula",
+		"search_in":           "en",
+		"search_everywhere":   "Buscar en todas partes",
+		"transcode":           "Convertir para la web",
+		"transcode_pending":   "en cola",
+		"transcode_running":   "convirtiendo",
+		"transcode_succeeded": "convertido",
+		"transcode_failed":    "conversión fallida",
+		"trash":               "Papelera",
+		"trash_empty":         "La papelera está vacía.",
+		"restore":             "Restaurar",
+		"trashed":             "eliminado",
+		"purged":              "se borra definitivamente",
+		"show_more":           "Mostrar más",
+		"group_other":         "Otros",
+		"diff":                "Cambios entre generaciones",
+		"diff_missing":        "generación no encontrada",
+		"diff_generation":     "Generación",
+		"diff_size":           "Tamaño",
+		"diff_md5":            "MD5",
+		"diff_crc32c":         "CRC32C",
+		"diff_updated":        "Modificado",
+		"diff_content_type":   "Tipo de contenido",
+		"search_buckets":      "Buscar en todos los buckets",
+		"search_failed":       "No se pudo buscar en estos buckets:",
+		"search_no_results":   "No se encontró nada.",
+		"featured":            "Destacados",
+		"edit_metadata":       "Editar metadatos",
+		"content_type":        "Tipo de contenido",
+		"cache_control":       "Control de caché",
+		"custom_metadata":     "Metadatos personalizados",
+		"metadata_key":        "Clave",
+		"metadata_value":      "Valor",
+		"metadata_field":      "Campo",
+		"metadata_before":     "Antes",
+		"metadata_after":      "Después",
+		"add_field":           "Añadir campo",
+		"review_changes":      "Revisar cambios",
+		"apply_changes":       "Aplicar cambios",
+		"no_changes":          "No hay cambios.",
+		"duplicate_key":       "Clave duplicada:",
+		"deleted":             "Objetos eliminados",
+		"deleted_since":       "Eliminados desde",
+		"deleted_none":        "No se eliminó nada en este periodo.",
+		"deleted_unversioned": "El control de versiones está desactivado en este bucket, los objetos eliminados no se pueden recuperar.",
+	},
+	"fr": {
+		"lang":                "fr",
+		"sort":                "Trier",
+		"sort_updated":        "Récents",
+		"sort_name":           "Nom",
+		"sort_size":           "Taille",
+		"videos":              "Vidéos",
+		"all":                 "Toutes",
+		"search":              "Rechercher",
+		"recently_played":     "Vus récemment",
+		"stream":              "flux",
+		"unavailable":         "indisponible",
+		"delete":              "Supprimer",
+		"delete_confirm":      "Supprimer cet objet ?",
+		"download":            "Télécharger",
+		"up":                  "Remonter",
+		"dry_run":             "Mode test : les modifications sont journalisées mais pas appliquées.",
+		"media_video":         "Vidéos",
+		"media_audio":         "Audio",
+		"media_image":         "Images",
+		"media_all":           "Tous les fichiers",
+		"previous":            "Précédent",
+		"next":                "Suivant",
+		"open_in_console":     "Ouvrir dans la Cloud Console",
+		"min_size":            "Taille min.",
+		"max_size":            "Taille max.",
+		"public":              "Public",
+		"private":             "Privé",
+		"make_public":         "Rendre public",
+		"make_private":        "Rendre privé",
+		"acl_bucket":          "Accès du bucket",
+		"acl_uniform":         "Le bucket utilise l'accès uniforme au niveau du bucket, l'accès ne peut être modifié que pour tout le bucket.",
+		"dismiss":             "Fermer",
+		"sort_expires":        "Expirent bientôt",
+		"sort_popular":        "Les plus vus",
+		"expires":             "expire",
+		"view_list":           "Liste",
+		"view_grid":           "Grille",
+		"search_in":           "dans",
+		"search_everywhere":   "Rechercher partout",
+		"transcode":           "Convertir pour le web",
+		"transcode_pending":   "en attente",
+		"transcode_running":   "conversion en cours",
+		"transcode_succeeded": "converti",
+		"transcode_failed":    "échec de la conversion",
+		"trash":               "Corbeille",
+		"trash_empty":         "La corbeille est vide.",
+		"restore":             "Restaurer",
+		"trashed":             "supprimé",
+		"purged":              "supprimé définitivement",
+		"show_more":           "Afficher plus",
+		"group_other":         "Autres",
+		"diff":                "Modifications entre générations",
+		"diff_missing":        "génération introuvable",
+		"diff_generation":     "Génération",
+		"diff_size":           "Taille",
+		"diff_md5":            "MD5",
+		"diff_crc32c":         "CRC32C",
+		"diff_updated":        "Modifié",
+		"diff_content_type":   "Type de contenu",
+		"search_buckets":      "Rechercher dans tous les buckets",
+		"search_failed":       "Ces buckets n'ont pas pu être parcourus :",
+		"search_no_results":   "Aucun résultat.",
+		"featured":            "À la une",
+		"edit_metadata":       "Modifier les métadonnées",
+		"content_type":        "Type de contenu",
+		"cache_control":       "Contrôle du cache",
+		"custom_metadata":     "Métadonnées personnalisées",
+		"metadata_key":        "Clé",
+		"metadata_value":      "Valeur",
+		"metadata_field":      "Champ",
+		"metadata_before":     "Avant",
+		"metadata_after":      "Après",
+		"add_field":           "Ajouter un champ",
+		"review_changes":      "Vérifier les modifications",
+		"apply_changes":       "Appliquer les modifications",
+		"no_changes":          "Aucune modification.",
+		"duplicate_key":       "Clé en double :",
+		"deleted":             "Objets supprimés",
+		"deleted_since":       "Supprimés depuis",
+		"deleted_none":        "Rien n'a été supprimé pendant cette période.",
+		"deleted_unversioned": "Le versionnage des objets est désactivé pour ce bucket, les objets supprimés sont perdus.",
+	},
+}
+
+// Translate returns the message for key in lang.
+func Translate(lang string, key string) string {
+	if message, ok := messages[lang][key]; ok {
+		return message
+	}
+	if message, ok := messages[fallbackLang][key]; ok {
+		return message
+	}
+	return key
+}
+
+type acceptedLang struct {
+	tag     string
+	quality float64
+}
+
+// NegotiateLang picks the best translated language for an Accept-Language
+// header, trying the base language of regional tags like en-GB as well.
+func NegotiateLang(header string, defaultLang string) string {
+	var accepted []acceptedLang
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.ToLower(strings.TrimSpace(fields[0]))
+		if tag == "" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if q, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					quality = q
+				}
+			}
+		}
+		if quality > 0 {
+			accepted = append(accepted, acceptedLang{tag, quality})
+		}
+	}
+	sort.SliceStable(accepted, func(i, j int) bool { return accepted[i].quality > accepted[j].quality })
+
+	for _, candidate := range accepted {
+		if _, ok := messages[candidate.tag]; ok {
+			return candidate.tag
+		}
+		if base := strings.SplitN(candidate.tag, "-", 2)[0]; messages[base] != nil {
+			return base
+		}
+	}
+	return defaultLang
+}
+
+// LocalizeTemplates returns a copy of base for every translated language
+// with the t template function bound to it. base must not have been
+// executed yet.
+func LocalizeTemplates(base *template.Template) (map[string]*template.Template, error) {
+	localized := make(map[string]*template.Template, len(messages))
+	for lang := range messages {
+		clone, err := base.Clone()
+		if err != nil {
+			return nil, err
+		}
+		lang := lang
+		clone.Funcs(template.FuncMap{
+			"t": func(key string) string { return Translate(lang, key) },
+		})
+		localized[lang] = clone
+	}
+	return localized, nil
+}
+
+// Render executes the named template in the language negotiated for the
+// request.
+func (s *Server) Render(response http.ResponseWriter, request *http.Request, name string, data interface{}) {
+	lang := NegotiateLang(request.Header.Get("Accept-Language"), *defaultLang)
+	templates, ok := s.LocalizedTemplates[lang]
+	if !ok {
+		templates = s.Templates
+	}
+	response.Header().Set("Content-Language", lang)
+	response.Header().Add("Vary", "Accept-Language")
+	// Templates sign the URLs they link to, which is part of the span.
+	_, span := StartSpan(request.Context(), "render", attribute.String("template", name))
+	err := templates.ExecuteTemplate(response, name, data)
+	EndSpan(span, err)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"template":      name,
+			"internalError": err,
+		}).Warn("Failed rendering template.")
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"path"
+	"strings"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+const iconPath = "/static/icons/"
+
+// iconsByExtension names the icon of file types that content types don't
+// tell apart well.
+var iconsByExtension = map[string]string{
+	".pdf":  "pdf",
+	".doc":  "document",
+	".docx": "document",
+	".odt":  "document",
+	".rtf":  "document",
+	".xls":  "spreadsheet",
+	".xlsx": "spreadsheet",
+	".ods":  "spreadsheet",
+	".csv":  "spreadsheet",
+	".txt":  "text",
+	".md":   "text",
+	".json": "text",
+	".vtt":  "text",
+	".srt":  "text",
+	".zip":  "archive",
+	".tar":  "archive",
+	".gz":   "archive",
+	".tgz":  "archive",
+	".7z":   "archive",
+	".rar":  "archive",
+}
+
+// IconName returns the name of the icon for object, "file" when nothing more
+// specific fits.
+func IconName(object *storage.Object) string {
+	if icon, ok := iconsByExtension[strings.ToLower(path.Ext(object.Name))]; ok {
+		return icon
+	}
+	if kind := MediaKind(object); kind != "other" {
+		return kind
+	}
+	if strings.HasPrefix(ContentType(object), "text/") {
+		return "text"
+	}
+	return "file"
+}
+
+// IconFor returns the path of the embedded icon for object.
+func IconFor(object *storage.Object) string {
+	return Link(iconPath, IconName(object), ".svg")
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	_ "github.com/mattn/go-sqlite3"
+	"golang.org/x/net/context"
+	storage "google.golang.org/api/storage/v1"
+)
+
+var (
+	indexDB       = flag.String("index-db", "", "Path of an SQLite database indexing the bucket listing for fast search. Disabled when empty.")
+	indexInterval = flag.Duration("index-interval", 10*time.Minute, "How often the SQLite index is refreshed from the bucket.")
+)
+
+// The index keeps the objects as listed, pages served from it need their
+// metadata and generations as much as the ones served from the listing cache.
+const indexSchema = `CREATE TABLE IF NOT EXISTS objects (
+	name TEXT PRIMARY KEY,
+	object TEXT NOT NULL
+)`
+
+// indexVersion is stored as the user_version of the database. Databases of
+// another version are emptied, the next refresh fills them again.
+const indexVersion = 2
+
+// ObjectIndex mirrors the bucket listing into SQLite so that searching a
+// huge bucket doesn't require listing it.
+type ObjectIndex struct {
+	db *sql.DB
+
+	mutex sync.RWMutex
+	ready bool
+}
+
+func OpenObjectIndex(filename string) (*ObjectIndex, error) {
+	db, err := sql.Open("sqlite3", filename)
+	if err != nil {
+		return nil, err
+	}
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if version != indexVersion {
+		if _, err := db.Exec("DROP TABLE IF EXISTS objects"); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	if _, err := db.Exec(indexSchema); err != nil {
+		db.Close()
+		return nil, err
+	}
+	if _, err := db.Exec(fmt.Sprintf("PRAGMA user_version = %d", indexVersion)); err != nil {
+		db.Close()
+		return nil, err
+	}
+	index := &ObjectIndex{db: db}
+	// A database left from a previous run can be served until the first
+	// refresh completes.
+	var count int
+	if err := db.QueryRow("SELECT COUNT(*) FROM objects").Scan(&count); err == nil && count > 0 {
+		index.ready = true
+	}
+	return index, nil
+}
+
+// Ready reports whether the index holds a listing that can be searched.
+func (i *ObjectIndex) Ready() bool {
+	i.mutex.RLock()
+	defer i.mutex.RUnlock()
+	return i.ready
+}
+
+// Replace swaps the indexed listing for items in a single transaction.
+func (i *ObjectIndex) Replace(items []*storage.Object) error {
+	tx, err := i.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("DELETE FROM objects"); err != nil {
+		return err
+	}
+	insert, err := tx.Prepare("INSERT INTO objects (name, object) VALUES (?, ?)")
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+	for _, item := range items {
+		object, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		if _, err := insert.Exec(item.Name, string(object)); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	i.mutex.Lock()
+	i.ready = true
+	i.mutex.Unlock()
+	return nil
+}
+
+func escapeLike(input string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(input)
+}
+
+// Search returns the indexed objects below prefix whose name contains query,
+// most recently updated first. SQLite's LIKE ignores ASCII case.
+func (i *ObjectIndex) Search(prefix string, query string) ([]*storage.Object, error) {
+	rows, err := i.db.Query(`SELECT object FROM objects
+		WHERE name LIKE ? ESCAPE '\' AND name LIKE ? ESCAPE '\'`,
+		escapeLike(prefix)+"%", "%"+escapeLike(query)+"%")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []*storage.Object
+	for rows.Next() {
+		var object string
+		if err := rows.Scan(&object); err != nil {
+			return nil, err
+		}
+		var item storage.Object
+		if err := json.Unmarshal([]byte(object), &item); err != nil {
+			return nil, err
+		}
+		items = append(items, &item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Sorted here rather than by SQLite, which would compare the timestamps
+	// as strings.
+	SortByUpdated(items)
+	return items, nil
+}
+
+// RunIndexer refreshes the index from the bucket every interval, forever.
+func (s *Server) RunIndexer(interval time.Duration) {
+	for {
+		start := time.Now()
+		items, err := s.ListObjects(context.Background(), "")
+		if err == nil {
+			err = s.Index.Replace(items)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"internalError": err,
+			}).Warn("Failed refreshing object index.")
+		} else {
+			log.WithFields(log.Fields{
+				"objects":  len(items),
+				"duration": time.Since(start),
+			}).Info("Refreshed object index.")
+		}
+		time.Sleep(interval)
+	}
+}
+
+// SearchObjects returns the objects below prefix whose name contains query,
+// from the SQLite index when it is enabled and populated and from the
+// listing cache otherwise. The result is a new slice sorted by ByUpdated.
+func (s *Server) SearchObjects(ctx context.Context, prefix string, query string) ([]*storage.Object, error) {
+	if s.Index != nil && s.Index.Ready() {
+		items, err := s.Index.Search(prefix, query)
+		if err == nil {
+			// The index may predate a reload of the blocked names.
+			return s.Blocked.Hide(items), nil
+		}
+		log.WithFields(log.Fields{
+			"internalError": err,
+		}).Warn("Failed searching object index, falling back to listing.")
+	}
+	items, err := s.CachedObjects(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	items = FilterByName(items, query)
+	SortByUpdated(items)
+	return items, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+func TestObjectIndexKeepsListedFields(t *testing.T) {
+	index, err := OpenObjectIndex(filepath.Join(t.TempDir(), "index.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	listed := &storage.Object{
+		Name:         "videos/clip.mp4",
+		Size:         1234,
+		Updated:      "2020-01-02T00:00:00Z",
+		TimeCreated:  "2020-01-01T00:00:00Z",
+		ContentType:  "video/mp4",
+		CacheControl: "public, max-age=60",
+		Generation:   42,
+		Metadata:     map[string]string{"featured": "true"},
+	}
+	if err := index.Replace([]*storage.Object{listed, {Name: "other.mp4"}}); err != nil {
+		t.Fatal(err)
+	}
+	items, err := index.Search("videos/", "CLIP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("Search found %d objects, want 1", len(items))
+	}
+	item := items[0]
+	if item.Name != listed.Name || item.Size != listed.Size || item.Updated != listed.Updated ||
+		item.TimeCreated != listed.TimeCreated || item.ContentType != listed.ContentType ||
+		item.CacheControl != listed.CacheControl || item.Generation != listed.Generation ||
+		item.Metadata["featured"] != "true" {
+		t.Errorf("Search returned %+v, want %+v", item, listed)
+	}
+}
+
+func TestObjectIndexDropsOlderVersions(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "index.db")
+	index, err := OpenObjectIndex(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := index.db.Exec("PRAGMA user_version = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Replace([]*storage.Object{{Name: "clip.mp4"}}); err != nil {
+		t.Fatal(err)
+	}
+	index.db.Close()
+
+	index, err = OpenObjectIndex(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index.Ready() {
+		t.Error("index of an older version is ready, want it emptied")
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	storage "google.golang.org/api/storage/v1"
+)
+
+const inventoryFields = "nextPageToken,items(name,size,updated,contentType,md5Hash,crc32c)"
+
+// InventoryRecord is one line of the bucket inventory.
+type InventoryRecord struct {
+	Name        string `json:"name"`
+	Size        uint64 `json:"size"`
+	Updated     string `json:"updated"`
+	ContentType string `json:"contentType"`
+	Md5         string `json:"md5,omitempty"`
+	Crc32c      string `json:"crc32c,omitempty"`
+}
+
+// InventoryHandler streams every object of the bucket as JSON Lines, flushing
+// after each listing page so nothing but the current page is buffered.
+func (s *Server) InventoryHandler(response http.ResponseWriter, request *http.Request) {
+	flusher, _ := response.(http.Flusher)
+	response.Header().Set("Content-type", "application/x-ndjson")
+	response.Header().Set("Content-Disposition", `attachment; filename="inventory.jsonl"`)
+	NoIndex(response)
+	Audit(request, "inventory", log.Fields{})
+
+	ctx := request.Context()
+	call := s.Storage().Objects.List(bucketName).Fields(inventoryFields).Context(ctx)
+	encoder := json.NewEncoder(response)
+	err := listPages(call, "", func(page []*storage.Object) error {
+		for _, object := range s.Blocked.Hide(page) {
+			err := encoder.Encode(InventoryRecord{
+				Name:        object.Name,
+				Size:        object.Size,
+				Updated:     object.Updated,
+				ContentType: object.ContentType,
+				Md5:         object.Md5Hash,
+				Crc32c:      object.Crc32c,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil && ctx.Err() == nil {
+		// The status is long sent, all that's left is to cut the export short.
+		log.WithFields(log.Fields{
+			"internalError": err,
+		}).Warn("Failed exporting inventory.")
+	}
+}
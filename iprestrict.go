@@ -0,0 +1,151 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+)
+
+var signIPRestrict = flag.String("sign-ip-restrict", "", "Comma separated networks such as 10.0.0.0/8 or single addresses that object links only work from. GCS signed URLs, V4 included, can't be tied to client addresses, so objects are then served through /r/ links the server checks instead. Ignored with -proxy-only.")
+
+// restrictedNetworks are the parsed -sign-ip-restrict networks.
+var restrictedNetworks []*net.IPNet
+
+// IPRestricted reports whether object links are restricted to
+// -sign-ip-restrict.
+func IPRestricted() bool {
+	return len(restrictedNetworks) > 0 && !*proxyOnly
+}
+
+// ParseNetworks parses a comma separated list of CIDRs, single addresses
+// are networks of their own.
+func ParseNetworks(input string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, value := range strings.Split(input, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if ip := net.ParseIP(value); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", value)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// AllowedAddr reports whether remoteAddr, a host:port as in
+// http.Request.RemoteAddr, is within -sign-ip-restrict. Behind a load
+// balancer that is the balancer's address.
+func AllowedAddr(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, network := range restrictedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// restrictedTokenPrefix keeps restricted tokens apart from share tokens,
+// which are signed with the same secret.
+const restrictedTokenPrefix = "r:"
+
+// RestrictedPath returns the /r/ link used instead of a signed URL with
+// -sign-ip-restrict. Like DownloadPath, of the signed URL overrides only the
+// attachment disposition, the generation and the -cache-bust-param are kept,
+// the proxy takes care of the rest.
+func (s *Server) RestrictedPath(objectName string, params url.Values, expiry time.Duration) string {
+	expires := time.Now().Add(expiry).Unix()
+	token := s.SignValue([]byte(restrictedTokenPrefix + strconv.FormatInt(expires, 10) + ":" + objectName))
+	query := url.Values{}
+	if params.Get("response-content-disposition") != "" {
+		query.Set("download", "1")
+	}
+	if generation := params.Get("generation"); generation != "" {
+		query.Set("generation", generation)
+	}
+	if *cacheBustParam != "" && params.Get(*cacheBustParam) != "" {
+		query.Set(*cacheBustParam, params.Get(*cacheBustParam))
+	}
+	target := Link("/r/", token)
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	return target
+}
+
+// ParseRestrictedToken validates a token created by RestrictedPath and
+// returns the object name it grants access to.
+func (s *Server) ParseRestrictedToken(token string) (string, error) {
+	payload, ok := s.VerifyValue(token)
+	if !ok || !strings.HasPrefix(string(payload), restrictedTokenPrefix) {
+		return "", errInvalidToken
+	}
+	fields := strings.SplitN(strings.TrimPrefix(string(payload), restrictedTokenPrefix), ":", 2)
+	if len(fields) != 2 {
+		return "", errInvalidToken
+	}
+	expires, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return "", errInvalidToken
+	}
+	if time.Now().Unix() > expires {
+		return "", errExpiredToken
+	}
+	return fields[1], nil
+}
+
+// RestrictedHandler serves the object of a /r/ link to clients within
+// -sign-ip-restrict.
+func (s *Server) RestrictedHandler(response http.ResponseWriter, request *http.Request) {
+	if !AllowedAddr(request.RemoteAddr) {
+		log.WithFields(log.Fields{
+			"remoteAddr": request.RemoteAddr,
+		}).Info("Rejected restricted link from outside the allowed networks.")
+		http.Error(response, "Forbidden", http.StatusForbidden)
+		return
+	}
+	objectName, err := s.ParseRestrictedToken(mux.Vars(request)["token"])
+	if err != nil {
+		log.WithFields(log.Fields{
+			"remoteAddr":    request.RemoteAddr,
+			"internalError": err,
+		}).Info("Rejected restricted link token.")
+		http.Error(response, err.Error(), http.StatusForbidden)
+		return
+	}
+	generation, ok := parseGeneration(request.FormValue("generation"))
+	if !ok {
+		http.Error(response, "Invalid generation.", http.StatusBadRequest)
+		return
+	}
+	if request.FormValue("download") != "" {
+		s.setAttachment(response, request, objectName, generation)
+	}
+	s.ProxyGeneration(response, request, objectName, generation)
+}
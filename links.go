@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+var externalUrl = flag.String("external-url", "", "Origin and base path the server is reached at, e.g. https://example.com/videos. Generated links are absolute when set and relative otherwise. A proxy in front is expected to strip the base path.")
+
+// ValidateExternalUrl checks -external-url and returns it without a
+// trailing slash.
+func ValidateExternalUrl(raw string) (string, error) {
+	if raw == "" {
+		return "", nil
+	}
+	parsed, err := url.Parse(raw)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" || parsed.Host == "" {
+		return "", errors.New("external URL must be an absolute http or https URL")
+	}
+	if parsed.RawQuery != "" || parsed.Fragment != "" {
+		return "", errors.New("external URL must not have a query or fragment")
+	}
+	return strings.TrimSuffix(parsed.String(), "/"), nil
+}
+
+// Link returns the link to a path of this server, made absolute with
+// -external-url when it is set. The parts are joined as they are, so they
+// must already be escaped.
+func Link(parts ...interface{}) string {
+	var link strings.Builder
+	link.WriteString(*externalUrl)
+	for _, part := range parts {
+		fmt.Fprint(&link, part)
+	}
+	return link.String()
+}
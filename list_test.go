@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"golang.org/x/net/context"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// listedObject has the metadata a full listing returns for every object,
+// most of which the pages never use.
+func listedObject(name string) storage.Object {
+	return storage.Object{
+		ContentType:    "video/mp4",
+		CacheControl:   "public, max-age=3600",
+		Generation:     1600000000000000,
+		Metageneration: 1,
+		Id:             bucketName + "/" + name + "/1600000000000000",
+		Kind:           "storage#object",
+		SelfLink:       "https://www.googleapis.com/storage/v1/b/" + bucketName + "/o/" + name,
+		MediaLink:      "https://storage.googleapis.com/download/storage/v1/b/" + bucketName + "/o/" + name + "?generation=1600000000000000&alt=media",
+		Md5Hash:        "1B2M2Y8AsgTpgAmY7PhCfg==",
+		Crc32c:         "AAAAAA==",
+		Etag:           "CICAgICAgICAgAE=",
+		StorageClass:   "STANDARD",
+		TimeCreated:    "2020-01-01T00:00:00Z",
+		Owner:          &storage.ObjectOwner{Entity: "user-owner@example.com", EntityId: "00b4903a97"},
+		Acl: []*storage.ObjectAccessControl{
+			{Entity: "project-owners-123456789", Role: "OWNER", Kind: "storage#objectAccessControl"},
+			{Entity: "project-editors-123456789", Role: "OWNER", Kind: "storage#objectAccessControl"},
+			{Entity: "project-viewers-123456789", Role: "READER", Kind: "storage#objectAccessControl"},
+		},
+		Metadata: map[string]string{"title": name},
+	}
+}
+
+func BenchmarkListObjects(b *testing.B) {
+	bucket := &fakeBucket{}
+	for i := 0; i < 1000; i++ {
+		name := fmt.Sprintf("videos/clip-%04d.mp4", i)
+		bucket.add(name, "video", listedObject(name))
+	}
+	s := newTestServer(b, bucket)
+	for _, full := range []bool{false, true} {
+		b.Run(fmt.Sprintf("full-metadata=%v", full), func(b *testing.B) {
+			setFlag(b, fullMetadata, full)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.ListObjects(context.Background(), "videos/"); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
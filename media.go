@@ -0,0 +1,132 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+var contentTypeOverridesFlag = flag.String("content-type-overrides", "", "Comma separated extension=type pairs served whatever the stored content type, e.g. .mp4=video/mp4,.mov=video/quicktime. They apply to proxied downloads and, through response-content-type, to signed URLs.")
+
+// contentTypeOverrides is the parsed -content-type-overrides.
+var contentTypeOverrides map[string]string
+
+// Types for media extensions that the system MIME tables often lack.
+var mediaTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".webm": "video/webm",
+	".mkv":  "video/x-matroska",
+	".ts":   "video/mp2t",
+	".m3u8": "application/vnd.apple.mpegurl",
+	".vtt":  "text/vtt",
+	".srt":  "application/x-subrip",
+	".mp3":  "audio/mpeg",
+	".m4a":  "audio/mp4",
+	".ogg":  "audio/ogg",
+	".flac": "audio/flac",
+	".wav":  "audio/wav",
+}
+
+// MediaKind classifies an object as "video", "audio", "image" or "other".
+func MediaKind(object *storage.Object) string {
+	if IsStream(object.Name) {
+		return "video"
+	}
+	contentType := ContentType(object)
+	for _, kind := range []string{"video", "audio", "image"} {
+		if strings.HasPrefix(contentType, kind+"/") {
+			return kind
+		}
+	}
+	return "other"
+}
+
+// TypeByName guesses a content type from the extension of objectName.
+func TypeByName(objectName string) string {
+	extension := strings.ToLower(path.Ext(objectName))
+	if contentType, ok := mediaTypes[extension]; ok {
+		return contentType
+	}
+	return mime.TypeByExtension(extension)
+}
+
+// ParseContentTypeOverrides parses a comma separated list of extension=type
+// pairs.
+func ParseContentTypeOverrides(input string) (map[string]string, error) {
+	overrides := make(map[string]string)
+	for _, pair := range strings.Split(input, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || !strings.HasPrefix(parts[0], ".") || len(parts[0]) < 2 {
+			return nil, fmt.Errorf("invalid content type override %q, expected .extension=type", pair)
+		}
+		if _, _, err := mime.ParseMediaType(parts[1]); err != nil {
+			return nil, fmt.Errorf("invalid content type in %q: %v", pair, err)
+		}
+		overrides[strings.ToLower(parts[0])] = parts[1]
+	}
+	return overrides, nil
+}
+
+// OverrideType returns the -content-type-overrides type of objectName.
+func OverrideType(objectName string) (string, bool) {
+	contentType, ok := contentTypeOverrides[strings.ToLower(path.Ext(objectName))]
+	return contentType, ok
+}
+
+// ContentType returns the stored content type of object, falling back to a
+// guess from its name when the metadata is missing or generic. Overrides win
+// over both.
+func ContentType(object *storage.Object) string {
+	if contentType, ok := OverrideType(object.Name); ok {
+		return contentType
+	}
+	if object.ContentType != "" && object.ContentType != "application/octet-stream" {
+		return object.ContentType
+	}
+	if guessed := TypeByName(object.Name); guessed != "" {
+		return guessed
+	}
+	return object.ContentType
+}
+
+// MediaKinds lists the media filters offered in the page navigation.
+func MediaKinds() []string {
+	return []string{"video", "audio", "image", "all"}
+}
+
+// ParseMedia reads the media query parameter. Listings show videos unless
+// another kind is chosen.
+func ParseMedia(request *http.Request) string {
+	media := request.FormValue("media")
+	for _, kind := range MediaKinds() {
+		if media == kind {
+			return media
+		}
+	}
+	return "video"
+}
+
+// FilterMedia returns the objects of the given kind, or all of them for
+// "all".
+func FilterMedia(items []*storage.Object, kind string) []*storage.Object {
+	if kind == "video" {
+		return FilterVideos(items)
+	}
+	filtered := make([]*storage.Object, 0, len(items))
+	for _, item := range items {
+		if kind == "all" || MediaKind(item) == kind {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered
+}
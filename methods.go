@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// readMethods are the methods of the routes that only read, HEAD answers
+// like GET without the body.
+var readMethods = []string{"GET", "HEAD"}
+
+// routeMethods are the methods tried when telling a client which ones a path
+// allows.
+var routeMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE"}
+
+// AllowedMethods returns the methods router has a route for at the path of
+// request.
+func AllowedMethods(router *mux.Router, request *http.Request) []string {
+	var allowed []string
+	for _, method := range routeMethods {
+		probe := *request
+		probe.Method = method
+		var match mux.RouteMatch
+		if router.Match(&probe, &match) && match.MatchErr == nil {
+			allowed = append(allowed, method)
+		}
+	}
+	return allowed
+}
+
+// MethodNotAllowed answers requests whose path has routes, but none for
+// their method, with 405 and the methods that would have worked.
+func MethodNotAllowed(router *mux.Router) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		response.Header().Set("Allow", strings.Join(AllowedMethods(router, request), ", "))
+		http.Error(response, "Method not allowed.", http.StatusMethodNotAllowed)
+	})
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouterMethods(t *testing.T) {
+	setFlag(t, verifyObjects, true)
+	s := newTestServer(t, &fakeBucket{})
+	router := s.NewRouter()
+
+	tests := []struct {
+		method string
+		path   string
+		status int
+		allow  string
+	}{
+		{"GET", "/robots.txt", http.StatusOK, ""},
+		{"HEAD", "/robots.txt", http.StatusOK, ""},
+		{"POST", "/robots.txt", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"DELETE", "/robots.txt", http.StatusMethodNotAllowed, "GET, HEAD"},
+		// Routes with variables reach their handler, which doesn't find the
+		// object.
+		{"GET", "/raw/missing.mp4", http.StatusNotFound, ""},
+		{"HEAD", "/raw/missing.mp4", http.StatusNotFound, ""},
+		{"POST", "/raw/missing.mp4", http.StatusMethodNotAllowed, "GET, HEAD"},
+		{"GET", "/admin/reload-blocked", http.StatusMethodNotAllowed, "POST"},
+		{"PUT", "/admin/banner", http.StatusMethodNotAllowed, "GET, POST"},
+		{"POST", "/no/such/route", http.StatusNotFound, ""},
+	}
+	for _, test := range tests {
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, httptest.NewRequest(test.method, test.path, nil))
+		if response.Code != test.status {
+			t.Errorf("%s %s: status %d, want %d", test.method, test.path, response.Code, test.status)
+		}
+		if got := response.Header().Get("Allow"); got != test.allow {
+			t.Errorf("%s %s: Allow %q, want %q", test.method, test.path, got, test.allow)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	canonicalRedirects = flag.Bool("canonical-redirects", true, "Redirect paths with a missing or extra trailing slash to their canonical form. When disabled they are served as they are.")
+	logSampleRate      = flag.Float64("log-sample-rate", 1, "Fraction of successful requests written to the access log, between 0 and 1. Failed requests are always logged.")
+	handlerTimeout     = flag.Duration("handler-timeout", 30*time.Second, "Longest time a page or API request may take before it fails with 503. 0 disables the limit. Downloads, uploads and streams are never cut off.")
+)
+
+// Routes that stream for as long as the transfer takes and can't be buffered
+// by http.TimeoutHandler.
+var longRunningPrefixes = []string{"/download/", "/r/", "/s/", "/upload", "/api/objects/stream", "/api/changes", "/api/inventory.jsonl", "/verify/"}
+
+const timeoutPage = `<!doctype html><html><head><title>Timeout</title></head><body><h1>Timeout</h1><p>The server took too long to answer, please try again.</p></body></html>`
+
+// Routes taking an object or channel name, which never end with a slash.
+var namedRoutePrefixes = []string{"/play/", "/channel/", "/hls/", "/raw/", "/api/url/", "/url/", "/share/", "/download/", "/preview/", "/api/embed/", "/verify/", "/transcode/", "/api/transcode/", "/api/restore/", "/diff/", "/thumbnails/", "/edit/", "/api/metadata/", "/api/undelete/"}
+
+// Handler wraps router in the middleware every request passes, outermost
+// first: tracing, the access log, authentication, the body limit, the
+// handler timeout and canonical redirects. Nothing but the trace and the log
+// happens before a request is authenticated, redirects included.
+func (s *Server) Handler(router http.Handler) http.Handler {
+	return TraceRequests(LogRequests(s.Authenticate(LimitBody(LimitTime(RedirectCanonical(router))))))
+}
+
+func isBodyTooLarge(err error) bool {
+	var maxBytesError *http.MaxBytesError
+	return errors.As(err, &maxBytesError)
+}
+
+// bodyLimit returns the maximum request body size accepted for path.
+func bodyLimit(path string) int64 {
+	if path == "/upload" {
+		return *maxUploadSize
+	}
+	return *maxBodySize
+}
+
+// LimitBody caps the body of every request that may carry one. Requests
+// announcing a larger body are rejected right away, others fail with 413 once
+// the handler reads past the limit.
+func LimitBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if request.Method == "GET" || request.Method == "HEAD" {
+			next.ServeHTTP(response, request)
+			return
+		}
+		limit := bodyLimit(request.URL.Path)
+		if limit > 0 {
+			if request.ContentLength > limit {
+				http.Error(response, "Request body too large.", http.StatusRequestEntityTooLarge)
+				return
+			}
+			request.Body = http.MaxBytesReader(response, request.Body, limit)
+		}
+		next.ServeHTTP(response, request)
+	})
+}
+
+// CanonicalPath returns the canonical form of path: folder listings end with
+// a slash, object and channel pages don't.
+func CanonicalPath(path string) string {
+	if path == "/browse" {
+		return "/browse/"
+	}
+	if strings.HasPrefix(path, "/browse/") && !strings.HasSuffix(path, "/") {
+		return path + "/"
+	}
+	for _, prefix := range namedRoutePrefixes {
+		if strings.HasPrefix(path, prefix) {
+			if trimmed := strings.TrimRight(path, "/"); len(trimmed) >= len(prefix) {
+				return trimmed
+			}
+		}
+	}
+	return path
+}
+
+// RedirectCanonical permanently redirects requests to the canonical form of
+// their path, keeping the method for anything but GET and HEAD.
+func RedirectCanonical(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		canonical := CanonicalPath(request.URL.Path)
+		if !*canonicalRedirects || canonical == request.URL.Path {
+			next.ServeHTTP(response, request)
+			return
+		}
+		status := http.StatusMovedPermanently
+		if request.Method != "GET" && request.Method != "HEAD" {
+			status = http.StatusPermanentRedirect
+		}
+		target := url.URL{Path: canonical, RawQuery: request.URL.RawQuery}
+		http.Redirect(response, request, Link(target.String()), status)
+	})
+}
+
+func isLongRunning(path string) bool {
+	for _, prefix := range longRunningPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// LimitTime answers 503 with the timeout page when a handler runs longer
+// than -handler-timeout, except for the long running routes.
+func LimitTime(next http.Handler) http.Handler {
+	if *handlerTimeout <= 0 {
+		return next
+	}
+	timed := http.TimeoutHandler(next, *handlerTimeout, timeoutPage)
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		if isLongRunning(request.URL.Path) {
+			next.ServeHTTP(response, request)
+			return
+		}
+		timed.ServeHTTP(response, request)
+	})
+}
+
+// statusRecorder remembers the status and size of a response for the access
+// log. It forwards flushes so streaming responses keep working.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int64
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(data []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(data)
+	r.size += int64(n)
+	return n, err
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// LogRequests writes an access log entry for every failed request and for
+// the -log-sample-rate fraction of the successful ones.
+func LogRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: response}
+		next.ServeHTTP(recorder, request)
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		if recorder.status < 400 && rand.Float64() >= *logSampleRate {
+			return
+		}
+		log.WithFields(log.Fields{
+			"method":     request.Method,
+			"path":       request.URL.Path,
+			"status":     recorder.status,
+			"size":       recorder.size,
+			"duration":   time.Since(start),
+			"remoteAddr": request.RemoteAddr,
+		}).Info("Request.")
+	})
+}
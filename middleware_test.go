@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// pipelineServer returns the handler chain around a router that answers
+// with the authenticated user after waiting for delay.
+func pipelineServer(delay time.Duration) http.Handler {
+	s := &Server{Users: map[string]string{"alice": "secret"}}
+	return s.Handler(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		time.Sleep(delay)
+		response.Write([]byte("user=" + CurrentUser(request)))
+	}))
+}
+
+func servePipeline(handler http.Handler, request *http.Request, user bool) *httptest.ResponseRecorder {
+	if user {
+		request.SetBasicAuth("alice", "secret")
+	}
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	return response
+}
+
+func TestPipelineAuthenticatesBeforeRedirecting(t *testing.T) {
+	setFlag(t, canonicalRedirects, true)
+	handler := pipelineServer(0)
+
+	response := servePipeline(handler, httptest.NewRequest("GET", "/play/a/b.mp4/", nil), false)
+	if response.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous non-canonical path: status %d, want %d", response.Code, http.StatusUnauthorized)
+	}
+	if location := response.Header().Get("Location"); location != "" {
+		t.Errorf("anonymous non-canonical path redirected to %s before authentication", location)
+	}
+
+	response = servePipeline(handler, httptest.NewRequest("GET", "/play/a/b.mp4/", nil), true)
+	if response.Code != http.StatusMovedPermanently || response.Header().Get("Location") != "/play/a/b.mp4" {
+		t.Errorf("non-canonical path: status %d to %q, want %d to /play/a/b.mp4", response.Code, response.Header().Get("Location"), http.StatusMovedPermanently)
+	}
+
+	response = servePipeline(handler, httptest.NewRequest("GET", "/play/a/b.mp4", nil), true)
+	if got := response.Body.String(); got != "user=alice" {
+		t.Errorf("canonical path: handler answered %q, want user=alice", got)
+	}
+}
+
+func TestPipelineAuthenticatesBeforeLimitingBodies(t *testing.T) {
+	setFlag(t, maxBodySize, 16)
+	handler := pipelineServer(0)
+	large := func() *http.Request {
+		return httptest.NewRequest("POST", "/admin/banner", strings.NewReader(strings.Repeat("x", 64)))
+	}
+	if response := servePipeline(handler, large(), false); response.Code != http.StatusUnauthorized {
+		t.Errorf("anonymous large body: status %d, want %d", response.Code, http.StatusUnauthorized)
+	}
+	if response := servePipeline(handler, large(), true); response.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("large body: status %d, want %d", response.Code, http.StatusRequestEntityTooLarge)
+	}
+}
+
+func TestPipelineTimesOutShortRequestsOnly(t *testing.T) {
+	setFlag(t, handlerTimeout, 10*time.Millisecond)
+	handler := pipelineServer(50 * time.Millisecond)
+
+	if response := servePipeline(handler, httptest.NewRequest("GET", "/api/suggest", nil), true); response.Code != http.StatusServiceUnavailable {
+		t.Errorf("slow page: status %d, want %d", response.Code, http.StatusServiceUnavailable)
+	}
+	paths := []string{"/upload"}
+	for _, prefix := range longRunningPrefixes {
+		paths = append(paths, prefix+"clip.mp4")
+	}
+	for _, path := range paths {
+		method := "GET"
+		if path == "/upload" {
+			method = "POST"
+		}
+		response := servePipeline(handler, httptest.NewRequest(method, path, nil), true)
+		// Public paths like /s/ run without a user.
+		if response.Code != http.StatusOK || !strings.HasPrefix(response.Body.String(), "user=") {
+			t.Errorf("%s %s: status %d, %q, want it to run past the timeout", method, path, response.Code, response.Body)
+		}
+	}
+}
+
+func TestPipelineLogsRejectedRequests(t *testing.T) {
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	setFlag(t, logSampleRate, 0)
+	handler := pipelineServer(0)
+
+	servePipeline(handler, httptest.NewRequest("GET", "/play/a.mp4", nil), false)
+	if !strings.Contains(output.String(), "status=401") {
+		t.Errorf("access log %q doesn't have the rejected request", output.String())
+	}
+}
+
+func TestCanonicalPath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/play/a/b/c.mp4", "/play/a/b/c.mp4"},
+		{"/play/a/b/c.mp4/", "/play/a/b/c.mp4"},
+		{"/play/a/b/c.mp4//", "/play/a/b/c.mp4"},
+		{"/raw/a/b/c.mp4/", "/raw/a/b/c.mp4"},
+		{"/play/", "/play/"},
+		{"/browse", "/browse/"},
+		{"/browse/a/b", "/browse/a/b/"},
+		{"/browse/a/b/", "/browse/a/b/"},
+		{"/", "/"},
+		{"/search", "/search"},
+	}
+	for _, test := range tests {
+		if got := CanonicalPath(test.path); got != test.want {
+			t.Errorf("CanonicalPath(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestRedirectCanonical(t *testing.T) {
+	handler := RedirectCanonical(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		response.Write([]byte(request.URL.Path))
+	}))
+	tests := []struct {
+		method   string
+		path     string
+		enabled  bool
+		status   int
+		location string
+	}{
+		{"GET", "/play/a/b/c.mp4", true, http.StatusOK, ""},
+		{"GET", "/play/a/b/c.mp4/?t=10", true, http.StatusMovedPermanently, "/play/a/b/c.mp4?t=10"},
+		{"HEAD", "/raw/a/b/c.mp4/", true, http.StatusMovedPermanently, "/raw/a/b/c.mp4"},
+		{"POST", "/api/metadata/a/b/c.mp4/", true, http.StatusPermanentRedirect, "/api/metadata/a/b/c.mp4"},
+		{"GET", "/browse/a/b", true, http.StatusMovedPermanently, "/browse/a/b/"},
+		{"GET", "/play/a/b/c.mp4/", false, http.StatusOK, ""},
+	}
+	for _, test := range tests {
+		setFlag(t, canonicalRedirects, test.enabled)
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, httptest.NewRequest(test.method, test.path, nil))
+		if response.Code != test.status || response.Header().Get("Location") != test.location {
+			t.Errorf("%s %s: status %d to %q, want %d to %q", test.method, test.path, response.Code, response.Header().Get("Location"), test.status, test.location)
+		}
+	}
+}
+
+func TestPlayCapturesNestedNames(t *testing.T) {
+	setFlag(t, signingVersion, "v4")
+	key, err := ParsePrivateKey([]byte(conformanceKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket := &fakeBucket{}
+	bucket.add("a/b/c.mp4", "video", storage.Object{ContentType: "video/mp4"})
+	s := newTestServer(t, bucket)
+	s.Credentials().SigningKey = key
+	handler := s.Handler(s.NewRouter())
+	for _, path := range []string{"/play/a/b/c.mp4", "/play/a/b/c.mp4/"} {
+		request := httptest.NewRequest("GET", path, nil)
+		request.Header.Set("Accept", "application/json")
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		if location := response.Header().Get("Location"); location != "" {
+			response = httptest.NewRecorder()
+			request = httptest.NewRequest("GET", location, nil)
+			request.Header.Set("Accept", "application/json")
+			handler.ServeHTTP(response, request)
+		}
+		var metadata VideoMetadata
+		if err := json.NewDecoder(response.Body).Decode(&metadata); response.Code != http.StatusOK || err != nil {
+			t.Errorf("%s: status %d, %v", path, response.Code, err)
+			continue
+		}
+		if metadata.Name != "a/b/c.mp4" {
+			t.Errorf("%s: name %q, want a/b/c.mp4", path, metadata.Name)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+var pageSize = flag.Int("page-size", 100, "Number of objects shown on each index page. 0 shows all of them.")
+
+// stateParams are the query parameters that make up the browsing state and
+// are carried over by every link on a listing page. Changing one of them
+// changes the listing, so the page number starts over.
+var stateParams = []string{"q", "sort", "order", "media", "minSize", "maxSize", "since", "until", "prefix"}
+
+// Pagination describes the page of a listing being shown.
+type Pagination struct {
+	Page  int
+	Pages int
+	Total int
+}
+
+func (p Pagination) Prev() int {
+	if p.Page > 1 {
+		return p.Page - 1
+	}
+	return 0
+}
+
+func (p Pagination) Next() int {
+	if p.Page < p.Pages {
+		return p.Page + 1
+	}
+	return 0
+}
+
+// ParsePage reads the page query parameter, starting at 1.
+func ParsePage(request *http.Request) int {
+	page, err := strconv.Atoi(request.FormValue("page"))
+	if err != nil || page < 1 {
+		return 1
+	}
+	return page
+}
+
+// Paginate returns the items on page, moving past the end to the last page.
+func Paginate(items []*storage.Object, page int, size int) ([]*storage.Object, Pagination) {
+	if size <= 0 {
+		return items, Pagination{Page: 1, Pages: 1, Total: len(items)}
+	}
+	pages := (len(items) + size - 1) / size
+	if pages == 0 {
+		pages = 1
+	}
+	if page > pages {
+		page = pages
+	}
+	start := (page - 1) * size
+	end := start + size
+	if end > len(items) {
+		end = len(items)
+	}
+	return items[start:end], Pagination{Page: page, Pages: pages, Total: len(items)}
+}
+
+// BrowsingState returns the state parameters of request as a query string.
+func BrowsingState(request *http.Request) string {
+	state := url.Values{}
+	for _, name := range stateParams {
+		if value := request.FormValue(name); value != "" {
+			state.Set(name, value)
+		}
+	}
+	return state.Encode()
+}
+
+// BuildURL merges overrides, given as name and value pairs, into the query of
+// base. Empty values remove the parameter. Overriding a state parameter without
+// choosing a page or offset goes back to the first page.
+func BuildURL(base string, overrides ...interface{}) (string, error) {
+	if len(overrides)%2 != 0 {
+		return "", fmt.Errorf("buildURL needs name and value pairs, got %d arguments", len(overrides))
+	}
+	parsed, err := url.Parse(base)
+	if err != nil {
+		return "", err
+	}
+	query := parsed.Query()
+	resetPage := false
+	setPage := false
+	for i := 0; i < len(overrides); i += 2 {
+		name := fmt.Sprint(overrides[i])
+		value := fmt.Sprint(overrides[i+1])
+		if value == "" || (name == "page" && value == "0") {
+			query.Del(name)
+		} else {
+			query.Set(name, value)
+		}
+		if name == "page" || name == "offset" {
+			setPage = true
+		}
+		for _, param := range stateParams {
+			if name == param {
+				resetPage = true
+			}
+		}
+	}
+	if resetPage && !setPage {
+		query.Del("page")
+		query.Del("offset")
+	}
+	// The first page is the default, keep its URL short.
+	if query.Get("page") == "1" {
+		query.Del("page")
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String(), nil
+}
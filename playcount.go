@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"google.golang.org/api/googleapi"
+	storage "google.golang.org/api/storage/v1"
+)
+
+var (
+	playCountsFile         = flag.String("play-counts-file", "", "File the play counts behind sort=popular are saved to so they survive restarts. They are only kept in memory when neither this nor -play-counts-object is set.")
+	playCountsObject       = flag.String("play-counts-object", "", "Object of the bucket, e.g. _stats.json, the play counts are saved to instead of -play-counts-file. Replicas sharing the bucket load it at startup and each saves its own counts, the last save wins and the plays counted by the others since they started are lost. The object is never listed or served.")
+	playCountsSaveInterval = flag.Duration("play-counts-save-interval", time.Minute, "How often changed play counts are saved, they are saved on exit as well.")
+)
+
+// PlayCounts counts how often each object's play page was opened.
+type PlayCounts struct {
+	mutex  sync.Mutex
+	counts map[string]int64
+	// dirty is set when counts changed since they were last saved.
+	dirty bool
+	// saving is held while the counts are written.
+	saving sync.Mutex
+}
+
+// playCounts is global as sorting has no server at hand.
+var playCounts = &PlayCounts{counts: make(map[string]int64)}
+
+// Increment counts a play of objectName.
+func (p *PlayCounts) Increment(objectName string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.counts[objectName]++
+	p.dirty = true
+}
+
+// Count returns how often objectName was played.
+func (p *PlayCounts) Count(objectName string) int64 {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.counts[objectName]
+}
+
+// playCountsStore is where the counts are saved, -play-counts-file or
+// -play-counts-object.
+type playCountsStore interface {
+	// Load returns the saved counts, nil when nothing was saved yet.
+	Load() ([]byte, error)
+	Save(data []byte) error
+	String() string
+}
+
+type playCountsFileStore string
+
+func (f playCountsFileStore) Load() ([]byte, error) {
+	data, err := ioutil.ReadFile(string(f))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (f playCountsFileStore) Save(data []byte) error {
+	// Write and rename so a crash never leaves a truncated file.
+	if err := ioutil.WriteFile(string(f)+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(string(f)+".tmp", string(f))
+}
+
+func (f playCountsFileStore) String() string { return string(f) }
+
+// playCountsObjectStore keeps the counts in an object of the bucket, so that
+// replicas sharing the bucket start from the same counts. Each replica
+// saves the counts it has, the last one to save wins.
+type playCountsObjectStore struct {
+	server *Server
+	name   string
+}
+
+func (o playCountsObjectStore) Load() ([]byte, error) {
+	res, err := o.server.Storage().Objects.Get(bucketName, StorageName(o.name)).Download()
+	if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	return ioutil.ReadAll(res.Body)
+}
+
+func (o playCountsObjectStore) Save(data []byte) error {
+	object := &storage.Object{Name: StorageName(o.name), ContentType: "application/json", CacheControl: "no-store"}
+	_, err := o.server.Storage().Objects.Insert(bucketName, object).Media(bytes.NewReader(data)).Do()
+	return err
+}
+
+func (o playCountsObjectStore) String() string {
+	return "gs://" + bucketName + "/" + StorageName(o.name)
+}
+
+// Load replaces the counts with those saved in store. Nothing saved yet
+// leaves them alone.
+func (p *PlayCounts) Load(store playCountsStore) error {
+	data, err := store.Load()
+	if err != nil || data == nil {
+		return err
+	}
+	counts := make(map[string]int64)
+	if err := json.Unmarshal(data, &counts); err != nil {
+		return err
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.counts, p.dirty = counts, false
+	return nil
+}
+
+// Save writes the counts to store if they changed since the last save.
+func (p *PlayCounts) Save(store playCountsStore) error {
+	// Saves run one at a time, so that an older snapshot never replaces a
+	// newer one.
+	p.saving.Lock()
+	defer p.saving.Unlock()
+	p.mutex.Lock()
+	if !p.dirty {
+		p.mutex.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(p.counts)
+	p.dirty = false
+	p.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := store.Save(data); err != nil {
+		p.markDirty()
+		return err
+	}
+	return nil
+}
+
+// markDirty makes the next Save try again after a failed one.
+func (p *PlayCounts) markDirty() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.dirty = true
+}
+
+// RunSaver saves the counts to store every interval, forever. Plays in
+// between are written together.
+func (p *PlayCounts) RunSaver(store playCountsStore, interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		if err := p.Save(store); err != nil {
+			log.WithFields(log.Fields{
+				"playCounts":    store.String(),
+				"internalError": err,
+			}).Warn("Failed saving play counts.")
+		}
+	}
+}
+
+// SaveOnExit saves the counts to store once more when the server is
+// interrupted or terminated, and exits.
+func (p *PlayCounts) SaveOnExit(store playCountsStore) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		received := <-signals
+		if err := p.Save(store); err != nil {
+			log.WithFields(log.Fields{
+				"playCounts":    store.String(),
+				"internalError": err,
+			}).Error("Failed saving play counts on exit.")
+			os.Exit(1)
+		}
+		log.WithFields(log.Fields{
+			"signal": received.String(),
+		}).Info("Saved play counts, exiting.")
+		os.Exit(0)
+	}()
+}
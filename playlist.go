@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+var wrapPlayback = flag.Bool("wrap-playback", false, "Make next on the last video go to the first one and previous on the first go to the last.")
+
+// PlayOrder returns the videos in the order the index shows them for the
+// browsing state of the request.
+func (s *Server) PlayOrder(request *http.Request) ([]*storage.Object, error) {
+	items, err := s.CachedObjects(request.Context(), "")
+	if err != nil {
+		return nil, err
+	}
+	filter, err := s.ListFilter(request)
+	if err != nil {
+		return nil, err
+	}
+	items = FilterVideos(filter(items))
+	SortObjects(items, ParseSort(request))
+	return items, nil
+}
+
+// Neighbors returns the videos before and after objectName in items, or ""
+// at the ends of the list unless -wrap-playback is set. It returns false
+// when objectName isn't in items.
+func Neighbors(items []*storage.Object, objectName string) (string, string, bool) {
+	for i, item := range items {
+		if item.Name != objectName {
+			continue
+		}
+		var prev, next string
+		if i > 0 {
+			prev = items[i-1].Name
+		} else if *wrapPlayback && len(items) > 1 {
+			prev = items[len(items)-1].Name
+		}
+		if i < len(items)-1 {
+			next = items[i+1].Name
+		} else if *wrapPlayback && len(items) > 1 {
+			next = items[0].Name
+		}
+		return prev, next, true
+	}
+	return "", "", false
+}
+
+// NextVideo is what a player needs to continue with the next video. It is
+// empty at the end of the list.
+type NextVideo struct {
+	Name    string `json:"name,omitempty"`
+	Url     string `json:"url,omitempty"`
+	SubUrl  string `json:"subUrl,omitempty"`
+	Stream  bool   `json:"stream,omitempty"`
+	PlayUrl string `json:"playUrl,omitempty"`
+}
+
+// NextHandler returns the video after the one named by the after query
+// parameter, in the order of the browsing state of the request.
+func (s *Server) NextHandler(response http.ResponseWriter, request *http.Request) {
+	after := request.FormValue("after")
+	if _, err := s.ListFilter(request); err != nil {
+		writeJSONError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	order, err := s.PlayOrder(request)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"internalError": err,
+		}).Warn("Failed getting video list.")
+		writeJSONError(response, http.StatusInternalServerError, "could not list videos")
+		return
+	}
+	_, next, ok := Neighbors(order, after)
+	if !ok {
+		writeJSONError(response, http.StatusNotFound, "object not found")
+		return
+	}
+	response.Header().Set("Cache-Control", "no-store")
+	if next == "" {
+		writeJSON(response, http.StatusOK, NextVideo{})
+		return
+	}
+	video := NextVideo{
+		Name:    next,
+		SubUrl:  s.SignUrlFor(stripExtension.ReplaceAllString(next, ".vtt"), *inlineExpiry),
+		Stream:  IsStream(next),
+		PlayUrl: Link("/play/", ObjectPath(next)),
+	}
+	if video.Stream {
+		video.Url = HLSPath(next)
+	} else {
+		video.Url = s.SignUrlFor(next, *inlineExpiry)
+	}
+	if state := BrowsingState(request); state != "" {
+		video.PlayUrl += "?" + state
+	}
+	writeJSON(response, http.StatusOK, video)
+}
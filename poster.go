@@ -0,0 +1,93 @@
+package main
+
+import (
+	"flag"
+	"path"
+	"strings"
+
+	"golang.org/x/net/context"
+	storage "google.golang.org/api/storage/v1"
+)
+
+var (
+	placeholderImage = flag.String("placeholder-image", "/static/placeholder.svg", "Poster shown for objects without a thumbnail.")
+	placeholderVideo = flag.String("placeholder-video", "/static/placeholder-video.svg", "Poster shown for videos without a thumbnail.")
+	placeholderAudio = flag.String("placeholder-audio", "/static/placeholder-audio.svg", "Poster shown for audio without a thumbnail.")
+)
+
+// Extensions of sidecar thumbnails stored next to a video, e.g. clip.jpg
+// for clip.mp4.
+var thumbnailExtensions = []string{".jpg", ".jpeg", ".png", ".webp"}
+
+// Width of the posters shown in listings.
+const posterWidth = 320
+
+// Placeholder returns the poster for objects of kind without a thumbnail.
+func Placeholder(kind string) string {
+	placeholder := *placeholderImage
+	switch kind {
+	case "video":
+		placeholder = *placeholderVideo
+	case "audio":
+		placeholder = *placeholderAudio
+	}
+	if strings.HasPrefix(placeholder, "/") {
+		return Link(placeholder)
+	}
+	return placeholder
+}
+
+// thumbnail returns the name of the sidecar thumbnail of objectName if it is
+// one of names.
+func thumbnail(names map[string]bool, objectName string) (string, bool) {
+	base := strings.TrimSuffix(objectName, path.Ext(objectName))
+	for _, extension := range thumbnailExtensions {
+		if names[base+extension] {
+			return base + extension, true
+		}
+	}
+	return "", false
+}
+
+func objectNames(items []*storage.Object) map[string]bool {
+	names := make(map[string]bool, len(items))
+	for _, item := range items {
+		names[item.Name] = true
+	}
+	return names
+}
+
+// Posters maps the name of every object in items to a preview of itself for
+// images, a preview of its sidecar thumbnail, or the placeholder for its kind.
+// Sidecars are only looked for among siblings, which can include objects
+// filtered out of items.
+func (s *Server) Posters(items []*storage.Object, siblings []*storage.Object) map[string]string {
+	names := objectNames(siblings)
+	posters := make(map[string]string, len(items))
+	for _, item := range items {
+		kind := MediaKind(item)
+		if kind == "image" {
+			posters[item.Name] = PreviewPath(item.Name, posterWidth)
+		} else if name, ok := thumbnail(names, item.Name); ok {
+			posters[item.Name] = PreviewPath(name, posterWidth)
+		} else {
+			posters[item.Name] = Placeholder(kind)
+		}
+	}
+	return posters
+}
+
+// Poster returns the poster of a single object, looking for its thumbnail
+// in the cached listing of its folder.
+func (s *Server) Poster(ctx context.Context, object *storage.Object) string {
+	prefix := ""
+	if dir := path.Dir(object.Name); dir != "." {
+		prefix = dir + "/"
+	}
+	if siblings, err := s.CachedObjects(ctx, prefix); err == nil {
+		if name, ok := thumbnail(objectNames(siblings), object.Name); ok {
+			return PreviewPath(name, *previewMaxWidth)
+		}
+	}
+	return Placeholder(MediaKind(object))
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"flag"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+	storage "google.golang.org/api/storage/v1"
+)
+
+var prefetchNext = flag.Bool("prefetch-next", false, "Prepare the next index page in the background while the current one is shown, so paging feels instant: its listing is refreshed before it expires from the cache, its links are signed and its posters made.")
+
+// prefetching holds the preview keys being made in the background, so that
+// reloading a page doesn't start the same work twice.
+var prefetching sync.Map
+
+// prefetchContext is done when the listing cache is invalidated or its
+// entries expire, as the page work is done for is gone by then.
+func (s *Server) prefetchContext() (context.Context, context.CancelFunc) {
+	ctx := s.Cache.Context()
+	cancel := func() {}
+	if *cacheTTL > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *cacheTTL)
+	}
+	return ctx, cancel
+}
+
+// PrefetchPage prepares the index page showing items in the background.
+// items were listed from prefixes, siblings are the whole listing.
+func (s *Server) PrefetchPage(prefixes []string, items []*storage.Object, siblings []*storage.Object) {
+	ctx, cancel := s.prefetchContext()
+	go func() {
+		defer cancel()
+		s.prefetchPage(ctx, prefixes, items)
+	}()
+	s.PrefetchPosters(items, siblings)
+}
+
+// prefetchPage lists prefixes again when their cached listings expire soon,
+// and signs the links of items into the listing cache.
+func (s *Server) prefetchPage(ctx context.Context, prefixes []string, items []*storage.Object) {
+	// The search index answers without listing.
+	if *cacheTTL > 0 && (s.Index == nil || !s.Index.Ready()) {
+		for _, prefix := range prefixes {
+			if s.Cache.fresh(prefix) {
+				continue
+			}
+			listed, err := s.ListObjects(ctx, prefix)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"prefix":        prefix,
+					"internalError": err,
+				}).Info("Failed prefetching listing.")
+				continue
+			}
+			// Listings from before an invalidation are outdated.
+			if ctx.Err() != nil {
+				return
+			}
+			s.Cache.put(prefix, listed)
+		}
+	}
+	for _, item := range items {
+		if ctx.Err() != nil {
+			return
+		}
+		if link := s.presign(item); link.Url != "" {
+			s.Cache.putLink(item, link)
+		}
+	}
+}
+
+// PrefetchPosters makes the previews shown as posters of items in the
+// background. Sidecar thumbnails are looked for among siblings. Work stops
+// like that of PrefetchPage.
+func (s *Server) PrefetchPosters(items []*storage.Object, siblings []*storage.Object) {
+	byName := make(map[string]*storage.Object, len(siblings))
+	for _, sibling := range siblings {
+		byName[sibling.Name] = sibling
+	}
+	names := objectNames(siblings)
+	var sources []*storage.Object
+	for _, item := range items {
+		if MediaKind(item) == "image" {
+			sources = append(sources, item)
+		} else if name, ok := thumbnail(names, item.Name); ok {
+			sources = append(sources, byName[name])
+		}
+	}
+	if len(sources) == 0 {
+		return
+	}
+
+	ctx, cancel := s.prefetchContext()
+	go func() {
+		defer cancel()
+		for _, source := range sources {
+			if ctx.Err() != nil {
+				return
+			}
+			key := previewKey(source, posterWidth)
+			if _, busy := prefetching.LoadOrStore(key, true); busy {
+				continue
+			}
+			_, _, err := s.Preview(ctx, source, posterWidth)
+			prefetching.Delete(key)
+			if err != nil {
+				log.WithFields(log.Fields{
+					"objectName":    source.Name,
+					"internalError": err,
+				}).Info("Failed prefetching preview.")
+			}
+		}
+	}()
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// newSigningServer returns a test server for bucket that signs V4 URLs with
+// the conformance key.
+func newSigningServer(t testing.TB, bucket *fakeBucket) *Server {
+	t.Helper()
+	setFlag(t, signingVersion, "v4")
+	setFlag(t, googleAccessId, conformanceAccessID)
+	key, err := ParsePrivateKey([]byte(conformanceKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, bucket)
+	s.Credentials().SigningKey = key
+	return s
+}
+
+func TestPrefetchPage(t *testing.T) {
+	bucket := &fakeBucket{}
+	bucket.add("docs/a.pdf", "document", storage.Object{ContentType: "application/pdf"})
+	bucket.add("docs/b.mp4", "video", storage.Object{ContentType: "video/mp4"})
+	s := newSigningServer(t, bucket)
+	items, err := s.ListObjects(context.Background(), "docs/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.prefetchPage(context.Background(), []string{"docs/"}, items)
+	if !s.Cache.fresh("docs/") {
+		t.Errorf("the listing of docs/ wasn't cached")
+	}
+	link, ok := s.Cache.takeLink(items[0])
+	if !ok || !link.Available || link.Url == "" {
+		t.Errorf("%s: link %+v, %v, want a signed one", items[0].Name, link, ok)
+	}
+	if _, ok := s.Cache.takeLink(items[0]); ok {
+		t.Errorf("%s: the signed link was handed out twice", items[0].Name)
+	}
+	if _, ok := s.Cache.takeLink(items[1]); ok {
+		t.Errorf("%s: a video link was signed", items[1].Name)
+	}
+
+	s.prefetchPage(context.Background(), nil, items)
+	s.Cache.Invalidate()
+	if _, ok := s.Cache.takeLink(items[0]); ok {
+		t.Errorf("%s: the signed link outlived the invalidated listing", items[0].Name)
+	}
+}
+
+// BenchmarkNextIndexPage shows the second index page of a folder of
+// documents, as when the listing cache expired, and after page one
+// prefetched it.
+func BenchmarkNextIndexPage(b *testing.B) {
+	setFlag(b, pageSize, 50)
+	bucket := &fakeBucket{}
+	for i := 0; i < 500; i++ {
+		bucket.add(fmt.Sprintf("docs/file-%04d.pdf", i), "document", storage.Object{ContentType: "application/pdf"})
+	}
+	s := newSigningServer(b, bucket)
+	request := httptest.NewRequest("GET", "/?page=2", nil)
+	prefixes := s.IndexPrefixes("")
+	nextPage := func() IndexPage {
+		page, err := s.NewIndexPage(request, prefixes, s.IndexObjects(context.Background(), "", ""))
+		if err != nil {
+			b.Fatal(err)
+		}
+		return page
+	}
+	next := nextPage().Items
+
+	b.Run("cold", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			s.Cache.Invalidate()
+			b.StartTimer()
+			nextPage()
+		}
+	})
+	b.Run("prefetched", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			b.StopTimer()
+			s.Cache.Invalidate()
+			s.prefetchPage(context.Background(), prefixes, next)
+			b.StartTimer()
+			nextPage()
+		}
+	})
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"golang.org/x/image/draw"
+	"golang.org/x/net/context"
+	storage "google.golang.org/api/storage/v1"
+)
+
+var (
+	previewMaxWidth  = flag.Int("preview-max-width", 1920, "Largest width /preview resizes images to.")
+	previewCacheSize = flag.Int("preview-cache-size", 256, "Number of resized previews kept in memory.")
+	previewMaxSource = flag.Int64("preview-max-source-size", 32<<20, "Images larger than this many bytes are not resized but served as they are.")
+)
+
+const (
+	defaultPreviewWidth = 320
+	previewMaxPixels    = 50 * 1000 * 1000
+	previewJPEGQuality  = 80
+	// Widths are rounded up to a multiple of this so arbitrary values can't
+	// fill the cache.
+	previewWidthStep = 32
+)
+
+// Decoders for the image types /preview resizes.
+var previewFormats = map[string]func(io.Reader) (image.Image, error){
+	"image/jpeg": jpeg.Decode,
+	"image/png":  png.Decode,
+	"image/gif":  gif.Decode,
+}
+
+type preview struct {
+	data        []byte
+	contentType string
+}
+
+// PreviewCache keeps the most recently made previews, evicting the oldest
+// once it holds -preview-cache-size of them. Keys include the generation, so
+// a replaced object never serves a stale preview.
+type PreviewCache struct {
+	mutex   sync.Mutex
+	entries map[string]preview
+	order   []string
+}
+
+func NewPreviewCache() *PreviewCache {
+	return &PreviewCache{entries: make(map[string]preview)}
+}
+
+func (c *PreviewCache) get(key string) (preview, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry, ok := c.entries[key]
+	return entry, ok
+}
+
+func (c *PreviewCache) put(key string, entry preview) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.entries[key]; ok || *previewCacheSize <= 0 {
+		return
+	}
+	for len(c.order) >= *previewCacheSize {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.entries[key] = entry
+	c.order = append(c.order, key)
+}
+
+// PreviewPath returns the URL of a preview of objectName at most width
+// pixels wide.
+func PreviewPath(objectName string, width int) string {
+	return Link("/preview/", ObjectPath(objectName), "?w=", width)
+}
+
+// previewWidth reads the w query parameter, capped to -preview-max-width.
+func previewWidth(request *http.Request) int {
+	width, err := strconv.Atoi(request.FormValue("w"))
+	if err != nil || width <= 0 {
+		width = defaultPreviewWidth
+	}
+	width = (width + previewWidthStep - 1) / previewWidthStep * previewWidthStep
+	if width > *previewMaxWidth {
+		width = *previewMaxWidth
+	}
+	return width
+}
+
+// resize decodes an image and scales it down to width, keeping the aspect
+// ratio. It returns false for images already narrow enough.
+func resize(input io.Reader, decode func(io.Reader) (image.Image, error), width int) (image.Image, bool, error) {
+	// Check the dimensions before decoding so huge images can't exhaust memory.
+	var header bytes.Buffer
+	config, _, err := image.DecodeConfig(io.TeeReader(input, &header))
+	if err != nil {
+		return nil, false, err
+	}
+	if config.Width*config.Height > previewMaxPixels {
+		return nil, false, fmt.Errorf("image of %dx%d pixels is too large", config.Width, config.Height)
+	}
+	if config.Width <= width {
+		return nil, false, nil
+	}
+	source, err := decode(io.MultiReader(&header, input))
+	if err != nil {
+		return nil, false, err
+	}
+	bounds := source.Bounds()
+	height := bounds.Dy() * width / bounds.Dx()
+	if height < 1 {
+		height = 1
+	}
+	resized := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.BiLinear.Scale(resized, resized.Bounds(), source, bounds, draw.Src, nil)
+	return resized, true, nil
+}
+
+// makePreview downloads object and resizes it. It returns false when the
+// original should be served instead.
+func (s *Server) makePreview(ctx context.Context, object *storage.Object, width int) (preview, bool, error) {
+	contentType := ContentType(object)
+	decode, ok := previewFormats[contentType]
+	if !ok || int64(object.Size) > *previewMaxSource {
+		return preview{}, false, nil
+	}
+	res, err := s.Storage().Objects.Get(bucketName, StorageName(object.Name)).Context(ctx).Download()
+	if err != nil {
+		return preview{}, false, err
+	}
+	defer res.Body.Close()
+
+	resized, ok, err := resize(io.LimitReader(res.Body, *previewMaxSource), decode, width)
+	if err != nil || !ok {
+		return preview{}, false, err
+	}
+	var output bytes.Buffer
+	// GIFs and PNGs may be transparent, keep them as PNG.
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&output, resized, &jpeg.Options{Quality: previewJPEGQuality})
+	} else {
+		contentType = "image/png"
+		err = png.Encode(&output, resized)
+	}
+	if err != nil {
+		return preview{}, false, err
+	}
+	return preview{data: output.Bytes(), contentType: contentType}, true, nil
+}
+
+func previewKey(object *storage.Object, width int) string {
+	return fmt.Sprintf("%s#%d#%d", object.Name, object.Generation, width)
+}
+
+// Preview returns the cached preview of object at width or makes it. It
+// returns false when the original should be served instead, including when
+// all download slots are busy.
+func (s *Server) Preview(ctx context.Context, object *storage.Object, width int) (preview, bool, error) {
+	key := previewKey(object, width)
+	if entry, ok := s.Previews.get(key); ok {
+		return entry, true, nil
+	}
+	if !s.acquireDownload() {
+		return preview{}, false, nil
+	}
+	entry, ok, err := s.makePreview(ctx, object, width)
+	s.releaseDownload()
+	if ok {
+		s.Previews.put(key, entry)
+	}
+	return entry, ok, err
+}
+
+// PreviewHandler serves a resized copy of an image, or redirects to the
+// original for anything it can't or doesn't need to resize.
+func (s *Server) PreviewHandler(response http.ResponseWriter, request *http.Request) {
+	objectName := mux.Vars(request)["objectName"]
+	object, err := s.GetObject(request.Context(), objectName)
+	if err != nil {
+		http.NotFound(response, request)
+		return
+	}
+	entry, ok, err := s.Preview(request.Context(), object, previewWidth(request))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"objectName":    objectName,
+			"internalError": err,
+		}).Warn("Failed making preview.")
+	}
+	if !ok {
+		http.Redirect(response, request, s.SignObject(object), http.StatusFound)
+		return
+	}
+
+	NoIndex(response)
+	response.Header().Set("Content-type", entry.contentType)
+	response.Header().Set("Content-Length", strconv.Itoa(len(entry.data)))
+	response.Header().Set("Cache-Control", "private, max-age=86400")
+	response.Write(entry.data)
+}
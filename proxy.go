@@ -0,0 +1,263 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	storage "google.golang.org/api/storage/v1"
+)
+
+var proxyOnly = flag.Bool("proxy-only", false, "Never sign URLs and serve every object through /download instead, so all access goes through the server's authentication. No PEM file is needed.")
+
+// Seconds clients are asked to wait when all download slots are taken.
+const downloadRetryAfter = 10
+
+// acquireDownload takes one of the -max-concurrent-downloads slots without
+// waiting. It always succeeds when no limit is configured.
+func (s *Server) acquireDownload() bool {
+	if s.DownloadSlots == nil {
+		return true
+	}
+	select {
+	case s.DownloadSlots <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *Server) releaseDownload() {
+	if s.DownloadSlots != nil {
+		<-s.DownloadSlots
+	}
+}
+
+// ProxyObject streams an object from the bucket to the client using the
+// service account, passing through Range requests so seeking works.
+func (s *Server) ProxyObject(response http.ResponseWriter, request *http.Request, objectName string) {
+	s.ProxyGeneration(response, request, objectName, 0)
+}
+
+// ProxyGeneration is ProxyObject for a generation of the object, the live
+// one for 0.
+func (s *Server) ProxyGeneration(response http.ResponseWriter, request *http.Request, objectName string, generation int64) {
+	if s.Blocked.Blocked(objectName) {
+		logBlocked(request, objectName)
+		http.Error(response, "Forbidden", http.StatusForbidden)
+		return
+	}
+	object, err := s.GetGeneration(request.Context(), objectName, generation)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"objectName":    objectName,
+			"internalError": err,
+		}).Warn("Failed getting info for download.")
+		http.NotFound(response, request)
+		return
+	}
+	etag := ObjectETag(object)
+	response.Header().Set("ETag", etag)
+	setCacheControl(response, object)
+	if NotModified(request, etag, UpdatedTime(object)) {
+		NoIndex(response)
+		response.WriteHeader(http.StatusNotModified)
+		return
+	}
+	rangeHeader := request.Header.Get("Range")
+	if ifRange := request.Header.Get("If-Range"); ifRange != "" && ifRange != etag {
+		// The client's partial copy is of another generation.
+		rangeHeader = ""
+	}
+	// The size of compressed objects isn't what GCS serves when it
+	// decompresses them, their ranges are passed through as they are.
+	var byteRange ByteRange
+	ranged := false
+	if rangeHeader != "" && object.ContentEncoding == "" {
+		var err error
+		byteRange, ranged, err = ParseRange(rangeHeader, int64(object.Size))
+		if err != nil {
+			response.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", object.Size))
+			http.Error(response, "Range not satisfiable.", http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+		rangeHeader = ""
+		if ranged {
+			rangeHeader = byteRange.Header()
+		}
+	}
+	if request.Method == "HEAD" {
+		// The metadata has all the headers, the body is never downloaded.
+		writeHeadHeaders(response, object, byteRange, ranged)
+		return
+	}
+
+	if !s.acquireDownload() {
+		log.WithFields(log.Fields{
+			"objectName": objectName,
+			"limit":      *maxConcurrentDownloads,
+		}).Warn("Too many concurrent downloads.")
+		response.Header().Set("Retry-After", strconv.Itoa(downloadRetryAfter))
+		http.Error(response, "Too many concurrent downloads, try again later.", http.StatusServiceUnavailable)
+		return
+	}
+	defer s.releaseDownload()
+
+	// Download the generation the ETag names, even if the object was
+	// replaced in the meantime.
+	call := s.Storage().Objects.Get(bucketName, StorageName(objectName)).Generation(object.Generation).Context(request.Context())
+	if rangeHeader != "" {
+		call.Header().Set("Range", rangeHeader)
+	}
+	res, err := call.Download()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"objectName":    objectName,
+			"internalError": err,
+		}).Warn("Failed downloading object.")
+		http.NotFound(response, request)
+		return
+	}
+	defer res.Body.Close()
+
+	for _, header := range []string{"Content-Type", "Content-Length", "Content-Range", "Accept-Ranges", "Last-Modified"} {
+		if value := res.Header.Get(header); value != "" {
+			response.Header().Set(header, value)
+		}
+	}
+	if contentType, ok := OverrideType(objectName); ok {
+		response.Header().Set("Content-Type", contentType)
+	}
+	// Only complete downloads of the stored bytes can be checked.
+	verify := *verifyChecksums && res.StatusCode == http.StatusOK && object.ContentEncoding == ""
+	if verify {
+		// Trailers need a chunked response.
+		response.Header().Del("Content-Length")
+		response.Header().Set("Trailer", checksumTrailer)
+	}
+	NoIndex(response)
+	response.WriteHeader(res.StatusCode)
+	body := Throttle(request.Context(), res.Body, NewRateLimiter(*downloadRateKbps), s.DownloadLimiter)
+	checksums := NewChecksums()
+	if verify {
+		body = io.TeeReader(body, checksums)
+	}
+	if _, err := io.Copy(response, body); err != nil {
+		log.WithFields(log.Fields{
+			"objectName":    objectName,
+			"internalError": err,
+		}).Info("Proxy download interrupted.")
+		return
+	}
+	if verify {
+		err := checksums.Verify(object)
+		logChecksum(object, err)
+		response.Header().Set(checksumTrailer, strconv.FormatBool(err == nil))
+	}
+}
+
+// writeHeadHeaders answers a HEAD request for object with the headers a
+// download of byteRange would have, or of the whole object unless ranged.
+func writeHeadHeaders(response http.ResponseWriter, object *storage.Object, byteRange ByteRange, ranged bool) {
+	contentType := object.ContentType
+	if override, ok := OverrideType(object.Name); ok {
+		contentType = override
+	}
+	if contentType != "" {
+		response.Header().Set("Content-Type", contentType)
+	}
+	if updated, err := time.Parse(time.RFC3339Nano, object.Updated); err == nil {
+		response.Header().Set("Last-Modified", updated.UTC().Format(http.TimeFormat))
+	}
+	status := http.StatusOK
+	// Decompressed objects have no known length and can't be ranged.
+	if object.ContentEncoding == "" {
+		response.Header().Set("Accept-Ranges", "bytes")
+		length := int64(object.Size)
+		if ranged {
+			response.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", byteRange.Start, byteRange.End, object.Size))
+			length = byteRange.End - byteRange.Start + 1
+			status = http.StatusPartialContent
+		}
+		response.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	}
+	NoIndex(response)
+	response.WriteHeader(status)
+}
+
+// ObjectETag returns the entity tag of the content of object. The generation
+// changes whenever the content does, unlike the metadata.
+func ObjectETag(object *storage.Object) string {
+	return `"` + strconv.FormatInt(object.Generation, 10) + `"`
+}
+
+// NotModified reports whether the If-None-Match header of a GET or HEAD
+// request matches etag, or, for requests without one, whether the object
+// wasn't updated after If-Modified-Since.
+func NotModified(request *http.Request, etag string, updated time.Time) bool {
+	if request.Method != "GET" && request.Method != "HEAD" {
+		return false
+	}
+	if ifNoneMatch := request.Header.Get("If-None-Match"); ifNoneMatch != "" {
+		for _, candidate := range strings.Split(ifNoneMatch, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == etag || candidate == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(request.Header.Get("If-Modified-Since"))
+	if err != nil || updated.IsZero() {
+		return false
+	}
+	// HTTP dates have no fractions of seconds.
+	return !updated.Truncate(time.Second).After(since)
+}
+
+func setCacheControl(response http.ResponseWriter, object *storage.Object) {
+	if object.CacheControl != "" {
+		response.Header().Set("Cache-Control", object.CacheControl)
+	} else if *defaultCacheControl != "" {
+		response.Header().Set("Cache-Control", *defaultCacheControl)
+	}
+}
+
+// DownloadPath returns the proxy URL used instead of a signed URL in
+// -proxy-only mode. The proxy sets the response headers from the metadata
+// itself, so of the signed URL overrides only the attachment disposition and
+// the -cache-bust-param are kept.
+func DownloadPath(objectName string, params url.Values) string {
+	target := Link("/download/", ObjectPath(objectName))
+	query := url.Values{}
+	if params.Get("response-content-disposition") != "" {
+		query.Set("download", "1")
+	}
+	if *cacheBustParam != "" && params.Get(*cacheBustParam) != "" {
+		query.Set(*cacheBustParam, params.Get(*cacheBustParam))
+	}
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	return target
+}
+
+func (s *Server) DownloadHandler(response http.ResponseWriter, request *http.Request) {
+	objectName := mux.Vars(request)["objectName"]
+	generation, ok := parseGeneration(request.FormValue("generation"))
+	if !ok {
+		http.Error(response, "Invalid generation.", http.StatusBadRequest)
+		return
+	}
+	if request.FormValue("download") != "" {
+		s.setAttachment(response, request, objectName, generation)
+	}
+	s.ProxyGeneration(response, request, objectName, generation)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+// proxyRequest sends a request for objectName through ProxyObject.
+func proxyRequest(s *Server, method string, objectName string, headers map[string]string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, "/download/"+objectName, nil)
+	for name, value := range headers {
+		request.Header.Set(name, value)
+	}
+	response := httptest.NewRecorder()
+	s.ProxyObject(response, request, objectName)
+	return response
+}
+
+func TestProxyHeadSkipsDownload(t *testing.T) {
+	bucket := &fakeBucket{}
+	bucket.add("clip.mp4", "0123456789", storage.Object{ContentType: "video/mp4", Updated: "2020-01-02T03:04:05.5Z"})
+	s := newTestServer(t, bucket)
+	// HEAD requests don't take a download slot either.
+	s.DownloadSlots = make(chan struct{})
+
+	tests := []struct {
+		name         string
+		headers      map[string]string
+		status       int
+		length       string
+		contentRange string
+	}{
+		{"whole", nil, http.StatusOK, "10", ""},
+		{"range", map[string]string{"Range": "bytes=2-5"}, http.StatusPartialContent, "4", "bytes 2-5/10"},
+	}
+	for _, test := range tests {
+		response := proxyRequest(s, "HEAD", "clip.mp4", test.headers)
+		if response.Code != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, response.Code, test.status)
+		}
+		header := response.Header()
+		if got := header.Get("Content-Length"); got != test.length {
+			t.Errorf("%s: Content-Length %q, want %q", test.name, got, test.length)
+		}
+		if got := header.Get("Content-Range"); got != test.contentRange {
+			t.Errorf("%s: Content-Range %q, want %q", test.name, got, test.contentRange)
+		}
+		if got := header.Get("Content-Type"); got != "video/mp4" {
+			t.Errorf("%s: Content-Type %q, want video/mp4", test.name, got)
+		}
+		if got := header.Get("Last-Modified"); got != "Thu, 02 Jan 2020 03:04:05 GMT" {
+			t.Errorf("%s: Last-Modified %q, want Thu, 02 Jan 2020 03:04:05 GMT", test.name, got)
+		}
+		if response.Body.Len() != 0 {
+			t.Errorf("%s: HEAD response has a body of %d bytes", test.name, response.Body.Len())
+		}
+	}
+	if _, media := bucket.counts(); media != 0 {
+		t.Errorf("HEAD requests made %d media requests, want none", media)
+	}
+}
+
+func TestProxyGetDownloads(t *testing.T) {
+	bucket := &fakeBucket{}
+	bucket.add("clip.mp4", "0123456789", storage.Object{ContentType: "video/mp4"})
+	s := newTestServer(t, bucket)
+
+	response := proxyRequest(s, "GET", "clip.mp4", map[string]string{"Range": "bytes=2-5"})
+	if response.Code != http.StatusPartialContent {
+		t.Errorf("status %d, want %d", response.Code, http.StatusPartialContent)
+	}
+	if got := response.Body.String(); got != "2345" {
+		t.Errorf("body %q, want 2345", got)
+	}
+	if got := response.Header().Get("Content-Range"); got != "bytes 2-5/10" {
+		t.Errorf("Content-Range %q, want bytes 2-5/10", got)
+	}
+}
+
+func TestProxyConditionalRequests(t *testing.T) {
+	bucket := &fakeBucket{}
+	bucket.add("clip.mp4", "0123456789", storage.Object{ContentType: "video/mp4", Generation: 7, Updated: "2020-01-02T03:04:05.5Z"})
+	s := newTestServer(t, bucket)
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		status  int
+		body    string
+	}{
+		{"matching etag", "GET", map[string]string{"If-None-Match": `"7"`}, http.StatusNotModified, ""},
+		{"matching etag of HEAD", "HEAD", map[string]string{"If-None-Match": `"7"`}, http.StatusNotModified, ""},
+		{"weak etag in a list", "GET", map[string]string{"If-None-Match": `"6", W/"7"`}, http.StatusNotModified, ""},
+		{"any etag", "GET", map[string]string{"If-None-Match": "*"}, http.StatusNotModified, ""},
+		{"other etag", "GET", map[string]string{"If-None-Match": `"6"`}, http.StatusOK, "0123456789"},
+		// If-None-Match wins over If-Modified-Since.
+		{"other etag, not modified since", "GET", map[string]string{"If-None-Match": `"6"`, "If-Modified-Since": "Fri, 03 Jan 2020 00:00:00 GMT"}, http.StatusOK, "0123456789"},
+		{"not modified since", "GET", map[string]string{"If-Modified-Since": "Thu, 02 Jan 2020 03:04:05 GMT"}, http.StatusNotModified, ""},
+		{"modified since", "GET", map[string]string{"If-Modified-Since": "Thu, 02 Jan 2020 03:04:04 GMT"}, http.StatusOK, "0123456789"},
+		{"invalid date", "GET", map[string]string{"If-Modified-Since": "yesterday"}, http.StatusOK, "0123456789"},
+		{"current If-Range", "GET", map[string]string{"Range": "bytes=2-5", "If-Range": `"7"`}, http.StatusPartialContent, "2345"},
+		{"stale If-Range", "GET", map[string]string{"Range": "bytes=2-5", "If-Range": `"6"`}, http.StatusOK, "0123456789"},
+		{"If-Range date", "GET", map[string]string{"Range": "bytes=2-5", "If-Range": "Thu, 02 Jan 2020 03:04:05 GMT"}, http.StatusOK, "0123456789"},
+	}
+	for _, test := range tests {
+		_, mediaBefore := bucket.counts()
+		response := proxyRequest(s, test.method, "clip.mp4", test.headers)
+		if response.Code != test.status {
+			t.Errorf("%s: status %d, want %d", test.name, response.Code, test.status)
+		}
+		if got := response.Body.String(); got != test.body {
+			t.Errorf("%s: body %q, want %q", test.name, got, test.body)
+		}
+		if got := response.Header().Get("ETag"); got != `"7"` {
+			t.Errorf("%s: ETag %q, want \"7\"", test.name, got)
+		}
+		if _, media := bucket.counts(); test.status == http.StatusNotModified && media != mediaBefore {
+			t.Errorf("%s: downloaded the object for a 304", test.name)
+		}
+	}
+}
+
+func TestProxyRanges(t *testing.T) {
+	bucket := &fakeBucket{}
+	bucket.add("clip.mp4", "0123456789", storage.Object{ContentType: "video/mp4"})
+	s := newTestServer(t, bucket)
+
+	tests := []struct {
+		header       string
+		status       int
+		body         string
+		contentRange string
+	}{
+		{"bytes=5-100", http.StatusPartialContent, "56789", "bytes 5-9/10"},
+		{"bytes=8-3", http.StatusOK, "0123456789", ""},
+		{"bytes=10-", http.StatusRequestedRangeNotSatisfiable, "", "bytes */10"},
+	}
+	for _, test := range tests {
+		response := proxyRequest(s, "GET", "clip.mp4", map[string]string{"Range": test.header})
+		if response.Code != test.status {
+			t.Errorf("%s: status %d, want %d", test.header, response.Code, test.status)
+		}
+		if test.body != "" && response.Body.String() != test.body {
+			t.Errorf("%s: body %q, want %q", test.header, response.Body, test.body)
+		}
+		if got := response.Header().Get("Content-Range"); got != test.contentRange {
+			t.Errorf("%s: Content-Range %q, want %q", test.header, got, test.contentRange)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2/google"
+	pubsub "google.golang.org/api/pubsub/v1"
+)
+
+var pubsubSubscription = flag.String("pubsub-subscription", "", "Pub/Sub subscription receiving the bucket's object change notifications, as projects/<project>/subscriptions/<name>. When set, listings stay cached until an object changes instead of expiring after -cache-ttl.")
+
+const (
+	pubsubBatchSize  = 100
+	pubsubMaxBackoff = 5 * time.Minute
+)
+
+// Notification event types that change what a listing shows.
+var listingEvents = map[string]bool{
+	"OBJECT_FINALIZE":        true,
+	"OBJECT_DELETE":          true,
+	"OBJECT_ARCHIVE":         true,
+	"OBJECT_METADATA_UPDATE": true,
+}
+
+// NewPubsubService creates a Pub/Sub client from the default credentials.
+func NewPubsubService() (*pubsub.Service, error) {
+	client, err := google.DefaultClient(context.Background(), pubsub.PubsubScope)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get default client: %v", err)
+	}
+	return pubsub.New(client)
+}
+
+// changesListing reports whether a notification is about an object of this
+// instance changing.
+func changesListing(attributes map[string]string) bool {
+	return listingEvents[attributes["eventType"]] &&
+		attributes["bucketId"] == bucketName &&
+		strings.HasPrefix(attributes["objectId"], *rootPrefix)
+}
+
+// pullNotifications waits for the next batch of notifications, invalidates
+// the listing cache if any of them changes a listing and acknowledges them.
+func (s *Server) pullNotifications(service *pubsub.Service, subscription string) error {
+	pulled, err := service.Projects.Subscriptions.Pull(subscription, &pubsub.PullRequest{
+		MaxMessages: pubsubBatchSize,
+	}).Do()
+	if err != nil {
+		return err
+	}
+	if len(pulled.ReceivedMessages) == 0 {
+		return nil
+	}
+	changed := false
+	ackIds := make([]string, len(pulled.ReceivedMessages))
+	for i, received := range pulled.ReceivedMessages {
+		ackIds[i] = received.AckId
+		if received.Message != nil && changesListing(received.Message.Attributes) {
+			changed = true
+			log.WithFields(log.Fields{
+				"objectName": received.Message.Attributes["objectId"],
+				"eventType":  received.Message.Attributes["eventType"],
+			}).Debug("Object changed.")
+		}
+	}
+	// One invalidation covers the whole batch, an upload of many files only
+	// drops the cache once.
+	if changed {
+		s.Cache.Invalidate()
+	}
+	_, err = service.Projects.Subscriptions.Acknowledge(subscription, &pubsub.AcknowledgeRequest{
+		AckIds: ackIds,
+	}).Do()
+	return err
+}
+
+// RunNotifications keeps pulling bucket notifications, forever. While the
+// subscription can't be reached the cache falls back to -cache-ttl, and it is
+// invalidated once it can again, as notifications may have been missed.
+func (s *Server) RunNotifications(service *pubsub.Service, subscription string) {
+	backoff := time.Second
+	for {
+		err := s.pullNotifications(service, subscription)
+		if err == nil {
+			if !s.Cache.Notified() {
+				s.Cache.Invalidate()
+				s.Cache.SetNotified(true)
+			}
+			backoff = time.Second
+			continue
+		}
+		s.Cache.SetNotified(false)
+		log.WithFields(log.Fields{
+			"subscription":  subscription,
+			"retryIn":       backoff,
+			"internalError": err,
+		}).Warn("Failed pulling bucket notifications.")
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > pubsubMaxBackoff {
+			backoff = pubsubMaxBackoff
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"math/rand"
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// RandomHandler redirects to the play page of a random video below the
+// prefix query parameter, or straight to its URL with raw=1. The filters of
+// the index, like q or minSize, narrow the choice down as well.
+func (s *Server) RandomHandler(response http.ResponseWriter, request *http.Request) {
+	prefix := request.FormValue("prefix")
+	if prefix != "" && hasBadSegment(prefix) {
+		http.Error(response, "prefix must not contain empty, . or .. segments.", http.StatusBadRequest)
+		return
+	}
+	filter, err := s.ListFilter(request)
+	if err != nil {
+		http.Error(response, err.Error(), http.StatusBadRequest)
+		return
+	}
+	items, err := s.CachedObjects(request.Context(), prefix)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"prefix":        prefix,
+			"internalError": err,
+		}).Warn("Failed listing videos to pick a random one.")
+		http.Error(response, "Failed listing the videos.", http.StatusBadGateway)
+		return
+	}
+	videos := keep(FilterVideos(filter(items)), Available)
+	if len(videos) == 0 {
+		http.Error(response, "There are no videos to choose from.", http.StatusNotFound)
+		return
+	}
+	// Seeded per request, kiosks starting at the same time still see
+	// different videos.
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	video := videos[random.Intn(len(videos))]
+
+	// Every request picks anew.
+	response.Header().Set("Cache-Control", "no-store")
+	if request.FormValue("raw") == "" {
+		http.Redirect(response, request, Link("/play/", s.Aliases.PlayPath(video.Name)), http.StatusFound)
+		return
+	}
+	// Stream segments need signing too, streams go through the playlist
+	// proxy.
+	target := HLSPath(video.Name)
+	if !IsStream(video.Name) {
+		target = s.SignObject(video)
+	}
+	if target == "" {
+		http.Error(response, "Could not sign URL.", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(response, request, target, http.StatusFound)
+}
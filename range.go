@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var errUnsatisfiableRange = errors.New("range not satisfiable")
+
+// ByteRange is an inclusive range of byte offsets.
+type ByteRange struct {
+	Start int64
+	End   int64
+}
+
+// Header returns the range as the value of a Range request header.
+func (r ByteRange) Header() string {
+	return fmt.Sprintf("bytes=%d-%d", r.Start, r.End)
+}
+
+// ParseRange parses a Range header for an object of size bytes and clamps it
+// to the object. Only the first of several ranges is served. It returns
+// false when the whole object should be served, for requests without a
+// Range header, with a unit other than bytes or with a syntactically invalid
+// range, which RFC 7233 says to ignore, and errUnsatisfiableRange for
+// ranges starting past the end.
+func ParseRange(header string, size int64) (ByteRange, bool, error) {
+	if header == "" {
+		return ByteRange{}, false, nil
+	}
+	const unit = "bytes="
+	if !strings.HasPrefix(header, unit) {
+		return ByteRange{}, false, nil
+	}
+	spec := strings.TrimSpace(strings.SplitN(strings.TrimPrefix(header, unit), ",", 2)[0])
+	dash := strings.Index(spec, "-")
+	if dash < 0 {
+		return ByteRange{}, false, nil
+	}
+	first, last := strings.TrimSpace(spec[:dash]), strings.TrimSpace(spec[dash+1:])
+	if first == "" {
+		// A suffix range of the last n bytes.
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return ByteRange{}, false, nil
+		}
+		if n == 0 || size <= 0 {
+			return ByteRange{}, false, errUnsatisfiableRange
+		}
+		if n > size {
+			n = size
+		}
+		return ByteRange{Start: size - n, End: size - 1}, true, nil
+	}
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return ByteRange{}, false, nil
+	}
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return ByteRange{}, false, nil
+		}
+	}
+	if start >= size {
+		return ByteRange{}, false, errUnsatisfiableRange
+	}
+	if end > size-1 {
+		end = size - 1
+	}
+	return ByteRange{Start: start, End: end}, true, nil
+}
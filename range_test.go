@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestParseRange(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		size   int64
+		want   ByteRange
+		ranged bool
+		err    error
+	}{
+		{"no header", "", 100, ByteRange{}, false, nil},
+		{"other unit", "items=0-10", 100, ByteRange{}, false, nil},
+		{"closed", "bytes=10-19", 100, ByteRange{Start: 10, End: 19}, true, nil},
+		{"single byte", "bytes=0-0", 100, ByteRange{Start: 0, End: 0}, true, nil},
+		{"open-ended", "bytes=90-", 100, ByteRange{Start: 90, End: 99}, true, nil},
+		{"suffix", "bytes=-10", 100, ByteRange{Start: 90, End: 99}, true, nil},
+		{"suffix longer than the object", "bytes=-500", 100, ByteRange{Start: 0, End: 99}, true, nil},
+		{"clamped end", "bytes=50-500", 100, ByteRange{Start: 50, End: 99}, true, nil},
+		{"spaces", "bytes= 10 - 19 ", 100, ByteRange{Start: 10, End: 19}, true, nil},
+		{"multi-range serves the first", "bytes=10-19, 50-59", 100, ByteRange{Start: 10, End: 19}, true, nil},
+		{"start at the end", "bytes=100-", 100, ByteRange{}, false, errUnsatisfiableRange},
+		{"start past the end", "bytes=500-600", 100, ByteRange{}, false, errUnsatisfiableRange},
+		{"empty suffix", "bytes=-0", 100, ByteRange{}, false, errUnsatisfiableRange},
+		{"empty object", "bytes=0-10", 0, ByteRange{}, false, errUnsatisfiableRange},
+		// Invalid ranges are ignored and the whole object served.
+		{"end before start", "bytes=500-100", 1000, ByteRange{}, false, nil},
+		{"no dash", "bytes=10", 100, ByteRange{}, false, nil},
+		{"not a number", "bytes=a-b", 100, ByteRange{}, false, nil},
+		{"no bounds", "bytes=-", 100, ByteRange{}, false, nil},
+	}
+	for _, test := range tests {
+		got, ranged, err := ParseRange(test.header, test.size)
+		if got != test.want || ranged != test.ranged || err != test.err {
+			t.Errorf("%s: ParseRange(%q, %d) = %v, %v, %v, want %v, %v, %v",
+				test.name, test.header, test.size, got, ranged, err, test.want, test.ranged, test.err)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var signingCheckInterval = flag.Duration("signing-check-interval", 5*time.Minute, "How often signing is self-tested for /readyz, 0 only tests at startup.")
+
+// signingCheckName is signed by the self-test, it doesn't have to exist.
+const signingCheckName = "filebrowser-signing-check"
+
+// SigningHealth is the result of the last signing self-test.
+type SigningHealth struct {
+	mutex   sync.Mutex
+	err     error
+	checked time.Time
+}
+
+func (h *SigningHealth) get() (time.Time, error) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.checked, h.err
+}
+
+// set records a result and returns the previous error.
+func (h *SigningHealth) set(err error) error {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	previous := h.err
+	h.err, h.checked = err, time.Now()
+	return previous
+}
+
+// checkSignedUrl makes sure signed looks like a usable signed URL. It
+// doesn't prove Cloud Storage accepts the key, only that signing produced a
+// URL.
+func checkSignedUrl(signed string) error {
+	if signed == "" {
+		return errors.New("signing returned no URL")
+	}
+	parsed, err := url.Parse(signed)
+	if err != nil {
+		return err
+	}
+	if *proxyOnly || IPRestricted() {
+		return nil
+	}
+	if !parsed.IsAbs() || parsed.Host == "" {
+		return errors.New("signed URL is not absolute")
+	}
+	if *publicBucket {
+		return nil
+	}
+	query := parsed.Query()
+	if query.Get("X-Goog-Signature") == "" && query.Get("Signature") == "" {
+		return errors.New("signed URL has no signature")
+	}
+	return nil
+}
+
+// CheckSigning signs a dummy object name, records whether that worked and
+// logs when the result changes.
+func (s *Server) CheckSigning() error {
+	err := checkSignedUrl(s.signUrl(signingCheckName, nil, time.Minute))
+	previous := s.Signing.set(err)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"internalError": err,
+		}).Error("Signing self-test failed, links to objects are broken.")
+		return err
+	}
+	if previous != nil {
+		log.Info("Signing self-test passed again.")
+	}
+	return nil
+}
+
+// RunSigningCheck repeats the signing self-test every interval, forever.
+func (s *Server) RunSigningCheck(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		s.CheckSigning()
+	}
+}
+
+type readiness struct {
+	Signing        string    `json:"signing"`
+	Error          string    `json:"error,omitempty"`
+	SigningChecked time.Time `json:"signingChecked"`
+	// Cache is only reported with -warm-before-ready.
+	Cache string `json:"cache,omitempty"`
+}
+
+// MetricsHandler exposes the health of the server in the Prometheus text
+// format.
+func (s *Server) MetricsHandler(response http.ResponseWriter, request *http.Request) {
+	healthy := 1
+	if _, err := s.Signing.get(); err != nil {
+		healthy = 0
+	}
+	response.Header().Set("Content-type", "text/plain; version=0.0.4")
+	fmt.Fprintln(response, "# HELP filebrowser_signing_healthy Whether the last signing self-test passed.")
+	fmt.Fprintln(response, "# TYPE filebrowser_signing_healthy gauge")
+	fmt.Fprintf(response, "filebrowser_signing_healthy %d\n", healthy)
+}
+
+// ReadyzHandler reports 503 while the signing subsystem is degraded, and
+// with -warm-before-ready until the cache is warm.
+func (s *Server) ReadyzHandler(response http.ResponseWriter, request *http.Request) {
+	checked, err := s.Signing.get()
+	response.Header().Set("Cache-Control", "no-store")
+	ready := readiness{Signing: "ok", SigningChecked: checked}
+	status := http.StatusOK
+	if err != nil {
+		ready.Signing, ready.Error = "degraded", err.Error()
+		status = http.StatusServiceUnavailable
+	}
+	if *warmBeforeReady {
+		ready.Cache = "warm"
+		if s.Warmth.Warming() {
+			ready.Cache = "warming"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	writeJSON(response, status, ready)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const (
+	recentCookieName = "recent"
+	maxRecent        = 10
+	// Browsers reject cookies over 4096 bytes including name and attributes.
+	maxRecentCookieSize = 3800
+)
+
+// RecentlyPlayed returns the object names stored in the recently played
+// cookie, most recent first. Missing or tampered cookies yield nothing.
+func (s *Server) RecentlyPlayed(request *http.Request) []string {
+	cookie, err := request.Cookie(recentCookieName)
+	if err != nil {
+		return nil
+	}
+	payload, ok := s.VerifyValue(cookie.Value)
+	if !ok {
+		return nil
+	}
+	var names []string
+	if err := json.Unmarshal(payload, &names); err != nil {
+		return nil
+	}
+	return names
+}
+
+// RememberPlayed moves objectName to the front of the recently played cookie,
+// dropping the oldest entries when the list gets too long for the cookie.
+func (s *Server) RememberPlayed(response http.ResponseWriter, request *http.Request, objectName string) {
+	names := []string{objectName}
+	for _, name := range s.RecentlyPlayed(request) {
+		if name != objectName && len(names) < maxRecent {
+			names = append(names, name)
+		}
+	}
+
+	var value string
+	for ; len(names) > 0; names = names[:len(names)-1] {
+		payload, err := json.Marshal(names)
+		if err != nil {
+			return
+		}
+		value = s.SignValue(payload)
+		if len(value) <= maxRecentCookieSize {
+			break
+		}
+	}
+	if len(names) == 0 {
+		return
+	}
+
+	http.SetCookie(response, &http.Cookie{
+		Name:     recentCookieName,
+		Value:    value,
+		Path:     "/",
+		Expires:  time.Now().Add(30 * 24 * time.Hour),
+		HttpOnly: true,
+	})
+}
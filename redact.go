@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// redactedParams matches the query parameters of signed URLs that let anyone
+// holding the URL download the object, also within error messages quoting
+// the URL.
+var redactedParams = regexp.MustCompile(`(?i)([?&](?:signature|x-goog-signature|expires|x-goog-expires)=)[^&\s"'<>]*`)
+
+// redactURL blanks the signature and expiry of every signed URL in text.
+func redactURL(text string) string {
+	return redactedParams.ReplaceAllString(text, "${1}REDACTED")
+}
+
+// redactHook runs every log entry through redactURL, so that no logging path
+// writes a usable signed URL.
+type redactHook struct{}
+
+func (redactHook) Levels() []log.Level {
+	return []log.Level{log.PanicLevel, log.FatalLevel, log.ErrorLevel, log.WarnLevel, log.InfoLevel, log.DebugLevel}
+}
+
+func (redactHook) Fire(entry *log.Entry) error {
+	entry.Message = redactURL(entry.Message)
+	for key, value := range entry.Data {
+		switch value := value.(type) {
+		case string:
+			entry.Data[key] = redactURL(value)
+		case error:
+			if redacted := redactURL(value.Error()); redacted != value.Error() {
+				entry.Data[key] = redacted
+			}
+		case fmt.Stringer:
+			if redacted := redactURL(value.String()); redacted != value.String() {
+				entry.Data[key] = redacted
+			}
+		}
+	}
+	return nil
+}
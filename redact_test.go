@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+func TestRedactHookHidesSignedURLs(t *testing.T) {
+	var output bytes.Buffer
+	logger := log.StandardLogger()
+	hooks := logger.ReplaceHooks(make(log.LevelHooks))
+	log.AddHook(redactHook{})
+	log.SetOutput(&output)
+	t.Cleanup(func() {
+		logger.ReplaceHooks(hooks)
+		log.SetOutput(os.Stderr)
+	})
+
+	urls := map[string]string{
+		"v2": "https://storage.googleapis.com/bucket/clip.mp4?GoogleAccessId=account%40project.iam.gserviceaccount.com&Expires=1577836800&Signature=c2lnbmF0dXJl%2B%2F%3D&response-content-type=video%2Fmp4",
+		"v4": "https://storage.googleapis.com/bucket/clip.mp4?X-Goog-Algorithm=GOOG4-RSA-SHA256&X-Goog-Credential=account%40project.iam.gserviceaccount.com%2F20200101%2Fauto%2Fstorage%2Fgoog4_request&X-Goog-Date=20200101T000000Z&X-Goog-Expires=3600&X-Goog-SignedHeaders=host&X-Goog-Signature=0123456789abcdef",
+	}
+	secrets := []string{"1577836800", "c2lnbmF0dXJl", "0123456789abcdef", "X-Goog-Expires=3600"}
+	for version, signedUrl := range urls {
+		log.WithFields(log.Fields{
+			"url":           signedUrl,
+			"internalError": fmt.Errorf("Get %q: connection reset", signedUrl),
+		}).Warn("Failed fetching " + signedUrl)
+		written := output.String()
+		output.Reset()
+		for _, secret := range secrets {
+			if strings.Contains(written, secret) {
+				t.Errorf("%s: log entry %q contains %s", version, written, secret)
+			}
+		}
+		for _, param := range []string{"Signature=", "Expires="} {
+			if version == "v4" {
+				param = "X-Goog-" + param
+			}
+			// Once in the message, once in each field.
+			if count := strings.Count(written, param+"REDACTED"); count != 3 {
+				t.Errorf("%s: log entry %q has %d redacted %s, want 3", version, written, count, param)
+			}
+		}
+		if !strings.Contains(written, "storage.googleapis.com/bucket/clip.mp4") {
+			t.Errorf("%s: log entry %q lost the object the URL is for", version, written)
+		}
+	}
+}
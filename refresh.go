@@ -0,0 +1,118 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+	storage "google.golang.org/api/storage/v1"
+)
+
+var autoRefreshSeconds = flag.Int("auto-refresh-seconds", 0, "Reload open index pages when the listing changes, checking every this many seconds. Changes show up within -cache-ttl plus this. 0 disables it.")
+
+// ListingETag identifies a listing, it changes whenever an object is added,
+// replaced or removed.
+func ListingETag(items []*storage.Object) string {
+	hash := sha256.New()
+	for _, item := range items {
+		fmt.Fprintf(hash, "%s\x00%d\x00%s\n", item.Name, item.Generation, item.Updated)
+	}
+	return `"` + hex.EncodeToString(hash.Sum(nil)[:8]) + `"`
+}
+
+// CachedETag returns the ETag of the cached listing of prefix.
+func (s *Server) CachedETag(ctx context.Context, prefix string) (string, error) {
+	items, err := s.CachedObjects(ctx, prefix)
+	if err != nil {
+		return "", err
+	}
+	return ListingETag(items), nil
+}
+
+// SetRefresh makes page reload when the listing below prefix changes, if
+// -auto-refresh-seconds is set.
+func (s *Server) SetRefresh(request *http.Request, page *IndexPage, prefix string) {
+	if *autoRefreshSeconds <= 0 {
+		return
+	}
+	etag, err := s.CachedETag(request.Context(), prefix)
+	if err != nil {
+		// The page just doesn't reload then.
+		return
+	}
+	page.ListingETag, page.RefreshPrefix = etag, prefix
+}
+
+// ChangesHandler sends a "change" server-sent event with the new ETag when
+// the listing below the prefix query parameter no longer matches the etag
+// query parameter, which is the ETag the page was rendered with. Without it
+// the listing at connect time is the baseline. Comments keep the connection
+// open between checks.
+func (s *Server) ChangesHandler(response http.ResponseWriter, request *http.Request) {
+	if *autoRefreshSeconds <= 0 {
+		http.NotFound(response, request)
+		return
+	}
+	flusher, ok := response.(http.Flusher)
+	if !ok {
+		writeJSONError(response, http.StatusInternalServerError, "Streaming is not supported.")
+		return
+	}
+	ctx := request.Context()
+	prefix := request.FormValue("prefix")
+	seen := request.FormValue("etag")
+	if seen == "" {
+		etag, err := s.CachedETag(ctx, prefix)
+		if err != nil {
+			writeJSONError(response, http.StatusBadGateway, err.Error())
+			return
+		}
+		seen = etag
+	}
+
+	response.Header().Set("Content-type", "text/event-stream")
+	response.Header().Set("Cache-Control", "no-cache")
+	// Keep proxies like nginx from buffering the stream.
+	response.Header().Set("X-Accel-Buffering", "no")
+	// Browsers reconnect after this many milliseconds when the connection
+	// drops.
+	fmt.Fprintf(response, "retry: %d\n\n", *autoRefreshSeconds*1000)
+	flusher.Flush()
+
+	ticker := time.NewTicker(time.Duration(*autoRefreshSeconds) * time.Second)
+	defer ticker.Stop()
+	for {
+		etag, err := s.CachedETag(ctx, prefix)
+		if ctx.Err() != nil {
+			return
+		}
+		switch {
+		case err != nil:
+			log.WithFields(log.Fields{
+				"prefix":        prefix,
+				"internalError": err,
+			}).Warn("Failed checking the listing for changes.")
+		case etag != seen:
+			seen = etag
+			if writeEvent(response, flusher, "change", etag) != nil {
+				return
+			}
+		default:
+			// A comment, so that proxies don't drop the idle connection.
+			if _, err := fmt.Fprint(response, ": unchanged\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
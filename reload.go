@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The -blocked-names, -aliases and -featured files are read at startup and
+// re-read by admins through their /admin/reload- routes. They only change
+// what is served and how, not the bucket, so reloads apply in dry-run mode
+// too.
+
+// loadLocked reads filename with read and hands the result to store while
+// holding mutex, so that readers see the old or the new entries but never a
+// mix. The old entries stay in place when the file can't be read. store
+// returns the number of entries.
+func loadLocked[T any](mutex *sync.RWMutex, filename string, read func(string) (T, error), store func(T) int) (int, error) {
+	entries, err := read(filename)
+	if err != nil {
+		return 0, err
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	return store(entries), nil
+}
+
+// fileReload describes the reload of one of the files.
+type fileReload struct {
+	// name names the /admin/reload- route and the audit entry.
+	name string
+	// flag is the flag filename is given with.
+	flag     string
+	filename string
+	// what names the entries of the file in log messages.
+	what string
+	// countKey is the JSON field of the number of entries read.
+	countKey string
+	load     func(filename string) (int, error)
+}
+
+// reloadFile re-reads the file of reload and answers with the number of
+// entries it has.
+func reloadFile(response http.ResponseWriter, request *http.Request, reload fileReload) {
+	if !IsAdmin(request) {
+		writeJSONError(response, http.StatusForbidden, "only admins can reload "+reload.what)
+		return
+	}
+	Audit(request, "reload-"+reload.name, log.Fields{reload.flag: reload.filename})
+	if reload.filename == "" {
+		writeJSONError(response, http.StatusBadRequest, "no -"+reload.flag+" file configured")
+		return
+	}
+	count, err := reload.load(reload.filename)
+	if err != nil {
+		log.WithFields(log.Fields{
+			reload.flag:     reload.filename,
+			"internalError": err,
+		}).Warn("Failed reloading " + reload.what + ", keeping the old ones.")
+		writeJSONError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	log.WithFields(log.Fields{
+		reload.countKey: count,
+	}).Info("Reloaded " + reload.what + ".")
+	writeJSON(response, http.StatusOK, map[string]int{reload.countKey: count})
+}
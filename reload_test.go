@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReloadFiles(t *testing.T) {
+	s := &Server{Users: map[string]string{"alice": "secret", "bob": "secret"}}
+	setFlag(t, adminUsers, "alice")
+	filename := filepath.Join(t.TempDir(), "blocked")
+	setFlag(t, blockedNames, filename)
+	handler := s.Handler(s.NewRouter())
+	reload := func(user string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest("POST", "/admin/reload-blocked", nil)
+		request.SetBasicAuth(user, "secret")
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response
+	}
+
+	if err := ioutil.WriteFile(filename, []byte("secret.mp4\n*.tmp\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if response := reload("bob"); response.Code != http.StatusForbidden {
+		t.Errorf("non-admin: status %d, want %d", response.Code, http.StatusForbidden)
+	}
+	if s.Blocked.Blocked("secret.mp4") {
+		t.Errorf("non-admin reloaded the blocked names")
+	}
+
+	response := reload("alice")
+	if response.Code != http.StatusOK || response.Body.String() != "{\"patterns\":2}\n" {
+		t.Errorf("reload: %d %q, want %d {\"patterns\":2}", response.Code, response.Body, http.StatusOK)
+	}
+	if !s.Blocked.Blocked("secret.mp4") || !s.Blocked.Blocked("a/b.tmp") {
+		t.Errorf("reload didn't swap in the new blocked names")
+	}
+
+	os.Remove(filename)
+	if response := reload("alice"); response.Code != http.StatusBadRequest {
+		t.Errorf("missing file: status %d, want %d", response.Code, http.StatusBadRequest)
+	}
+	if !s.Blocked.Blocked("secret.mp4") {
+		t.Errorf("failed reload dropped the old blocked names")
+	}
+
+	setFlag(t, blockedNames, "")
+	if response := reload("alice"); response.Code != http.StatusBadRequest {
+		t.Errorf("no file configured: status %d, want %d", response.Code, http.StatusBadRequest)
+	}
+}
+
+func TestReloadCredsNeedsAdmin(t *testing.T) {
+	s := &Server{Users: map[string]string{"alice": "secret", "bob": "secret"}}
+	setFlag(t, adminUsers, "alice")
+	handler := s.Handler(s.NewRouter())
+	request := httptest.NewRequest("POST", "/admin/reload-creds", nil)
+	request.SetBasicAuth("bob", "secret")
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	if response.Code != http.StatusForbidden {
+		t.Errorf("non-admin: status %d, want %d", response.Code, http.StatusForbidden)
+	}
+	if s.Credentials() != nil {
+		t.Errorf("non-admin swapped the credentials")
+	}
+}
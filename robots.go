@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	robotsFile    = flag.String("robots-file", "", "File served as /robots.txt instead of the default.")
+	allowIndexing = flag.Bool("allow-indexing", false, "Let search engines index the site. By default robots are disallowed and pages carry X-Robots-Tag: noindex.")
+)
+
+const (
+	disallowRobots = "User-agent: *\nDisallow: /\n"
+	allowRobots    = "User-agent: *\nDisallow:\n"
+)
+
+// NoIndex keeps search engines from indexing a response, which would leak
+// expiring signed URLs into their results.
+func NoIndex(response http.ResponseWriter) {
+	if !*allowIndexing {
+		response.Header().Set("X-Robots-Tag", "noindex, nofollow")
+	}
+}
+
+func RobotsHandler(response http.ResponseWriter, request *http.Request) {
+	response.Header().Set("Content-type", "text/plain; charset=utf-8")
+	if *robotsFile != "" {
+		content, err := ioutil.ReadFile(*robotsFile)
+		if err == nil {
+			response.Write(content)
+			return
+		}
+		log.WithFields(log.Fields{
+			"robotsFile":    *robotsFile,
+			"internalError": err,
+		}).Warn("Failed reading robots file, serving default.")
+	}
+	if *allowIndexing {
+		response.Write([]byte(allowRobots))
+	} else {
+		response.Write([]byte(disallowRobots))
+	}
+}
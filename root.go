@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"strings"
+
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/net/context"
+	storage "google.golang.org/api/storage/v1"
+)
+
+var rootPrefix = flag.String("root-prefix", "", "Folder of the bucket served as the root of this instance, e.g. tenant/. Objects outside of it are not accessible.")
+
+// ValidateRootPrefix checks -root-prefix and returns it as a folder name
+// ending with the delimiter.
+func ValidateRootPrefix(prefix string) (string, error) {
+	if prefix == "" {
+		return "", nil
+	}
+	if strings.HasPrefix(prefix, delimiter) {
+		return "", errors.New("root prefix must not start with a slash")
+	}
+	if !strings.HasSuffix(prefix, delimiter) {
+		prefix += delimiter
+	}
+	if hasBadSegment(prefix) {
+		return "", errors.New("root prefix must not contain empty, . or .. segments")
+	}
+	return prefix, nil
+}
+
+// hasBadSegment reports whether a folder name ending with the delimiter has
+// empty, . or .. segments.
+func hasBadSegment(prefix string) bool {
+	for _, segment := range strings.Split(strings.TrimSuffix(prefix, delimiter), delimiter) {
+		if segment == "" || segment == "." || segment == ".." {
+			return true
+		}
+	}
+	return false
+}
+
+// StorageName returns the name in the bucket of the object the instance
+// knows as objectName. Names are joined as plain strings, as the bucket has
+// no notion of parent folders, so there is no name that leaves the root.
+func StorageName(objectName string) string {
+	return *rootPrefix + objectName
+}
+
+// VirtualName returns the name shown for an object stored as storageName,
+// and false for objects outside of the root.
+func VirtualName(storageName string) (string, bool) {
+	if !strings.HasPrefix(storageName, *rootPrefix) || storageName == *rootPrefix {
+		return "", false
+	}
+	return strings.TrimPrefix(storageName, *rootPrefix), true
+}
+
+// VirtualObjects renames freshly fetched objects to their virtual names in
+// place, dropping the ones outside of the root.
+func VirtualObjects(items []*storage.Object) []*storage.Object {
+	if *rootPrefix == "" {
+		return items
+	}
+	kept := items[:0]
+	for _, item := range items {
+		if name, ok := VirtualName(item.Name); ok {
+			item.Name = name
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
+
+// GetObject fetches the metadata of objectName below the root.
+func (s *Server) GetObject(ctx context.Context, objectName string) (*storage.Object, error) {
+	return s.GetGeneration(ctx, objectName, 0)
+}
+
+// GetGeneration fetches the metadata of a generation of objectName, which
+// may have been replaced or deleted in a versioned bucket. Generation 0 is the
+// live object.
+func (s *Server) GetGeneration(ctx context.Context, objectName string, generation int64) (*storage.Object, error) {
+	ctx, span := StartSpan(ctx, "storage.get", attribute.String("object", objectName), attribute.Int64("generation", generation))
+	call := s.Storage().Objects.Get(bucketName, StorageName(objectName)).Context(detach(ctx))
+	if generation != 0 {
+		call.Generation(generation)
+	}
+	object, err := call.Do()
+	EndSpan(span, err)
+	if err != nil {
+		return nil, RequesterPaysHint(err)
+	}
+	object.Name = objectName
+	return object, nil
+}
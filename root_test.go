@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+func TestStorageAndVirtualNames(t *testing.T) {
+	setFlag(t, rootPrefix, "tenant/")
+	tests := []struct {
+		objectName  string
+		storageName string
+	}{
+		{"clip.mp4", "tenant/clip.mp4"},
+		{"a/b/c.mp4", "tenant/a/b/c.mp4"},
+		{"a/b/c d&e.mp4", "tenant/a/b/c d&e.mp4"},
+	}
+	for _, test := range tests {
+		if got := StorageName(test.objectName); got != test.storageName {
+			t.Errorf("StorageName(%q) = %q, want %q", test.objectName, got, test.storageName)
+		}
+		if got, ok := VirtualName(test.storageName); !ok || got != test.objectName {
+			t.Errorf("VirtualName(%q) = %q, %v, want %q", test.storageName, got, ok, test.objectName)
+		}
+	}
+	for _, outside := range []string{"tenant/", "other/a/b/c.mp4", "tenantx/c.mp4"} {
+		if got, ok := VirtualName(outside); ok {
+			t.Errorf("VirtualName(%q) = %q, want it outside of the root", outside, got)
+		}
+	}
+}
+
+// TestNestedObjectNames requests a/b/c.mp4 through the router below a
+// -root-prefix and checks the handlers fetch and sign the nested name.
+func TestNestedObjectNames(t *testing.T) {
+	setFlag(t, rootPrefix, "tenant/")
+	setFlag(t, signingVersion, "v4")
+	setFlag(t, verifyObjects, true)
+	key, err := ParsePrivateKey([]byte(conformanceKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bucket := &fakeBucket{}
+	bucket.add("tenant/a/b/c.mp4", "video", storage.Object{ContentType: "video/mp4"})
+	s := newTestServer(t, bucket)
+	s.Credentials().SigningKey = key
+	router := s.NewRouter()
+	signedPrefix := "https://" + storageHost + "/" + bucketName + "/tenant/a/b/c.mp4?"
+
+	request := httptest.NewRequest("GET", "/raw/a/b/c.mp4", nil)
+	response := httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusFound {
+		t.Fatalf("raw: status %d, want %d: %s", response.Code, http.StatusFound, response.Body)
+	}
+	if location := response.Header().Get("Location"); !strings.HasPrefix(location, signedPrefix) {
+		t.Errorf("raw: redirected to %s, want a URL starting with %s", location, signedPrefix)
+	}
+
+	request = httptest.NewRequest("GET", "/play/a/b/c.mp4", nil)
+	request.Header.Set("Accept", "application/json")
+	response = httptest.NewRecorder()
+	router.ServeHTTP(response, request)
+	if response.Code != http.StatusOK {
+		t.Fatalf("play: status %d, want %d: %s", response.Code, http.StatusOK, response.Body)
+	}
+	var metadata VideoMetadata
+	if err := json.NewDecoder(response.Body).Decode(&metadata); err != nil {
+		t.Fatal(err)
+	}
+	if metadata.Name != "a/b/c.mp4" {
+		t.Errorf("play: name %q, want a/b/c.mp4", metadata.Name)
+	}
+	if !strings.HasPrefix(metadata.Url, signedPrefix) {
+		t.Errorf("play: URL %s, want one starting with %s", metadata.Url, signedPrefix)
+	}
+
+	for _, missing := range []string{"/raw/a/b/other.mp4", "/play/a/c.mp4", "/raw/b/c.mp4"} {
+		response := httptest.NewRecorder()
+		router.ServeHTTP(response, httptest.NewRequest("GET", missing, nil))
+		if response.Code != http.StatusNotFound {
+			t.Errorf("%s: status %d, want %d", missing, response.Code, http.StatusNotFound)
+		}
+	}
+}
+
+func TestObjectPath(t *testing.T) {
+	tests := []struct {
+		objectName string
+		want       string
+	}{
+		{"a/b/c.mp4", "a/b/c.mp4"},
+		{"a b/c#d?.mp4", "a%20b/c%23d%3F.mp4"},
+		{"2023/100%.mp4", "2023/100%25.mp4"},
+	}
+	for _, test := range tests {
+		if got := string(ObjectPath(test.objectName)); got != test.want {
+			t.Errorf("ObjectPath(%q) = %q, want %q", test.objectName, got, test.want)
+		}
+	}
+}
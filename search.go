@@ -0,0 +1,174 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+	"golang.org/x/sync/errgroup"
+	storage "google.golang.org/api/storage/v1"
+)
+
+var (
+	searchBuckets       = flag.String("search-buckets", "", "Comma separated buckets /search looks through besides the served one. Their listings are cached like the served bucket's, -root-prefix and -blocked-names only apply to the served bucket.")
+	searchBucketTimeout = flag.Duration("search-bucket-timeout", 10*time.Second, "How long /search waits for each bucket, slower buckets are left out of the results.")
+)
+
+// SearchPage is a search across the buckets. The Bucket of every item is
+// set to the bucket it was found in.
+type SearchPage struct {
+	Query      string
+	Items      []*storage.Object
+	Pagination Pagination
+	URL        string
+	// Failed are the buckets that couldn't be searched in time.
+	Failed []string
+	// Bucket is the served bucket, its items link to their play page.
+	Bucket string
+}
+
+// SearchBuckets returns the served bucket followed by -search-buckets.
+func SearchBuckets() []string {
+	buckets := []string{bucketName}
+	for _, bucket := range strings.Split(*searchBuckets, ",") {
+		if bucket = strings.TrimSpace(bucket); bucket != "" && bucket != bucketName {
+			buckets = append(buckets, bucket)
+		}
+	}
+	return buckets
+}
+
+// listBucket lists all objects of another bucket, from s.BucketListings
+// while it is fresh.
+func (s *Server) listBucket(ctx context.Context, bucket string) ([]*storage.Object, error) {
+	if *cacheTTL > 0 {
+		if items, ok := s.BucketListings.get(bucket); ok {
+			return items, nil
+		}
+	}
+	var items []*storage.Object
+	call := s.Storage().Objects.List(bucket).Context(ctx)
+	if !*fullMetadata {
+		call.Fields(listingFields)
+	}
+	for {
+		res, err := call.Do()
+		if err != nil {
+			return nil, RequesterPaysHint(err)
+		}
+		items = append(items, HidePlaceholders(res.Items)...)
+		if res.NextPageToken == "" {
+			break
+		}
+		call.PageToken(res.NextPageToken)
+	}
+	if *cacheTTL > 0 {
+		s.BucketListings.put(bucket, items)
+	}
+	return items, nil
+}
+
+// searchServed is SearchObjects on the served bucket, giving up once ctx is
+// done. Its listing is detached to fill the cache for everyone, so it goes on
+// in the background and the next search finds it cached.
+func (s *Server) searchServed(ctx context.Context, query string) ([]*storage.Object, error) {
+	type result struct {
+		items []*storage.Object
+		err   error
+	}
+	done := make(chan result, 1)
+	go func() {
+		items, err := s.SearchObjects(ctx, "", query)
+		done <- result{items, err}
+	}()
+	select {
+	case found := <-done:
+		return found.items, found.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// searchBucket returns copies of the objects of bucket whose name contains
+// query, with Bucket set.
+func (s *Server) searchBucket(ctx context.Context, bucket string, query string) ([]*storage.Object, error) {
+	var items []*storage.Object
+	var err error
+	if bucket == bucketName {
+		items, err = s.searchServed(ctx, query)
+	} else {
+		items, err = s.listBucket(ctx, bucket)
+		items = FilterByName(items, query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The listings are shared with other requests.
+	found := make([]*storage.Object, len(items))
+	for i, item := range items {
+		annotated := *item
+		annotated.Bucket = bucket
+		found[i] = &annotated
+	}
+	return found, nil
+}
+
+// SearchAll searches all SearchBuckets concurrently, each for at most
+// -search-bucket-timeout. The merged result is sorted by ByUpdated, the
+// buckets that failed are returned along with it.
+func (s *Server) SearchAll(ctx context.Context, query string) ([]*storage.Object, []string) {
+	var mutex sync.Mutex
+	var items []*storage.Object
+	var failed []string
+	// The goroutines never return an error, a failing bucket mustn't cancel
+	// the others.
+	var group errgroup.Group
+	for _, bucket := range SearchBuckets() {
+		bucket := bucket
+		group.Go(func() error {
+			bucketCtx, cancel := context.WithTimeout(ctx, *searchBucketTimeout)
+			defer cancel()
+			found, err := s.searchBucket(bucketCtx, bucket, query)
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				log.WithFields(log.Fields{
+					"bucket":        bucket,
+					"internalError": err,
+				}).Warn("Failed searching bucket.")
+				failed = append(failed, bucket)
+				return nil
+			}
+			items = append(items, found...)
+			return nil
+		})
+	}
+	group.Wait()
+	SortByUpdated(items)
+	sort.Strings(failed)
+	return items, failed
+}
+
+// SearchHandler searches the q query parameter across all SearchBuckets.
+func (s *Server) SearchHandler(response http.ResponseWriter, request *http.Request) {
+	page := SearchPage{
+		Query:  request.FormValue("q"),
+		URL:    Link(request.URL.RequestURI()),
+		Bucket: bucketName,
+	}
+	// Without a query there is nothing to look for, listing every bucket
+	// in full would be the slowest page there is.
+	if page.Query != "" {
+		var items []*storage.Object
+		items, page.Failed = s.SearchAll(request.Context(), page.Query)
+		page.Items, page.Pagination = Paginate(items, ParsePage(request), *pageSize)
+	}
+	NoIndex(response)
+	response.Header().Set("Content-type", "text/html")
+	s.Render(response, request, "search.html", page)
+}
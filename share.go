@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+)
+
+var shareExpiry = flag.Duration("share-expiry", 24*time.Hour, "How long /share links are valid unless their expires parameter says otherwise. Shared links are passed on to people without an account and often opened days later, longer ones save creating new links but anyone holding one can fetch the object until it expires.")
+
+var (
+	errInvalidToken = errors.New("invalid share token")
+	errExpiredToken = errors.New("expired share token")
+)
+
+// ShareToken returns a token granting access to objectName until expires.
+func (s *Server) ShareToken(objectName string, expires time.Time) string {
+	return s.SignValue([]byte(strconv.FormatInt(expires.Unix(), 10) + ":" + objectName))
+}
+
+// ParseShareToken validates a token created by ShareToken and returns the
+// object name it grants access to.
+func (s *Server) ParseShareToken(token string) (string, error) {
+	payload, ok := s.VerifyValue(token)
+	if !ok {
+		return "", errInvalidToken
+	}
+	fields := strings.SplitN(string(payload), ":", 2)
+	if len(fields) != 2 {
+		return "", errInvalidToken
+	}
+	expires, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return "", errInvalidToken
+	}
+	if time.Now().Unix() > expires {
+		return "", errExpiredToken
+	}
+	return fields[1], nil
+}
+
+func (s *Server) ShareHandler(response http.ResponseWriter, request *http.Request) {
+	objectName, err := s.ParseShareToken(mux.Vars(request)["token"])
+	if err != nil {
+		log.WithFields(log.Fields{
+			"remoteAddr":    request.RemoteAddr,
+			"internalError": err,
+		}).Info("Rejected share token.")
+		http.Error(response, err.Error(), http.StatusForbidden)
+		return
+	}
+	s.ProxyObject(response, request, objectName)
+}
+
+type ShareInfo struct {
+	Name      string    `json:"name"`
+	Url       string    `json:"url"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// requestOrigin returns the origin the client reached us at, unless
+// -external-url says otherwise.
+func requestOrigin(request *http.Request) string {
+	if *externalUrl != "" {
+		return *externalUrl
+	}
+	if request.TLS != nil {
+		return "https://" + request.Host
+	}
+	return "http://" + request.Host
+}
+
+// ShareLinkHandler creates a share link for an object. The optional expires
+// query parameter is a duration such as 90m or 72h.
+func (s *Server) ShareLinkHandler(response http.ResponseWriter, request *http.Request) {
+	objectName := mux.Vars(request)["objectName"]
+	expiry := *shareExpiry
+	if value := request.FormValue("expires"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			http.Error(response, "Invalid expires duration.", http.StatusBadRequest)
+			return
+		}
+		expiry = parsed
+	}
+
+	if _, err := s.GetObject(request.Context(), objectName); err != nil {
+		http.NotFound(response, request)
+		return
+	}
+
+	expires := time.Now().Add(expiry)
+	info := ShareInfo{
+		Name:      objectName,
+		Url:       requestOrigin(request) + "/s/" + s.ShareToken(objectName, expires),
+		ExpiresAt: expires.UTC(),
+	}
+	log.WithFields(log.Fields{
+		"objectName": objectName,
+		"user":       CurrentUser(request),
+		"expires":    info.ExpiresAt,
+		"audit":      true,
+	}).Info("Created share link.")
+
+	response.Header().Set("Content-type", "application/json")
+	json.NewEncoder(response).Encode(info)
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/net/context"
+	storage "google.golang.org/api/storage/v1"
+	cloud "google.golang.org/cloud/storage"
+)
+
+var (
+	signingVersion      = flag.String("signing-version", "v2", "Signed URL algorithm, v2 or v4.")
+	publicBucket        = flag.Bool("public-bucket", false, "The bucket is readable by everyone, link to objects directly instead of signing URLs. No PEM file is needed.")
+	cacheBustParam      = flag.String("cache-bust-param", "", "Query parameter set to the object generation in object URLs, e.g. v, so that a CDN caching them fetches overwritten objects again. generation makes GCS serve exactly that generation.")
+	inlineExpiry        = flag.Duration("inline-expiry", signedUrlExpiry, "How long the media URLs the play page plays inline are valid, including stream segments, subtitles and the next video of autoplay. Players keep fetching ranges of them while the page is open, so they must outlast the longest viewing, but a URL copied out of the page works for as long. V4 URLs are capped at seven days.")
+	downloadExpiryHours = flag.Int("download-expiry-hours", 24, "How many hours the Download link of the play page is valid, so that pages left open still download. V4 URLs are capped at seven days.")
+)
+
+const (
+	signedUrlExpiry = 6 * time.Hour
+	storageHost     = "storage.googleapis.com"
+	// V4 signed URLs can't be valid for longer than seven days.
+	maxV4Expiry = 7 * 24 * time.Hour
+)
+
+// ValidateCacheBustParam makes sure -cache-bust-param doesn't clash with the
+// parameters of signed URLs.
+func ValidateCacheBustParam(name string) error {
+	lower := strings.ToLower(name)
+	switch {
+	case name == "":
+		return nil
+	case lower == "expires" || lower == "googleaccessid" || lower == "signature" || lower == "userproject" || lower == "download",
+		strings.HasPrefix(lower, "x-goog-"), strings.HasPrefix(lower, "response-"):
+		return fmt.Errorf("cache bust parameter %q is already used in object URLs", name)
+	}
+	return nil
+}
+
+func (s *Server) SignUrl(objectName string) string {
+	return s.SignUrlFor(objectName, signedUrlExpiry)
+}
+
+// SignUrlFor is SignUrl with a URL valid for expiry.
+func (s *Server) SignUrlFor(objectName string, expiry time.Duration) string {
+	var params url.Values
+	if contentType, ok := OverrideType(objectName); ok {
+		// Overrides don't depend on the metadata.
+		params = url.Values{"response-content-type": {contentType}}
+	}
+	return s.signUrl(objectName, params, expiry)
+}
+
+// SignUrlContext is SignUrl in a span of ctx, for handlers answering with
+// the signed URL.
+func (s *Server) SignUrlContext(ctx context.Context, objectName string) string {
+	_, span := StartSpan(ctx, "sign", attribute.String("object", objectName))
+	signedUrl := s.SignUrl(objectName)
+	var err error
+	if signedUrl == "" {
+		err = errors.New("could not sign url")
+	}
+	EndSpan(span, err)
+	return signedUrl
+}
+
+// SignObject signs the URL of an object whose metadata is known, adding the
+// response overrides that depend on it. The URLs are plain GET URLs, so GCS
+// honours Range requests against them like for any other download, and the
+// overrides apply to partial responses as well.
+func (s *Server) SignObject(object *storage.Object) string {
+	return s.SignObjectWith(object, nil)
+}
+
+// SignObjectFor is SignObjectWith with a URL valid for the given number of
+// hours instead of the default expiry, for links to objects on pages that
+// stay open longer, like downloads. Signing never changes shared state, so it
+// is safe to call concurrently with any expiry. V4 URLs are capped at seven
+// days.
+func (s *Server) SignObjectFor(object *storage.Object, hours int, extra url.Values) string {
+	if hours <= 0 {
+		log.WithFields(log.Fields{
+			"objectName": object.Name,
+			"hours":      hours,
+		}).Warn("Refusing to sign URL without a positive expiry.")
+		return ""
+	}
+	return s.signObject(object, extra, time.Duration(hours)*time.Hour)
+}
+
+// SignObjectWith is SignObject with additional query parameters, such as
+// response-content-disposition.
+func (s *Server) SignObjectWith(object *storage.Object, extra url.Values) string {
+	return s.signObject(object, extra, signedUrlExpiry)
+}
+
+func (s *Server) signObject(object *storage.Object, extra url.Values, expiry time.Duration) string {
+	params := url.Values{}
+	if *signCacheControl && object.CacheControl == "" && *defaultCacheControl != "" {
+		params.Set("response-cache-control", *defaultCacheControl)
+	}
+	_, overridden := OverrideType(object.Name)
+	if *signContentType || overridden {
+		if contentType := ContentType(object); contentType != "" && contentType != object.ContentType {
+			params.Set("response-content-type", contentType)
+		}
+	}
+	if *cacheBustParam != "" && object.Generation != 0 {
+		// Part of the signed query for V4 and appended after signing for V2,
+		// either way the signature stays valid.
+		params.Set(*cacheBustParam, strconv.FormatInt(object.Generation, 10))
+	}
+	for key, values := range extra {
+		params[key] = values
+	}
+	return s.signUrl(object.Name, params, expiry)
+}
+
+// signUrl signs objectName with the configured algorithm, including the
+// given query parameters.
+func (s *Server) signUrl(objectName string, params url.Values, expiry time.Duration) string {
+	if s.Blocked.Blocked(objectName) {
+		log.WithFields(log.Fields{
+			"objectName": objectName,
+		}).Warn("Refusing to sign blocked object.")
+		return ""
+	}
+	if *proxyOnly {
+		return DownloadPath(objectName, params)
+	}
+	if IPRestricted() {
+		return s.RestrictedPath(objectName, params, expiry)
+	}
+	if *userProject != "" {
+		// The browser's download is billed to the project as well.
+		billed := url.Values{"userProject": {*userProject}}
+		for key, values := range params {
+			billed[key] = values
+		}
+		params = billed
+	}
+	if *publicBucket {
+		return PublicUrl(objectName, params)
+	}
+	var getURL string
+	var err error
+	if *signingVersion == "v4" {
+		getURL, err = SignV4(s.Credentials().SigningKey, *googleAccessId, bucketName, StorageName(objectName), params, time.Now(), expiry)
+	} else {
+		getURL, err = s.signV2(objectName, params, expiry)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"objectName":    objectName,
+			"internalError": err,
+		}).Warn("Error signing URL.")
+		return ""
+	}
+	return getURL
+}
+
+// signV2 signs objectName and appends the response override parameters.
+// V2 signatures do not cover the query string, so they can be added after
+// signing.
+func (s *Server) signV2(objectName string, params url.Values, expiry time.Duration) (string, error) {
+	// Copy the options so concurrent requests don't race on Expires.
+	options := *s.Credentials().SignedURLOptions
+	options.Expires = time.Now().Add(expiry)
+	getURL, err := cloud.SignedURL(bucketName, UrlEscape(StorageName(objectName)), &options)
+	if err != nil {
+		return "", err
+	}
+	if len(params) > 0 {
+		getURL += "&" + params.Encode()
+	}
+	return getURL, nil
+}
+
+// PublicUrl returns the unsigned URL of an object in a public bucket.
+func PublicUrl(objectName string, params url.Values) string {
+	publicURL := "https://" + storageHost + "/" + bucketName + "/" + v4EscapePath(StorageName(objectName))
+	if len(params) > 0 {
+		publicURL += "?" + params.Encode()
+	}
+	return publicURL
+}
+
+// CheckPublic warns when the first object of the bucket can't be fetched
+// without credentials, which means -public-bucket links won't work.
+func (s *Server) CheckPublic() {
+	res, err := s.ObjectsList().MaxResults(1).Prefix(*rootPrefix).Do()
+	if err != nil || len(res.Items) == 0 {
+		return
+	}
+	objectName, _ := VirtualName(res.Items[0].Name)
+	client := &http.Client{Timeout: 10 * time.Second}
+	check, err := client.Head(PublicUrl(objectName, nil))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"internalError": err,
+		}).Warn("Unable to check whether the bucket is public.")
+		return
+	}
+	check.Body.Close()
+	if check.StatusCode != http.StatusOK {
+		log.WithFields(log.Fields{
+			"objectName": objectName,
+			"status":     check.Status,
+		}).Warn("The bucket doesn't look public, -public-bucket links will fail.")
+	}
+}
+
+// ParsePrivateKey reads an RSA key from a PKCS#1 or PKCS#8 PEM block.
+func ParsePrivateKey(pemFile []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(pemFile)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+	return key, nil
+}
+
+// v4EscapePath percent encodes every segment of an object name, keeping the
+// slashes between them.
+func v4EscapePath(objectName string) string {
+	segments := strings.Split(objectName, "/")
+	for i, segment := range segments {
+		segments[i] = UrlEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// v4CanonicalQuery sorts and percent encodes params as required for the
+// canonical request.
+func v4CanonicalQuery(params url.Values) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, key := range keys {
+		values := append([]string(nil), params[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			parts = append(parts, UrlEscape(key)+"="+UrlEscape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// SignV4 returns a GOOG4-RSA-SHA256 signed GET URL for an object. Unlike V2,
+// the query parameters are part of the signature and must not be changed
+// afterwards.
+func SignV4(key *rsa.PrivateKey, accessID string, bucket string, objectName string, params url.Values, now time.Time, expiry time.Duration) (string, error) {
+	if key == nil {
+		return "", errors.New("no signing key loaded")
+	}
+	if expiry > maxV4Expiry {
+		expiry = maxV4Expiry
+	}
+	now = now.UTC()
+	timestamp := now.Format("20060102T150405Z")
+	scope := now.Format("20060102") + "/auto/storage/goog4_request"
+
+	query := url.Values{}
+	for name, values := range params {
+		query[name] = values
+	}
+	query.Set("X-Goog-Algorithm", "GOOG4-RSA-SHA256")
+	query.Set("X-Goog-Credential", accessID+"/"+scope)
+	query.Set("X-Goog-Date", timestamp)
+	query.Set("X-Goog-Expires", fmt.Sprintf("%d", int64(expiry/time.Second)))
+	query.Set("X-Goog-SignedHeaders", "host")
+	canonicalQuery := v4CanonicalQuery(query)
+
+	path := "/" + bucket + "/" + v4EscapePath(objectName)
+	canonicalRequest := strings.Join([]string{
+		"GET",
+		path,
+		canonicalQuery,
+		"host:" + storageHost + "\n",
+		"host",
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"GOOG4-RSA-SHA256",
+		timestamp,
+		scope,
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	digest := sha256.Sum256([]byte(stringToSign))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return "https://" + storageHost + path + "?" + canonicalQuery + "&X-Goog-Signature=" + hex.EncodeToString(signature), nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	humanize "github.com/dustin/go-humanize"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// SizeRange limits listings to objects of at least Min and at most Max
+// bytes. A zero Max means no upper limit.
+type SizeRange struct {
+	Min uint64
+	Max uint64
+}
+
+func parseSize(name string, value string) (uint64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	size, err := humanize.ParseBytes(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s %q", name, value)
+	}
+	return size, nil
+}
+
+// ParseSizeRange reads human readable sizes like 100MB or 2GiB from the
+// minSize and maxSize query parameters.
+func ParseSizeRange(request *http.Request) (SizeRange, error) {
+	min, err := parseSize("minSize", request.FormValue("minSize"))
+	if err != nil {
+		return SizeRange{}, err
+	}
+	max, err := parseSize("maxSize", request.FormValue("maxSize"))
+	if err != nil {
+		return SizeRange{}, err
+	}
+	if max != 0 && min > max {
+		return SizeRange{}, errors.New("minSize is larger than maxSize")
+	}
+	return SizeRange{Min: min, Max: max}, nil
+}
+
+// FilterBySize returns the objects between min and max bytes, with a zero max
+// meaning no upper limit.
+func FilterBySize(objects []*storage.Object, min uint64, max uint64) []*storage.Object {
+	if min == 0 && max == 0 {
+		return objects
+	}
+	result := make([]*storage.Object, 0, len(objects))
+	for _, object := range objects {
+		if object.Size >= min && (max == 0 || object.Size <= max) {
+			result = append(result, object)
+		}
+	}
+	return result
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+var (
+	defaultSort  = flag.String("default-sort", "updated", "Sort used when the request doesn't choose one: updated, name, size, expires, popular or meta:<key> for a custom metadata field.")
+	defaultOrder = flag.String("default-order", "", "Order used when the request doesn't choose one: asc or desc. Defaults to newest and largest first and names from A to Z.")
+)
+
+// sortItem is an object with its parsed update time and play count, so that
+// sorting parses every timestamp once instead of on every comparison.
+type sortItem struct {
+	object  *storage.Object
+	updated time.Time
+	created time.Time
+	plays   int64
+}
+
+func newSortItems(objects []*storage.Object) []sortItem {
+	items := make([]sortItem, len(objects))
+	for i, object := range objects {
+		items[i] = sortItem{object: object, updated: UpdatedTime(object), created: CreatedTime(object), plays: playCounts.Count(object.Name)}
+	}
+	return items
+}
+
+// UpdatedTime parses the update time of object. Timestamps are compared as
+// times, as the API doesn't always use the same precision or zone format.
+// Missing or invalid timestamps sort as the oldest.
+func UpdatedTime(object *storage.Object) time.Time {
+	updated, err := time.Parse(time.RFC3339Nano, object.Updated)
+	if err != nil {
+		return time.Time{}
+	}
+	return updated
+}
+
+// sortLess orders objects ascending by the key of the sort query parameter.
+var sortLess = map[string]func(a, b sortItem) bool{
+	"updated": func(a, b sortItem) bool { return a.updated.Before(b.updated) },
+	"name":    func(a, b sortItem) bool { return a.object.Name < b.object.Name },
+	"size":    func(a, b sortItem) bool { return a.object.Size < b.object.Size },
+	"popular": func(a, b sortItem) bool { return a.plays < b.plays },
+	// Objects expire in the order they were created, the ones without a
+	// creation time never as far as we know.
+	"expires": func(a, b sortItem) bool {
+		return !a.created.IsZero() && (b.created.IsZero() || a.created.Before(b.created))
+	},
+}
+
+// metaSortPrefix selects sorting by a custom metadata field, as in
+// meta:sortKey.
+const metaSortPrefix = "meta:"
+
+// metaLess orders by the metadata field key, numerically when both values
+// are numbers, with numbers before text.
+func metaLess(key string) func(a, b sortItem) bool {
+	return func(a, b sortItem) bool {
+		x, y := a.object.Metadata[key], b.object.Metadata[key]
+		xNumber, xErr := strconv.ParseFloat(x, 64)
+		yNumber, yErr := strconv.ParseFloat(y, 64)
+		switch {
+		case xErr == nil && yErr == nil:
+			return xNumber < yNumber
+		case xErr == nil || yErr == nil:
+			return xErr == nil
+		}
+		return x < y
+	}
+}
+
+// sortLessFor returns the ascending order of a sort key.
+func sortLessFor(key string) (func(a, b sortItem) bool, bool) {
+	if strings.HasPrefix(key, metaSortPrefix) && len(key) > len(metaSortPrefix) {
+		return metaLess(strings.TrimPrefix(key, metaSortPrefix)), true
+	}
+	less, ok := sortLess[key]
+	return less, ok
+}
+
+// SortKeys lists the sorts offered in the page navigation.
+func SortKeys() []string {
+	if *objectTTLDays > 0 {
+		return []string{"updated", "name", "size", "popular", "expires"}
+	}
+	return []string{"updated", "name", "size", "popular"}
+}
+
+// naturalOrder is the order of each sort when none is chosen.
+var naturalOrder = map[string]string{
+	"updated": "desc",
+	"name":    "asc",
+	"size":    "desc",
+	"popular": "desc",
+	"expires": "asc",
+}
+
+// ValidateSort checks the -default-sort and -default-order flags.
+func ValidateSort(key string, order string) error {
+	if _, ok := sortLessFor(key); !ok {
+		return fmt.Errorf("unknown sort %q", key)
+	}
+	if order != "" && order != "asc" && order != "desc" {
+		return fmt.Errorf("unknown order %q", order)
+	}
+	return nil
+}
+
+// SortOption is the sort selected for a listing.
+type SortOption struct {
+	Key   string
+	Order string
+}
+
+// ParseSort reads the sort and order query parameters, falling back to the
+// configured defaults for missing or unknown values.
+func ParseSort(request *http.Request) SortOption {
+	option := SortOption{Key: request.FormValue("sort"), Order: request.FormValue("order")}
+	if _, ok := sortLessFor(option.Key); !ok {
+		option.Key = *defaultSort
+		if option.Order == "" {
+			option.Order = *defaultOrder
+		}
+	}
+	if option.Order != "asc" && option.Order != "desc" {
+		option.Order = naturalOrder[option.Key]
+		if option.Order == "" {
+			option.Order = "asc"
+		}
+	}
+	return option
+}
+
+// less orders by the selected sort, and objects that sort the same by name
+// from A to Z whatever the order, so that pages don't shuffle between
+// requests. Objects without the metadata field of a meta: sort come last
+// whatever the order as well.
+func (o SortOption) less() func(a, b sortItem) bool {
+	less, _ := sortLessFor(o.Key)
+	if o.Order == "desc" {
+		ascending := less
+		less = func(a, b sortItem) bool { return ascending(b, a) }
+	}
+	field := strings.TrimPrefix(o.Key, metaSortPrefix)
+	metaSort := field != o.Key
+	return func(a, b sortItem) bool {
+		if metaSort {
+			_, aOk := a.object.Metadata[field]
+			_, bOk := b.object.Metadata[field]
+			if aOk != bOk {
+				return aOk
+			}
+		}
+		if less(a, b) {
+			return true
+		}
+		if less(b, a) {
+			return false
+		}
+		return a.object.Name < b.object.Name
+	}
+}
+
+// SortObjects sorts items in place.
+func SortObjects(items []*storage.Object, option SortOption) {
+	wrapped := newSortItems(items)
+	less := option.less()
+	sort.SliceStable(wrapped, func(i, j int) bool { return less(wrapped[i], wrapped[j]) })
+	for i, item := range wrapped {
+		items[i] = item.object
+	}
+}
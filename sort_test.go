@@ -0,0 +1,149 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+func objectNamesOf(items []*storage.Object) []string {
+	names := make([]string, len(items))
+	for i, item := range items {
+		names[i] = item.Name
+	}
+	return names
+}
+
+// shuffled returns a copy of items in random order.
+func shuffled(random *rand.Rand, items []*storage.Object) []*storage.Object {
+	copied := append([]*storage.Object(nil), items...)
+	random.Shuffle(len(copied), func(i, j int) { copied[i], copied[j] = copied[j], copied[i] })
+	return copied
+}
+
+func TestSortTiesByName(t *testing.T) {
+	items := []*storage.Object{
+		{Name: "c.mp4", Updated: "2020-01-01T00:00:00Z", Size: 10},
+		{Name: "a.mp4", Updated: "2020-01-01T00:00:00Z", Size: 10},
+		{Name: "d.mp4", Updated: "2020-01-02T00:00:00Z", Size: 20},
+		{Name: "b.mp4", Updated: "2020-01-01T00:00:00Z", Size: 10},
+	}
+	tests := []struct {
+		option SortOption
+		want   []string
+	}{
+		{SortOption{Key: "updated", Order: "desc"}, []string{"d.mp4", "a.mp4", "b.mp4", "c.mp4"}},
+		{SortOption{Key: "updated", Order: "asc"}, []string{"a.mp4", "b.mp4", "c.mp4", "d.mp4"}},
+		{SortOption{Key: "size", Order: "desc"}, []string{"d.mp4", "a.mp4", "b.mp4", "c.mp4"}},
+		{SortOption{Key: "size", Order: "asc"}, []string{"a.mp4", "b.mp4", "c.mp4", "d.mp4"}},
+		{SortOption{Key: "name", Order: "desc"}, []string{"d.mp4", "c.mp4", "b.mp4", "a.mp4"}},
+		// Nothing has been played, every object ties.
+		{SortOption{Key: "popular", Order: "desc"}, []string{"a.mp4", "b.mp4", "c.mp4", "d.mp4"}},
+	}
+	random := rand.New(rand.NewSource(1))
+	for _, test := range tests {
+		// Whatever order the listing comes in, the page is the same.
+		for i := 0; i < 10; i++ {
+			sorted := shuffled(random, items)
+			SortObjects(sorted, test.option)
+			if got := objectNamesOf(sorted); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("SortObjects(%v) = %v, want %v", test.option, got, test.want)
+				break
+			}
+		}
+	}
+	for i := 0; i < 10; i++ {
+		sorted := shuffled(random, items)
+		SortByUpdated(sorted)
+		if got, want := objectNamesOf(sorted), []string{"d.mp4", "a.mp4", "b.mp4", "c.mp4"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("SortByUpdated = %v, want %v", got, want)
+			break
+		}
+	}
+}
+
+func TestSortIsStable(t *testing.T) {
+	// Channels may list the same object twice, completely equal objects keep
+	// the order they came in.
+	first := &storage.Object{Name: "a.mp4", Updated: "2020-01-01T00:00:00Z"}
+	second := &storage.Object{Name: "a.mp4", Updated: "2020-01-01T00:00:00Z"}
+	newer := &storage.Object{Name: "b.mp4", Updated: "2020-01-02T00:00:00Z"}
+	for _, option := range []SortOption{{Key: "updated", Order: "desc"}, {Key: "updated", Order: "asc"}, {Key: "name", Order: "desc"}} {
+		items := []*storage.Object{first, newer, second}
+		SortObjects(items, option)
+		var equal []*storage.Object
+		for _, item := range items {
+			if item.Name == "a.mp4" {
+				equal = append(equal, item)
+			}
+		}
+		if equal[0] != first || equal[1] != second {
+			t.Errorf("SortObjects(%v) swapped equal objects", option)
+		}
+	}
+	items := []*storage.Object{first, newer, second}
+	SortByUpdated(items)
+	if items[1] != first || items[2] != second {
+		t.Error("SortByUpdated swapped equal objects")
+	}
+}
+
+func TestSortByUpdatedMixedPrecision(t *testing.T) {
+	tests := []struct {
+		name  string
+		items []*storage.Object
+		want  []string
+	}{
+		{
+			// As strings "Z" sorts after ".", which would put the older
+			// object first.
+			name: "fraction",
+			items: []*storage.Object{
+				{Name: "whole.mp4", Updated: "2020-01-01T00:00:00Z"},
+				{Name: "half.mp4", Updated: "2020-01-01T00:00:00.5Z"},
+			},
+			want: []string{"half.mp4", "whole.mp4"},
+		},
+		{
+			name: "precision",
+			items: []*storage.Object{
+				{Name: "millis.mp4", Updated: "2020-01-01T00:00:00.100Z"},
+				{Name: "tenths.mp4", Updated: "2020-01-01T00:00:00.2Z"},
+				{Name: "nanos.mp4", Updated: "2020-01-01T00:00:00.150000000Z"},
+			},
+			want: []string{"tenths.mp4", "nanos.mp4", "millis.mp4"},
+		},
+		{
+			name: "zone",
+			items: []*storage.Object{
+				{Name: "utc.mp4", Updated: "2020-01-01T01:00:00Z"},
+				{Name: "offset.mp4", Updated: "2020-01-01T01:30:00+01:00"},
+			},
+			want: []string{"utc.mp4", "offset.mp4"},
+		},
+		{
+			name: "same instant",
+			items: []*storage.Object{
+				{Name: "b.mp4", Updated: "2020-01-01T00:00:00.000Z"},
+				{Name: "a.mp4", Updated: "2020-01-01T00:00:00+00:00"},
+			},
+			want: []string{"a.mp4", "b.mp4"},
+		},
+		{
+			name: "invalid",
+			items: []*storage.Object{
+				{Name: "invalid.mp4", Updated: "yesterday"},
+				{Name: "valid.mp4", Updated: "2020-01-01T00:00:00Z"},
+			},
+			want: []string{"valid.mp4", "invalid.mp4"},
+		},
+	}
+	for _, test := range tests {
+		SortByUpdated(test.items)
+		if got := objectNamesOf(test.items); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: SortByUpdated = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// Scrubbing thumbnails are described by a WebVTT file next to the video,
+// clip.thumbnails.vtt for clip.mp4, whose cues point at regions of sprite
+// sheets such as clip.sprite.jpg#xywh=0,0,160,90.
+const thumbnailsSuffix = ".thumbnails.vtt"
+
+// ThumbnailsName returns the name of the thumbnail track of objectName.
+func ThumbnailsName(objectName string) string {
+	return strings.TrimSuffix(objectName, path.Ext(objectName)) + thumbnailsSuffix
+}
+
+// ThumbnailsPath is where the thumbnail track of objectName is served with
+// its sprite URLs signed.
+func ThumbnailsPath(objectName string) string {
+	return Link((&url.URL{Path: "/thumbnails/" + objectName}).String())
+}
+
+// ThumbnailsUrl returns the ThumbnailsPath of object if its thumbnail track
+// is among its siblings, or "" so the player goes without.
+func (s *Server) ThumbnailsUrl(ctx context.Context, object *storage.Object) string {
+	prefix := ""
+	if dir := path.Dir(object.Name); dir != "." {
+		prefix = dir + "/"
+	}
+	siblings, err := s.CachedObjects(ctx, prefix)
+	if err != nil || !objectNames(siblings)[ThumbnailsName(object.Name)] {
+		return ""
+	}
+	return ThumbnailsPath(object.Name)
+}
+
+// resolveSprite resolves the image of a cue in the track stored at
+// trackName to a signed URL, keeping the #xywh fragment that selects the
+// thumbnail. Each sprite is signed once per track, signed holds those done.
+func (s *Server) resolveSprite(trackName string, uri string, signed map[string]string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.IsAbs() || parsed.Path == "" {
+		return uri
+	}
+	var objectName string
+	if strings.HasPrefix(parsed.Path, "/") {
+		objectName = strings.TrimPrefix(path.Clean(parsed.Path), "/")
+	} else {
+		objectName = path.Join(path.Dir(trackName), parsed.Path)
+	}
+	signedUrl, ok := signed[objectName]
+	if !ok {
+		signedUrl = s.SignUrl(objectName)
+		signed[objectName] = signedUrl
+	}
+	if signedUrl == "" {
+		return uri
+	}
+	if parsed.Fragment != "" {
+		signedUrl += "#" + parsed.Fragment
+	}
+	return signedUrl
+}
+
+// RewriteThumbnails copies a thumbnail track from input to output replacing
+// the sprite of every cue with a URL the browser can fetch.
+func (s *Server) RewriteThumbnails(trackName string, input io.Reader, output io.Writer) error {
+	signed := make(map[string]string)
+	inCue := false
+	scanner := bufio.NewScanner(input)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+			inCue = false
+		case strings.Contains(line, "-->"):
+			inCue = true
+		case inCue:
+			line = s.resolveSprite(trackName, line, signed)
+		}
+		if _, err := io.WriteString(output, line+"\n"); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+func setThumbnailsHeaders(response http.ResponseWriter) {
+	NoIndex(response)
+	response.Header().Set("Content-type", "text/vtt; charset=utf-8")
+	// Like HLS manifests, the track must not outlive its signed sprites.
+	response.Header().Set("Cache-Control", "private, max-age=60")
+}
+
+// ThumbnailsHandler serves the thumbnail track of the video objectName.
+func (s *Server) ThumbnailsHandler(response http.ResponseWriter, request *http.Request) {
+	objectName := mux.Vars(request)["objectName"]
+	trackName := ThumbnailsName(objectName)
+	if s.Blocked.Blocked(trackName) {
+		logBlocked(request, trackName)
+		http.Error(response, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	if request.Method == "HEAD" {
+		// Like manifests, only whether the track exists is known without
+		// downloading it.
+		if _, err := s.GetObject(request.Context(), trackName); err != nil {
+			http.NotFound(response, request)
+			return
+		}
+		setThumbnailsHeaders(response)
+		return
+	}
+	res, err := s.Storage().Objects.Get(bucketName, StorageName(trackName)).Context(request.Context()).Download()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"objectName":    trackName,
+			"internalError": err,
+		}).Warn("Failed downloading thumbnail track.")
+		http.NotFound(response, request)
+		return
+	}
+	defer res.Body.Close()
+
+	setThumbnailsHeaders(response)
+	err = s.RewriteThumbnails(trackName, io.LimitReader(res.Body, maxManifestSize), response)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"objectName":    trackName,
+			"internalError": err,
+		}).Warn("Failed rewriting thumbnail track.")
+	}
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"embed"
+	"net/http"
+)
+
+//go:embed static
+var staticFiles embed.FS
+
+// StaticHandler serves the assets embedded from the static directory.
+func StaticHandler() http.Handler {
+	return http.FileServer(http.FS(staticFiles))
+}
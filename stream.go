@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// StreamedObject is the JSON form of an object in the listing stream.
+type StreamedObject struct {
+	Name        string `json:"name"`
+	Size        uint64 `json:"size"`
+	Updated     string `json:"updated"`
+	ContentType string `json:"contentType"`
+}
+
+type streamDone struct {
+	Total int `json:"total"`
+}
+
+// writeEvent writes a single server-sent event and flushes it to the client.
+func writeEvent(response http.ResponseWriter, flusher http.Flusher, event string, value interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(response, "event: %s\ndata: %s\n\n", event, data); err != nil {
+		return err
+	}
+	flusher.Flush()
+	return nil
+}
+
+// StreamHandler sends the objects below the prefix query parameter as
+// server-sent events, one "objects" event per listing page, followed by a
+// "done" event with the total or an "error" event. Listing stops as soon as
+// the client goes away.
+func (s *Server) StreamHandler(response http.ResponseWriter, request *http.Request) {
+	flusher, ok := response.(http.Flusher)
+	if !ok {
+		writeJSONError(response, http.StatusInternalServerError, "Streaming is not supported.")
+		return
+	}
+	prefix := request.FormValue("prefix")
+	sizes, err := ParseSizeRange(request)
+	if err != nil {
+		writeJSONError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	response.Header().Set("Content-type", "text/event-stream")
+	response.Header().Set("Cache-Control", "no-cache")
+	// Keep proxies like nginx from buffering the whole stream.
+	response.Header().Set("X-Accel-Buffering", "no")
+	response.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	total := 0
+	ctx := request.Context()
+	err = s.ListPages(ctx, prefix, func(page []*storage.Object) error {
+		page = FilterBySize(page, sizes.Min, sizes.Max)
+		objects := make([]StreamedObject, 0, len(page))
+		for _, object := range page {
+			objects = append(objects, StreamedObject{
+				Name:        object.Name,
+				Size:        object.Size,
+				Updated:     object.Updated,
+				ContentType: ContentType(object),
+			})
+		}
+		total += len(objects)
+		return writeEvent(response, flusher, "objects", objects)
+	})
+	if ctx.Err() != nil {
+		// The client is gone, there is nobody left to tell.
+		return
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"prefix":        prefix,
+			"internalError": err,
+		}).Warn("Failed streaming object list.")
+		writeEvent(response, flusher, "error", apiError{Error: "Failed listing objects."})
+		return
+	}
+	writeEvent(response, flusher, "done", streamDone{Total: total})
+}
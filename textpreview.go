@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"html"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"golang.org/x/net/context"
+	storage "google.golang.org/api/storage/v1"
+)
+
+var textPreviewMaxSize = flag.Int64("text-preview-max-size", 256<<10, "Text objects up to this many bytes are shown on their play page, markdown rendered and anything else as plain text. 0 disables the preview.")
+
+// textTypes are the content types besides text/* that are previewed as text.
+var textTypes = map[string]bool{
+	"application/x-subrip": true,
+	"application/json":     true,
+}
+
+// markdown renders without raw HTML, which goldmark drops along with
+// javascript: and similar links unless told otherwise.
+var markdown = goldmark.New()
+
+// IsMarkdown reports whether object is rendered as markdown.
+func IsMarkdown(object *storage.Object) bool {
+	switch strings.ToLower(path.Ext(object.Name)) {
+	case ".md", ".markdown":
+		return true
+	}
+	return strings.HasPrefix(ContentType(object), "text/markdown")
+}
+
+// TextPreviewable reports whether object is text small enough to preview.
+func TextPreviewable(object *storage.Object) bool {
+	if *textPreviewMaxSize <= 0 || int64(object.Size) > *textPreviewMaxSize {
+		return false
+	}
+	contentType := ContentType(object)
+	if i := strings.Index(contentType, ";"); i >= 0 {
+		contentType = contentType[:i]
+	}
+	return strings.HasPrefix(contentType, "text/") || textTypes[contentType] || IsMarkdown(object)
+}
+
+// TextPreview fetches object and renders it as HTML, markdown through
+// goldmark and everything else escaped in a <pre>.
+func (s *Server) TextPreview(ctx context.Context, object *storage.Object) (template.HTML, error) {
+	res, err := s.Storage().Objects.Get(bucketName, StorageName(object.Name)).Generation(object.Generation).Context(ctx).Download()
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	// Compressed objects may be served larger than their size.
+	text, err := ioutil.ReadAll(io.LimitReader(res.Body, *textPreviewMaxSize))
+	if err != nil {
+		return "", err
+	}
+	if !IsMarkdown(object) {
+		return template.HTML("<pre>" + html.EscapeString(string(text)) + "</pre>"), nil
+	}
+	var output bytes.Buffer
+	if err := markdown.Convert(text, &output); err != nil {
+		return "", err
+	}
+	return template.HTML(output.String()), nil
+}
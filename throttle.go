@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"io"
+
+	"golang.org/x/net/context"
+	"golang.org/x/time/rate"
+)
+
+var (
+	downloadRateKbps       = flag.Int("download-rate-kbps", 0, "Maximum rate in kilobits per second of each proxied download, 0 for unlimited.")
+	downloadGlobalRateKbps = flag.Int("download-global-rate-kbps", 0, "Maximum combined rate in kilobits per second of all proxied downloads, 0 for unlimited.")
+)
+
+// Largest read passed through the limiters at once, also used as their burst.
+const throttleChunkSize = 16 * 1024
+
+// NewRateLimiter returns a limiter for kbps kilobits per second, or nil for
+// no limit.
+func NewRateLimiter(kbps int) *rate.Limiter {
+	if kbps <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(kbps*1000/8), throttleChunkSize)
+}
+
+// ThrottledReader delays reads so that they don't exceed any of its limiters.
+type ThrottledReader struct {
+	Reader   io.Reader
+	Context  context.Context
+	Limiters []*rate.Limiter
+}
+
+// Throttle wraps reader in the limiters that are not nil. The reader is
+// returned as is when there are none.
+func Throttle(ctx context.Context, reader io.Reader, limiters ...*rate.Limiter) io.Reader {
+	var active []*rate.Limiter
+	for _, limiter := range limiters {
+		if limiter != nil {
+			active = append(active, limiter)
+		}
+	}
+	if len(active) == 0 {
+		return reader
+	}
+	return &ThrottledReader{Reader: reader, Context: ctx, Limiters: active}
+}
+
+func (r *ThrottledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunkSize {
+		p = p[:throttleChunkSize]
+	}
+	n, err := r.Reader.Read(p)
+	for _, limiter := range r.Limiters {
+		if waitErr := limiter.WaitN(r.Context, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/net/context"
+)
+
+var tracing = flag.Bool("tracing", false, "Export OpenTelemetry spans of requests, storage calls, signing and rendering over OTLP/HTTP. The exporter is configured with the standard OTEL_EXPORTER_OTLP_* and OTEL_SERVICE_NAME environment variables.")
+
+// tracer is a no-op until InitTracing installs a provider.
+var tracer = otel.Tracer("filebrowser")
+
+// InitTracing installs the OTLP exporter and the W3C trace context
+// propagator. Spans are exported in batches, the last few may be lost when
+// the server exits.
+func InitTracing() error {
+	exporter, err := otlptracehttp.New(context.Background())
+	if err != nil {
+		return err
+	}
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES win over the name given
+	// here.
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(semconv.ServiceName("filebrowser")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return nil
+}
+
+// TraceRequests starts a server span for every request, continuing the trace
+// of the caller when the request carries a traceparent header.
+func TraceRequests(next http.Handler) http.Handler {
+	if !*tracing {
+		return next
+	}
+	return otelhttp.NewHandler(next, "request", otelhttp.WithSpanNameFormatter(func(_ string, request *http.Request) string {
+		return request.Method + " " + request.URL.Path
+	}))
+}
+
+// NameSpans renames the server span after the matched route, keeping object
+// names out of span names so they group in the tracing backend.
+func NameSpans(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		span := trace.SpanFromContext(request.Context())
+		if route := mux.CurrentRoute(request); route != nil && span.IsRecording() {
+			if template, err := route.GetPathTemplate(); err == nil {
+				span.SetName(request.Method + " " + template)
+				span.SetAttributes(semconv.HTTPRoute(template))
+			}
+			if objectName, ok := mux.Vars(request)["objectName"]; ok {
+				span.SetAttributes(attribute.String("object", objectName))
+			}
+		}
+		next.ServeHTTP(response, request)
+	})
+}
+
+// TraceTransport makes every call to Cloud Storage a client span of the
+// request that caused it.
+func TraceTransport(transport http.RoundTripper) http.RoundTripper {
+	if !*tracing {
+		return transport
+	}
+	return otelhttp.NewTransport(transport)
+}
+
+// StartSpan starts a span for an operation on the bucket.
+func StartSpan(ctx context.Context, name string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithAttributes(append(attributes, attribute.String("bucket", bucketName))...))
+}
+
+// EndSpan records the result of the operation and ends span.
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		span.SetAttributes(attribute.String("result", "error"))
+	} else {
+		span.SetAttributes(attribute.String("result", "ok"))
+	}
+	span.End()
+}
+
+// detach keeps the span of ctx but not its cancellation, for storage calls
+// whose result is shared with other requests.
+func detach(ctx context.Context) context.Context {
+	return detached{ctx}
+}
+
+// detached is a context with the values of its parent that is never done.
+type detached struct {
+	context.Context
+}
+
+func (detached) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detached) Done() <-chan struct{}       { return nil }
+func (detached) Err() error                  { return nil }
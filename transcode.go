@@ -0,0 +1,215 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2/google"
+	transcoder "google.golang.org/api/transcoder/v1"
+)
+
+var (
+	enableTranscode   = flag.Bool("enable-transcode", false, "Let authenticated users convert videos browsers can't play to MP4 with the Transcoder API.")
+	transcodeProject  = flag.String("transcode-project", "", "Project running the transcoding jobs, required with -enable-transcode.")
+	transcodeLocation = flag.String("transcode-location", "us-central1", "Transcoder API location running the jobs.")
+)
+
+// Extensions of the videos that can be transcoded, browsers don't play them.
+var transcodeExtensions = map[string]bool{
+	".mkv": true,
+	".avi": true,
+}
+
+// NewTranscoderService creates a Transcoder API client from the default
+// credentials.
+func NewTranscoderService() (*transcoder.Service, error) {
+	client, err := google.DefaultClient(context.Background(), transcoder.CloudPlatformScope)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get default client: %v", err)
+	}
+	return transcoder.New(client)
+}
+
+// CanTranscode reports whether objectName is a video worth converting.
+func CanTranscode(objectName string) bool {
+	return *enableTranscode && transcodeExtensions[strings.ToLower(path.Ext(objectName))]
+}
+
+// TranscodeOutput is the name of the MP4 written next to objectName.
+func TranscodeOutput(objectName string) string {
+	return strings.TrimSuffix(objectName, path.Ext(objectName)) + ".mp4"
+}
+
+// webFriendlyJob returns a job converting the object input to H.264 and AAC
+// in an MP4 at output.
+func webFriendlyJob(input string, output string) *transcoder.Job {
+	folder := path.Dir(output) + "/"
+	if folder == "./" {
+		folder = ""
+	}
+	return &transcoder.Job{
+		InputUri:  "gs://" + bucketName + "/" + input,
+		OutputUri: "gs://" + bucketName + "/" + folder,
+		Config: &transcoder.JobConfig{
+			ElementaryStreams: []*transcoder.ElementaryStream{
+				{
+					Key: "video",
+					VideoStream: &transcoder.VideoStream{H264: &transcoder.H264CodecSettings{
+						HeightPixels: 720,
+						FrameRate:    30,
+						BitrateBps:   2500000,
+					}},
+				},
+				{
+					Key:         "audio",
+					AudioStream: &transcoder.AudioStream{Codec: "aac", BitrateBps: 128000},
+				},
+			},
+			MuxStreams: []*transcoder.MuxStream{
+				{
+					Key:               "web",
+					Container:         "mp4",
+					ElementaryStreams: []string{"video", "audio"},
+					FileName:          path.Base(output),
+				},
+			},
+		},
+	}
+}
+
+// transcodeJob is a job started by this instance.
+type transcodeJob struct {
+	name string
+	done bool
+}
+
+// TranscodeJobs remembers the last job started for each object, so that its
+// status can be polled. Jobs are forgotten on restart.
+type TranscodeJobs struct {
+	mutex sync.Mutex
+	jobs  map[string]*transcodeJob
+}
+
+// Started records job as the last one started for objectName.
+func (t *TranscodeJobs) Started(objectName string, job string) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.jobs == nil {
+		t.jobs = make(map[string]*transcodeJob)
+	}
+	t.jobs[objectName] = &transcodeJob{name: job}
+}
+
+// Job returns the name of the last job started for objectName, or "".
+func (t *TranscodeJobs) Job(objectName string) string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if job, ok := t.jobs[objectName]; ok {
+		return job.name
+	}
+	return ""
+}
+
+// finished marks the job of objectName done and reports whether it wasn't
+// before.
+func (t *TranscodeJobs) finished(objectName string, job string) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	current, ok := t.jobs[objectName]
+	if !ok || current.name != job || current.done {
+		return false
+	}
+	current.done = true
+	return true
+}
+
+type TranscodeStatus struct {
+	Name   string `json:"name"`
+	Output string `json:"output"`
+	Job    string `json:"job,omitempty"`
+	State  string `json:"state,omitempty"`
+	Error  string `json:"error,omitempty"`
+	DryRun bool   `json:"dryRun,omitempty"`
+}
+
+// StartTranscode submits a job converting objectName, or only records the
+// intent in dry-run mode.
+func (s *Server) StartTranscode(request *http.Request, objectName string) (TranscodeStatus, error) {
+	output := TranscodeOutput(objectName)
+	status := TranscodeStatus{Name: objectName, Output: output}
+	Audit(request, "transcode", log.Fields{"objectName": objectName, "output": output})
+	if *dryRun {
+		status.DryRun = true
+		return status, nil
+	}
+	parent := fmt.Sprintf("projects/%s/locations/%s", *transcodeProject, *transcodeLocation)
+	job, err := s.Transcoder.Projects.Locations.Jobs.Create(parent, webFriendlyJob(StorageName(objectName), StorageName(output))).Do()
+	if err != nil {
+		return status, err
+	}
+	s.Transcodes.Started(objectName, job.Name)
+	status.Job = job.Name
+	status.State = job.State
+	return status, nil
+}
+
+// TranscodeHandler starts converting the object to an MP4 next to it.
+func (s *Server) TranscodeHandler(response http.ResponseWriter, request *http.Request) {
+	objectName := mux.Vars(request)["objectName"]
+	if !CanTranscode(objectName) {
+		writeJSONError(response, http.StatusBadRequest, "only .mkv and .avi videos can be transcoded")
+		return
+	}
+	_, err := s.Storage().Objects.Get(bucketName, StorageName(TranscodeOutput(objectName))).Fields("name").Do()
+	if err == nil {
+		writeJSONError(response, http.StatusConflict, TranscodeOutput(objectName)+" already exists")
+		return
+	}
+	status, err := s.StartTranscode(request, objectName)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"objectName":    objectName,
+			"internalError": err,
+		}).Warn("Failed creating transcoding job.")
+		writeJSONError(response, http.StatusBadGateway, err.Error())
+		return
+	}
+	writeJSON(response, http.StatusAccepted, status)
+}
+
+// TranscodeStatusHandler reports the state of the last job started for the
+// object.
+func (s *Server) TranscodeStatusHandler(response http.ResponseWriter, request *http.Request) {
+	objectName := mux.Vars(request)["objectName"]
+	name := s.Transcodes.Job(objectName)
+	if name == "" {
+		writeJSONError(response, http.StatusNotFound, "no transcoding job for this object")
+		return
+	}
+	job, err := s.Transcoder.Projects.Locations.Jobs.Get(name).Do()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"objectName":    objectName,
+			"job":           name,
+			"internalError": err,
+		}).Warn("Failed getting transcoding job.")
+		writeJSONError(response, http.StatusBadGateway, err.Error())
+		return
+	}
+	status := TranscodeStatus{Name: objectName, Output: TranscodeOutput(objectName), Job: job.Name, State: job.State}
+	if job.Error != nil {
+		status.Error = job.Error.Message
+	}
+	// The new MP4 should show up in listings right away.
+	if job.State == "SUCCEEDED" && s.Transcodes.finished(objectName, name) {
+		s.Cache.Invalidate()
+	}
+	writeJSON(response, http.StatusOK, status)
+}
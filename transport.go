@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+)
+
+// Storage calls are not retried, a call that finds every connection busy
+// waits until one is released. Keep -gcs-max-conns above
+// -max-concurrent-downloads, or streamed downloads can starve listings.
+var (
+	gcsMaxConns              = flag.Int("gcs-max-conns", 64, "Maximum connections to Cloud Storage, 0 for no limit. Calls beyond it wait for a free connection.")
+	gcsMaxIdleConns          = flag.Int("gcs-max-idle-conns", 16, "Idle connections to Cloud Storage kept open for reuse.")
+	gcsIdleTimeout           = flag.Duration("gcs-idle-timeout", 90*time.Second, "How long an idle connection to Cloud Storage is kept open.")
+	gcsDialTimeout           = flag.Duration("gcs-dial-timeout", 10*time.Second, "Timeout for connecting to Cloud Storage.")
+	gcsResponseHeaderTimeout = flag.Duration("gcs-response-header-timeout", 30*time.Second, "Timeout for Cloud Storage to start answering a call. Downloads may take longer once they started.")
+)
+
+var (
+	storageTransportOnce sync.Once
+	storageTransport     *http.Transport
+)
+
+// StorageTransport returns the transport of the storage client. It is shared
+// by all credentials, so reloading them doesn't open a second pool.
+func StorageTransport() http.RoundTripper {
+	storageTransportOnce.Do(func() {
+		storageTransport = &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   *gcsDialTimeout,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			ForceAttemptHTTP2:     true,
+			MaxConnsPerHost:       *gcsMaxConns,
+			MaxIdleConns:          *gcsMaxIdleConns,
+			MaxIdleConnsPerHost:   *gcsMaxIdleConns,
+			IdleConnTimeout:       *gcsIdleTimeout,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: *gcsResponseHeaderTimeout,
+			ExpectContinueTimeout: time.Second,
+		}
+	})
+	return TraceTransport(withUserProject(storageTransport))
+}
+
+// storageContext makes the OAuth2 client created with it send its requests
+// through StorageTransport.
+func storageContext() context.Context {
+	return context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: StorageTransport()})
+}
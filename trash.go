@@ -0,0 +1,270 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	"google.golang.org/api/googleapi"
+	storage "google.golang.org/api/storage/v1"
+)
+
+var (
+	trashPrefix        = flag.String("trash-prefix", "", "Folder deleted objects are moved to, e.g. .trash/, instead of deleting them right away. Trashed objects are only shown on /trash, where they can be restored.")
+	trashRetentionDays = flag.Int("trash-retention-days", 30, "Days after which trashed objects are deleted for good, 0 to keep them.")
+	trashPurgeInterval = flag.Duration("trash-purge-interval", time.Hour, "How often trashed objects past -trash-retention-days are looked for.")
+)
+
+// trashedAtKey is the metadata field recording when an object was trashed.
+const trashedAtKey = "trashedAt"
+
+// ValidateTrashPrefix checks -trash-prefix and returns it as a folder name
+// ending with the delimiter.
+func ValidateTrashPrefix(prefix string) (string, error) {
+	if prefix == "" {
+		return "", nil
+	}
+	if strings.HasPrefix(prefix, delimiter) {
+		return "", errors.New("trash prefix must not start with a slash")
+	}
+	if !strings.HasSuffix(prefix, delimiter) {
+		prefix += delimiter
+	}
+	if hasBadSegment(prefix) {
+		return "", errors.New("trash prefix must not contain empty, . or .. segments")
+	}
+	return prefix, nil
+}
+
+// TrashEnabled reports whether deleting moves objects to the trash.
+func TrashEnabled() bool {
+	return *allowDelete && *trashPrefix != ""
+}
+
+// InTrash reports whether objectName is a trashed object.
+func InTrash(objectName string) bool {
+	return TrashEnabled() && strings.HasPrefix(objectName, *trashPrefix)
+}
+
+// TrashedName is the name objectName is moved to in the trash.
+func TrashedName(objectName string) string {
+	return *trashPrefix + objectName
+}
+
+// TrashedAt returns when object was moved to the trash, or the zero time
+// when it wasn't recorded.
+func TrashedAt(object *storage.Object) time.Time {
+	trashed, err := time.Parse(time.RFC3339, object.Metadata[trashedAtKey])
+	if err != nil {
+		return time.Time{}
+	}
+	return trashed
+}
+
+// moveObject copies object to destination with the given custom metadata and
+// deletes the original. The original is only deleted if it didn't change in
+// the meantime.
+func (s *Server) moveObject(object *storage.Object, destination string, metadata map[string]string) error {
+	copied := &storage.Object{
+		CacheControl:       object.CacheControl,
+		ContentDisposition: object.ContentDisposition,
+		ContentEncoding:    object.ContentEncoding,
+		ContentLanguage:    object.ContentLanguage,
+		ContentType:        object.ContentType,
+		Metadata:           metadata,
+	}
+	call := s.Storage().Objects.Rewrite(bucketName, StorageName(object.Name), bucketName, StorageName(destination), copied).
+		SourceGeneration(object.Generation)
+	for {
+		res, err := call.Do()
+		if err != nil {
+			return err
+		}
+		if res.Done {
+			break
+		}
+		// Large objects are copied in several calls.
+		call.RewriteToken(res.RewriteToken)
+	}
+	return s.Storage().Objects.Delete(bucketName, StorageName(object.Name)).IfGenerationMatch(object.Generation).Do()
+}
+
+// TrashObject moves objectName to the trash, or only records the intent in
+// dry-run mode. Objects already in the trash are deleted for good.
+func (s *Server) TrashObject(request *http.Request, objectName string) error {
+	if InTrash(objectName) {
+		return s.DeleteObject(request, objectName)
+	}
+	Audit(request, "trash", log.Fields{"objectName": objectName})
+	if *dryRun {
+		return nil
+	}
+	object, err := s.GetObject(request.Context(), objectName)
+	if err != nil {
+		return err
+	}
+	metadata := make(map[string]string, len(object.Metadata)+1)
+	for key, value := range object.Metadata {
+		metadata[key] = value
+	}
+	metadata[trashedAtKey] = time.Now().UTC().Format(time.RFC3339)
+	return s.moveObject(object, TrashedName(objectName), metadata)
+}
+
+// RestoreObject moves the trashed copy of objectName back, or only records
+// the intent in dry-run mode.
+func (s *Server) RestoreObject(request *http.Request, objectName string) error {
+	Audit(request, "restore", log.Fields{"objectName": objectName})
+	if *dryRun {
+		return nil
+	}
+	object, err := s.GetObject(request.Context(), TrashedName(objectName))
+	if err != nil {
+		return err
+	}
+	metadata := make(map[string]string, len(object.Metadata))
+	for key, value := range object.Metadata {
+		if key != trashedAtKey {
+			metadata[key] = value
+		}
+	}
+	return s.moveObject(object, objectName, metadata)
+}
+
+// TrashEntry is a trashed object under the name it is restored to.
+type TrashEntry struct {
+	Name   string
+	Object *storage.Object
+	// TrashedAt and PurgeAt are RFC 3339 timestamps, PurgeAt is empty when
+	// trashed objects are kept.
+	TrashedAt string
+	PurgeAt   string
+}
+
+// ListTrash returns the trashed objects, most recently trashed first.
+func (s *Server) ListTrash() ([]TrashEntry, error) {
+	var entries []TrashEntry
+	// listPages doesn't hide blocked objects, which includes the trash.
+	err := listPages(s.ObjectsList(), *trashPrefix, func(page []*storage.Object) error {
+		for _, object := range page {
+			entry := TrashEntry{
+				Name:   strings.TrimPrefix(object.Name, *trashPrefix),
+				Object: object,
+			}
+			if trashed := TrashedAt(object); !trashed.IsZero() {
+				entry.TrashedAt = trashed.Format(time.RFC3339)
+				if *trashRetentionDays > 0 {
+					entry.PurgeAt = trashed.AddDate(0, 0, *trashRetentionDays).Format(time.RFC3339)
+				}
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, RequesterPaysHint(err)
+	}
+	// RFC 3339 timestamps of the same zone sort like the times.
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].TrashedAt > entries[j].TrashedAt })
+	return entries, nil
+}
+
+// PurgeTrash deletes the objects trashed longer than -trash-retention-days
+// ago and returns how many it deleted.
+func (s *Server) PurgeTrash() (int, error) {
+	cutoff := time.Now().AddDate(0, 0, -*trashRetentionDays)
+	purged := 0
+	err := listPages(s.ObjectsList(), *trashPrefix, func(page []*storage.Object) error {
+		for _, object := range page {
+			trashed := TrashedAt(object)
+			if trashed.IsZero() || trashed.After(cutoff) {
+				continue
+			}
+			if *dryRun {
+				log.WithFields(log.Fields{
+					"objectName": object.Name,
+				}).Info("Dry run, not purging trashed object.")
+				continue
+			}
+			err := s.Storage().Objects.Delete(bucketName, StorageName(object.Name)).IfGenerationMatch(object.Generation).Do()
+			if err != nil {
+				log.WithFields(log.Fields{
+					"objectName":    object.Name,
+					"internalError": err,
+				}).Warn("Failed purging trashed object.")
+				continue
+			}
+			log.WithFields(log.Fields{
+				"audit":      true,
+				"operation":  "purge",
+				"objectName": object.Name,
+			}).Info("Purged trashed object.")
+			purged++
+		}
+		return nil
+	})
+	return purged, err
+}
+
+// RunTrashPurge purges the trash every interval.
+func (s *Server) RunTrashPurge(interval time.Duration) {
+	for {
+		if purged, err := s.PurgeTrash(); err != nil {
+			log.WithFields(log.Fields{
+				"internalError": err,
+			}).Warn("Failed purging the trash.")
+		} else if purged > 0 {
+			log.WithFields(log.Fields{
+				"purged": purged,
+			}).Info("Purged the trash.")
+		}
+		time.Sleep(interval)
+	}
+}
+
+type TrashPage struct {
+	Entries []TrashEntry
+}
+
+// TrashHandler lists the trashed objects with a restore action.
+func (s *Server) TrashHandler(response http.ResponseWriter, request *http.Request) {
+	response.Header().Set("Content-type", "text/html")
+	entries, err := s.ListTrash()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"internalError": err,
+		}).Warn("Failed listing the trash.")
+	}
+	s.Render(response, request, "trash.html", TrashPage{Entries: entries})
+}
+
+// RestoreHandler moves the object back out of the trash.
+func (s *Server) RestoreHandler(response http.ResponseWriter, request *http.Request) {
+	if !RequireJSON(response, request) {
+		return
+	}
+	objectName := mux.Vars(request)["objectName"]
+	if _, err := s.GetObject(request.Context(), objectName); err == nil {
+		writeJSONError(response, http.StatusConflict, objectName+" exists, delete or rename it first")
+		return
+	}
+	if err := s.RestoreObject(request, objectName); err != nil {
+		if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == http.StatusNotFound {
+			writeJSONError(response, http.StatusNotFound, "not in the trash")
+			return
+		}
+		log.WithFields(log.Fields{
+			"objectName":    objectName,
+			"internalError": err,
+		}).Warn("Failed restoring object.")
+		writeJSONError(response, http.StatusBadGateway, err.Error())
+		return
+	}
+	s.Cache.Invalidate()
+	writeJSON(response, http.StatusOK, DeleteResult{Status: "restored"})
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"google.golang.org/api/googleapi"
+	storage "google.golang.org/api/storage/v1"
+)
+
+type UploadResult struct {
+	Name        string `json:"name"`
+	Size        uint64 `json:"size"`
+	ContentType string `json:"contentType,omitempty"`
+	Kind        string `json:"kind,omitempty"`
+	DryRun      bool   `json:"dryRun,omitempty"`
+	// HookErrors are the failures of non-critical -upload-hooks, the upload
+	// itself succeeded.
+	HookErrors []string `json:"hookErrors,omitempty"`
+}
+
+// CheckUploadPrefix makes sure uploads go to a folder, and to one inside a
+// channel when there are channels.
+func (s *Server) CheckUploadPrefix(prefix string) error {
+	if prefix != "" && (!strings.HasSuffix(prefix, delimiter) || hasBadSegment(prefix)) {
+		return errors.New("prefix must be a folder ending with a slash, without empty, . or .. segments")
+	}
+	if len(s.Channels) == 0 {
+		return nil
+	}
+	for _, channel := range s.Channels {
+		if strings.HasPrefix(prefix, channel.Prefix) {
+			return nil
+		}
+	}
+	return errors.New("prefix must be inside one of the channels")
+}
+
+// UploadHandler streams the file parts of a multipart POST into the bucket
+// below the folder given by the prefix query parameter. Existing objects are
+// only replaced with overwrite=1.
+func (s *Server) UploadHandler(response http.ResponseWriter, request *http.Request) {
+	reader, err := request.MultipartReader()
+	if err != nil {
+		writeJSONError(response, http.StatusBadRequest, "expected a multipart upload")
+		return
+	}
+	// FormValue would consume the multipart body.
+	prefix := request.URL.Query().Get("prefix")
+	if err := s.CheckUploadPrefix(prefix); err != nil {
+		writeJSONError(response, http.StatusBadRequest, err.Error())
+		return
+	}
+	overwrite := request.URL.Query().Get("overwrite") == "1"
+
+	var results []UploadResult
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			s.uploadError(response, "", err)
+			return
+		}
+		if part.FileName() == "" {
+			continue
+		}
+		name, err := SanitizeUploadName(rawFileName(part), time.Now())
+		if err != nil {
+			writeJSONError(response, http.StatusBadRequest, fmt.Sprintf("%q: %v", part.FileName(), err))
+			return
+		}
+		objectName := prefix + name
+		// Blocked names include the trash and the saved play counts.
+		if s.Blocked.Blocked(objectName) {
+			writeJSONError(response, http.StatusForbidden, fmt.Sprintf("%q can't be uploaded", objectName))
+			return
+		}
+		Audit(request, "upload", log.Fields{"objectName": objectName})
+		if *dryRun {
+			size, err := io.Copy(ioutil.Discard, part)
+			if err != nil {
+				s.uploadError(response, objectName, err)
+				return
+			}
+			results = append(results, UploadResult{Name: objectName, Size: uint64(size), DryRun: true})
+			continue
+		}
+		call := s.Storage().Objects.Insert(bucketName, &storage.Object{Name: StorageName(objectName)}).Media(part)
+		if !overwrite {
+			call.IfGenerationMatch(0)
+		}
+		object, err := call.Do()
+		if err != nil {
+			if apiErr, ok := err.(*googleapi.Error); ok && apiErr.Code == http.StatusPreconditionFailed {
+				writeJSONError(response, http.StatusConflict, objectName+" exists, upload with overwrite=1 to replace it")
+				return
+			}
+			s.uploadError(response, objectName, err)
+			return
+		}
+		// Hooks only run on objects that passed the checks above.
+		object, hookErrors, err := s.RunUploadHooks(request.Context(), object)
+		if err != nil {
+			// A critical hook failed, don't leave the object half processed.
+			if err := s.Storage().Objects.Delete(bucketName, object.Name).IfGenerationMatch(object.Generation).Do(); err != nil {
+				log.WithFields(log.Fields{
+					"objectName":    objectName,
+					"internalError": err,
+				}).Warn("Failed removing object after upload hook failure.")
+			}
+			s.uploadError(response, objectName, err)
+			return
+		}
+		results = append(results, UploadResult{
+			Name:        objectName,
+			Size:        object.Size,
+			ContentType: object.ContentType,
+			Kind:        MediaKind(object),
+			HookErrors:  hookErrors,
+		})
+	}
+	if len(results) == 0 {
+		writeJSONError(response, http.StatusBadRequest, "no file in upload")
+		return
+	}
+	writeJSON(response, http.StatusCreated, results)
+}
+
+func (s *Server) uploadError(response http.ResponseWriter, objectName string, err error) {
+	if isBodyTooLarge(err) {
+		writeJSONError(response, http.StatusRequestEntityTooLarge, "upload too large")
+		return
+	}
+	log.WithFields(log.Fields{
+		"objectName":    objectName,
+		"internalError": err,
+	}).Warn("Failed uploading object.")
+	writeJSONError(response, http.StatusInternalServerError, "upload failed")
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	storage "google.golang.org/api/storage/v1"
+)
+
+func TestCheckUploadPrefix(t *testing.T) {
+	tests := []struct {
+		prefix   string
+		channels []Channel
+		ok       bool
+	}{
+		{"", nil, true},
+		{"videos/", nil, true},
+		{"videos/2020/", nil, true},
+		{"videos", nil, false},
+		{"/videos/", nil, false},
+		{"videos//", nil, false},
+		{"../videos/", nil, false},
+		{"videos/../other/", nil, false},
+		{"videos/./", nil, false},
+		{"news/", []Channel{{Name: "news", Prefix: "news/"}}, true},
+		{"news/today/", []Channel{{Name: "news", Prefix: "news/"}}, true},
+		{"sports/", []Channel{{Name: "news", Prefix: "news/"}}, false},
+		{"", []Channel{{Name: "news", Prefix: "news/"}}, false},
+		{"news/../sports/", []Channel{{Name: "news", Prefix: "news/"}}, false},
+	}
+	for _, test := range tests {
+		s := &Server{Channels: test.channels}
+		if err := s.CheckUploadPrefix(test.prefix); (err == nil) != test.ok {
+			t.Errorf("CheckUploadPrefix(%q) with channels %v = %v, want ok %v", test.prefix, test.channels, err, test.ok)
+		}
+	}
+}
+
+// multipartUpload returns a multipart body with one file of size bytes.
+func multipartUpload(t *testing.T, size int) (*bytes.Buffer, string) {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("file", "clip.mp4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(bytes.Repeat([]byte("x"), size))
+	writer.Close()
+	return &body, writer.FormDataContentType()
+}
+
+func TestUploadBodyLimit(t *testing.T) {
+	defer func(limit int64) { *maxUploadSize = limit }(*maxUploadSize)
+	*maxUploadSize = 1024
+	handler := LimitBody(http.HandlerFunc(newTestServer(t, &fakeBucket{}).UploadHandler))
+
+	tests := []struct {
+		name   string
+		size   int
+		length bool
+		status int
+	}{
+		{"small", 100, true, http.StatusCreated},
+		{"announced too large", 4096, true, http.StatusRequestEntityTooLarge},
+		{"chunked too large", 4096, false, http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		body, contentType := multipartUpload(t, test.size)
+		var reader io.Reader = body
+		if !test.length {
+			// Hide the length so only reading runs into the limit.
+			reader = io.MultiReader(body)
+		}
+		request := httptest.NewRequest("POST", "/upload?prefix=videos/", reader)
+		request.Header.Set("Content-Type", contentType)
+		if !test.length {
+			request.ContentLength = -1
+		}
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		if response.Code != test.status {
+			t.Errorf("%s: status %d, want %d: %s", test.name, response.Code, test.status, response.Body)
+		}
+		if test.status == http.StatusRequestEntityTooLarge && !strings.Contains(response.Body.String(), "too large") {
+			t.Errorf("%s: body %q doesn't say the upload is too large", test.name, response.Body)
+		}
+	}
+}
+
+// countingHook counts the objects it ran on.
+type countingHook struct{ runs *int }
+
+func (countingHook) Critical() bool { return true }
+
+func (h countingHook) Run(ctx context.Context, s *Server, object *storage.Object) (*storage.Object, error) {
+	*h.runs++
+	return object, nil
+}
+
+func TestUploadHooksOnlyRunOnAcceptedNames(t *testing.T) {
+	setFlag(t, playCountsObject, "videos/_stats.json")
+	bucket := &fakeBucket{}
+	bucket.add("videos/clip.mp4", "old", storage.Object{})
+	s := newTestServer(t, bucket)
+	var runs int
+	s.UploadHooks = []UploadHook{countingHook{&runs}}
+
+	tests := []struct {
+		query    string
+		filename string
+		status   int
+		runs     int
+	}{
+		{"prefix=videos/", "_stats.json", http.StatusForbidden, 0},
+		{"prefix=videos/&overwrite=1", "_stats.json", http.StatusForbidden, 0},
+		{"prefix=videos/", "clip.mp4", http.StatusConflict, 0},
+		{"prefix=videos/&overwrite=1", "clip.mp4", http.StatusCreated, 1},
+	}
+	for _, test := range tests {
+		runs = 0
+		var body bytes.Buffer
+		writer := multipart.NewWriter(&body)
+		part, err := writer.CreateFormFile("file", test.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		part.Write([]byte("data"))
+		writer.Close()
+		request := httptest.NewRequest("POST", "/upload?"+test.query, &body)
+		request.Header.Set("Content-Type", writer.FormDataContentType())
+		response := httptest.NewRecorder()
+		s.UploadHandler(response, request)
+		if response.Code != test.status {
+			t.Errorf("%s %s: status %d, want %d: %s", test.query, test.filename, response.Code, test.status, response.Body)
+		}
+		if runs != test.runs {
+			t.Errorf("%s %s: hook ran %d times, want %d", test.query, test.filename, runs, test.runs)
+		}
+	}
+	if stored := bucket.objects["videos/clip.mp4"]; string(stored.data) != "data" {
+		t.Errorf("overwrite left %q, want data", stored.data)
+	}
+	if _, ok := bucket.objects["videos/_stats.json"]; ok {
+		t.Errorf("the blocked upload was stored")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+	storage "google.golang.org/api/storage/v1"
+)
+
+var uploadHooksFlag = flag.String("upload-hooks", "", "Comma separated hooks run in order on every uploaded object. defaults sets -default-cache-control and a content type guessed from the name on objects uploaded without them.")
+
+// UploadHook post-processes an uploaded object. Hooks are chained, each one
+// gets the object as the previous one left it.
+type UploadHook interface {
+	// Run returns the object as it is after the hook, or the one it was
+	// given when it changed nothing.
+	Run(ctx context.Context, s *Server, object *storage.Object) (*storage.Object, error)
+	// Critical hooks fail the upload when they fail, the errors of other
+	// hooks are only reported with the upload result.
+	Critical() bool
+}
+
+// uploadHooks are the hooks -upload-hooks can name.
+var uploadHooks = map[string]UploadHook{
+	"defaults": defaultsHook{},
+}
+
+// ParseUploadHooks looks up the hooks of a comma separated list of names.
+func ParseUploadHooks(input string) ([]UploadHook, error) {
+	var hooks []UploadHook
+	for _, name := range strings.Split(input, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		hook, ok := uploadHooks[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown upload hook %q", name)
+		}
+		hooks = append(hooks, hook)
+	}
+	return hooks, nil
+}
+
+// hookName returns the name hook is configured by, for errors and logging.
+func hookName(hook UploadHook) string {
+	for name, known := range uploadHooks {
+		if known == hook {
+			return name
+		}
+	}
+	return fmt.Sprintf("%T", hook)
+}
+
+// RunUploadHooks chains s.UploadHooks over a freshly uploaded object. It
+// stops at the first critical error, the errors of the other hooks are
+// returned as messages and the chain goes on with the object as it was.
+func (s *Server) RunUploadHooks(ctx context.Context, object *storage.Object) (*storage.Object, []string, error) {
+	var messages []string
+	for _, hook := range s.UploadHooks {
+		updated, err := hook.Run(ctx, s, object)
+		if err != nil {
+			name := hookName(hook)
+			if hook.Critical() {
+				return object, messages, fmt.Errorf("upload hook %s: %v", name, err)
+			}
+			log.WithFields(log.Fields{
+				"objectName":    object.Name,
+				"hook":          name,
+				"internalError": err,
+			}).Warn("Upload hook failed.")
+			messages = append(messages, name+": "+err.Error())
+			continue
+		}
+		object = updated
+	}
+	return object, messages, nil
+}
+
+// defaultsHook fills in the metadata uploads from browsers usually lack.
+type defaultsHook struct{}
+
+func (defaultsHook) Critical() bool { return false }
+
+func (defaultsHook) Run(ctx context.Context, s *Server, object *storage.Object) (*storage.Object, error) {
+	patch := &storage.Object{}
+	changed := false
+	if object.CacheControl == "" && *defaultCacheControl != "" {
+		patch.CacheControl = *defaultCacheControl
+		changed = true
+	}
+	if object.ContentType == "" || object.ContentType == "application/octet-stream" {
+		if contentType := TypeByName(object.Name); contentType != "" {
+			patch.ContentType = contentType
+			changed = true
+		}
+	}
+	if !changed {
+		return object, nil
+	}
+	// The object may have been replaced since it was uploaded.
+	return s.Storage().Objects.Patch(bucketName, object.Name, patch).
+		IfMetagenerationMatch(object.Metageneration).Context(ctx).Do()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"mime"
+	"mime/multipart"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	uploadMaxNameLength   = flag.Int("upload-max-name-length", 200, "Longest uploaded file name in bytes, longer names are shortened keeping their extension. Folders kept by -upload-folders and the -upload-timestamp-prefix count towards it.")
+	uploadFolders         = flag.Bool("upload-folders", false, "Keep the folders in uploaded file names, as sent by browsers uploading a whole directory, instead of only the base name.")
+	uploadTimestampPrefix = flag.Bool("upload-timestamp-prefix", false, "Prefix the base name of every upload with its UTC upload time, e.g. 20060102-150405-, so that uploads with the same name don't replace each other.")
+)
+
+var errEmptyUploadName = errors.New("file name is empty after sanitizing")
+
+// rawFileName returns the file name of part as the client sent it.
+// part.FileName already drops the folders.
+func rawFileName(part *multipart.Part) string {
+	_, params, err := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+	if err != nil {
+		return part.FileName()
+	}
+	return params["filename"]
+}
+
+// SanitizeUploadName turns the file name of an upload into an object name
+// below the upload prefix: control characters are dropped, backslashes of
+// Windows paths become slashes, and only the base name is kept unless
+// -upload-folders is set, in which case empty, . and .. segments are left
+// out. The result is at most -upload-max-name-length bytes long.
+func SanitizeUploadName(filename string, now time.Time) (string, error) {
+	filename = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || r == utf8.RuneError {
+			return -1
+		}
+		if r == '\\' {
+			return '/'
+		}
+		return r
+	}, filename)
+	var segments []string
+	for _, segment := range strings.Split(filename, "/") {
+		segment = strings.TrimSpace(segment)
+		if segment != "" && segment != "." && segment != ".." {
+			segments = append(segments, segment)
+		}
+	}
+	if len(segments) == 0 {
+		return "", errEmptyUploadName
+	}
+	if !*uploadFolders {
+		segments = segments[len(segments)-1:]
+	}
+	folder := strings.Join(segments[:len(segments)-1], "/")
+	if folder != "" {
+		folder += "/"
+	}
+	timestamp := ""
+	if *uploadTimestampPrefix {
+		timestamp = now.UTC().Format("20060102-150405-")
+	}
+	base := shortenName(segments[len(segments)-1], *uploadMaxNameLength-len(folder)-len(timestamp))
+	if base == "" {
+		return "", errEmptyUploadName
+	}
+	return folder + timestamp + base, nil
+}
+
+// shortenName cuts name to at most max bytes without splitting a character,
+// keeping short extensions.
+func shortenName(name string, max int) string {
+	if len(name) <= max {
+		return name
+	}
+	if max <= 0 {
+		return ""
+	}
+	extension := path.Ext(name)
+	if len(extension) >= max {
+		extension = ""
+	}
+	stem := name[:max-len(extension)]
+	for !utf8.ValidString(stem) {
+		stem = stem[:len(stem)-1]
+	}
+	return strings.TrimSpace(stem) + extension
+}
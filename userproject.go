@@ -0,0 +1,49 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+)
+
+var userProject = flag.String("user-project", "", "Project billed for the requests to a requester pays bucket.")
+
+// userProjectTransport adds the userProject parameter to every storage API
+// call, so that none of them can forget it.
+type userProjectTransport struct {
+	base    http.RoundTripper
+	project string
+}
+
+func (t userProjectTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the request it was given.
+	request = request.Clone(request.Context())
+	query := request.URL.Query()
+	query.Set("userProject", t.project)
+	request.URL.RawQuery = query.Encode()
+	return t.base.RoundTrip(request)
+}
+
+// withUserProject bills the calls sent through base to -user-project.
+func withUserProject(base http.RoundTripper) http.RoundTripper {
+	if *userProject == "" {
+		return base
+	}
+	return userProjectTransport{base: base, project: *userProject}
+}
+
+// RequesterPaysHint points to -user-project when err says the bucket is
+// requester pays.
+func RequesterPaysHint(err error) error {
+	apiErr, ok := err.(*googleapi.Error)
+	if !ok || apiErr.Code != http.StatusBadRequest || *userProject != "" {
+		return err
+	}
+	if !strings.Contains(strings.ToLower(apiErr.Message), "requester pays") {
+		return err
+	}
+	return fmt.Errorf("%v, set -user-project to the project to bill", err)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var defaultView = flag.String("default-view", "list", "Layout of the index pages for visitors who haven't chosen one: list or grid.")
+
+const viewCookieName = "view"
+
+// Views lists the layouts of the index pages.
+func Views() []string {
+	return []string{"list", "grid"}
+}
+
+func isView(view string) bool {
+	for _, known := range Views() {
+		if view == known {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateView checks the -default-view flag.
+func ValidateView(view string) error {
+	if !isView(view) {
+		return fmt.Errorf("unknown view %q", view)
+	}
+	return nil
+}
+
+// ChooseView returns the layout chosen with the view query parameter and
+// remembers it in a cookie, or the one remembered before.
+func ChooseView(response http.ResponseWriter, request *http.Request) string {
+	if view := request.FormValue("view"); isView(view) {
+		http.SetCookie(response, &http.Cookie{
+			Name:     viewCookieName,
+			Value:    view,
+			Path:     "/",
+			Expires:  time.Now().Add(365 * 24 * time.Hour),
+			HttpOnly: true,
+		})
+		return view
+	}
+	if cookie, err := request.Cookie(viewCookieName); err == nil && isView(cookie.Value) {
+		return cookie.Value
+	}
+	return *defaultView
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+)
+
+var (
+	warmCache       = flag.Bool("warm-cache", false, "Fill the listing cache in the background at startup and make the posters of the first index page, so the first requests after a deploy are fast. Serving starts right away.")
+	warmBeforeReady = flag.Bool("warm-before-ready", false, "Answer /readyz with 503 until -warm-cache is done, so that load balancers wait for it.")
+)
+
+// CacheWarmth tracks the warm-up of -warm-cache.
+type CacheWarmth struct {
+	mutex   sync.Mutex
+	warming bool
+}
+
+func (w *CacheWarmth) set(warming bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.warming = warming
+}
+
+// Warming reports whether the warm-up is still running.
+func (w *CacheWarmth) Warming() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.warming
+}
+
+// WarmCache lists what the index shows and starts making the posters of its
+// first page, as a visitor without query parameters would see it. The
+// warm-up is done once the listing is cached, the posters follow in the
+// background.
+func (s *Server) WarmCache() {
+	defer s.Warmth.set(false)
+	start := time.Now()
+	if *cacheTTL <= 0 {
+		log.Warn("-warm-cache does nothing without the listing cache, -cache-ttl is 0.")
+		return
+	}
+	// IndexObjects logs failures itself, the first visitors list the
+	// bucket then.
+	items := s.IndexObjects(context.Background(), "", "")
+	request, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		return
+	}
+	page, err := s.NewIndexPage(request, s.IndexPrefixes(""), items)
+	if err != nil {
+		return
+	}
+	s.PrefetchPosters(page.Items, items)
+	log.WithFields(log.Fields{
+		"objects":  len(items),
+		"duration": time.Since(start),
+	}).Info("Warmed listing cache.")
+}
+
+// StartWarmCache runs WarmCache in the background.
+func (s *Server) StartWarmCache() {
+	s.Warmth.set(true)
+	go s.WarmCache()
+}
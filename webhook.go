@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"golang.org/x/net/context"
+	storage "google.golang.org/api/storage/v1"
+)
+
+var (
+	webhookUrl      = flag.String("webhook-url", "", "URL notified with a JSON POST for every new object. Disabled when empty.")
+	webhookSecret   = flag.String("webhook-secret", "", "Shared secret used to sign webhook payloads in the X-Filebrowser-Signature header.")
+	webhookInterval = flag.Duration("webhook-interval", time.Minute, "How often the bucket is polled for new objects. Deliveries of a poll, retries included, stop after this long, the remaining objects are notified on the next poll.")
+	webhookState    = flag.String("webhook-state", "webhook-state.json", "File remembering the objects already notified across restarts.")
+)
+
+const webhookAttempts = 5
+
+type WebhookPayload struct {
+	Name string `json:"name"`
+	Size uint64 `json:"size"`
+	Url  string `json:"url"`
+}
+
+// Webhook notifies an external URL about objects it hasn't seen before.
+type Webhook struct {
+	Url       string
+	Secret    []byte
+	StateFile string
+	Client    *http.Client
+
+	seen map[string]bool
+}
+
+func (w *Webhook) loadState() error {
+	data, err := ioutil.ReadFile(w.StateFile)
+	if err != nil {
+		return err
+	}
+	var names []string
+	if err := json.Unmarshal(data, &names); err != nil {
+		return err
+	}
+	w.seen = make(map[string]bool, len(names))
+	for _, name := range names {
+		w.seen[name] = true
+	}
+	return nil
+}
+
+func (w *Webhook) saveState() error {
+	names := make([]string, 0, len(w.seen))
+	for name := range w.seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	data, err := json.Marshal(names)
+	if err != nil {
+		return err
+	}
+	// Write and rename so a crash never leaves a truncated state file.
+	if err := ioutil.WriteFile(w.StateFile+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(w.StateFile+".tmp", w.StateFile)
+}
+
+// persist saves the state, logging failures. The state is saved again on
+// the next delivery or poll.
+func (w *Webhook) persist() {
+	if err := w.saveState(); err != nil {
+		log.WithFields(log.Fields{
+			"stateFile":     w.StateFile,
+			"internalError": err,
+		}).Warn("Failed saving webhook state.")
+	}
+}
+
+// Sign returns the hex encoded HMAC-SHA256 of payload.
+func (w *Webhook) Sign(payload []byte) string {
+	mac := hmac.New(sha256.New, w.Secret)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (w *Webhook) post(ctx context.Context, payload []byte) error {
+	request, err := http.NewRequest("POST", w.Url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	request = request.WithContext(ctx)
+	request.Header.Set("Content-Type", "application/json")
+	if len(w.Secret) > 0 {
+		request.Header.Set("X-Filebrowser-Signature", "sha256="+w.Sign(payload))
+	}
+	response, err := w.Client.Do(request)
+	if err != nil {
+		return err
+	}
+	response.Body.Close()
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("webhook returned %s", response.Status)
+	}
+	return nil
+}
+
+// Deliver posts payload, retrying with exponential backoff until ctx is done.
+func (w *Webhook) Deliver(ctx context.Context, payload WebhookPayload) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		err = w.post(ctx, data)
+		if err == nil || attempt == webhookAttempts {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"objectName":    payload.Name,
+			"attempt":       attempt,
+			"internalError": err,
+		}).Info("Webhook delivery failed, retrying.")
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return err
+		}
+		backoff *= 2
+	}
+}
+
+// pollWebhook delivers a notification for every object not seen before,
+// saving the state after each delivery. Objects whose delivery failed, or
+// that weren't tried before ctx was done, stay unseen and are retried on the
+// next poll.
+func (s *Server) pollWebhook(ctx context.Context, w *Webhook, items []*storage.Object) {
+	present := make(map[string]bool, len(items))
+	for _, item := range items {
+		present[item.Name] = true
+		if w.seen[item.Name] || ctx.Err() != nil {
+			continue
+		}
+		err := w.Deliver(ctx, WebhookPayload{
+			Name: item.Name,
+			Size: item.Size,
+			Url:  s.SignObject(item),
+		})
+		if err != nil {
+			log.WithFields(log.Fields{
+				"objectName":    item.Name,
+				"internalError": err,
+			}).Warn("Failed delivering webhook.")
+			continue
+		}
+		w.seen[item.Name] = true
+		w.persist()
+	}
+	// Forget deleted objects so the state doesn't grow forever.
+	for name := range w.seen {
+		if !present[name] {
+			delete(w.seen, name)
+		}
+	}
+}
+
+// RunWebhook polls the bucket every interval, forever. Without a state file
+// the objects present at startup are recorded as seen instead of notified.
+func (s *Server) RunWebhook(w *Webhook, interval time.Duration) {
+	if err := w.loadState(); err != nil {
+		log.WithFields(log.Fields{
+			"stateFile":     w.StateFile,
+			"internalError": err,
+		}).Info("No webhook state, only objects created from now on are notified.")
+		w.seen = nil
+	}
+	for {
+		items, err := s.ListObjects(context.Background(), "")
+		if err != nil {
+			log.WithFields(log.Fields{
+				"internalError": err,
+			}).Warn("Failed listing objects for webhook.")
+		} else {
+			if w.seen == nil {
+				w.seen = make(map[string]bool, len(items))
+				for _, item := range items {
+					w.seen[item.Name] = true
+				}
+			} else {
+				// A failing endpoint mustn't hold up the next poll.
+				ctx, cancel := context.WithTimeout(context.Background(), interval)
+				s.pollWebhook(ctx, w, items)
+				cancel()
+			}
+			w.persist()
+		}
+		time.Sleep(interval)
+	}
+}